@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/zouy414/docker-volume-plugin/pkg/adapters"
+	"github.com/zouy414/docker-volume-plugin/pkg/cli"
 	"github.com/zouy414/docker-volume-plugin/pkg/log"
 
 	"github.com/docker/go-connections/sockets"
@@ -17,17 +18,27 @@ func main() {
 	var unixEndpoint string
 	var driver string
 	var driverOptions string
+	var admissionPolicy string
 
 	// Parse flags
 	flag.StringVar(&logLevel, "log-level", os.Getenv("LOG_LEVEL"), "set the log level (debug, info, warn, error)")
 	flag.StringVar(&unixEndpoint, "unit-endpoint", os.Getenv("UNIX_ENDPOINT"), "specify a UNIX endpoint to listen on")
 	flag.StringVar(&driver, "driver", os.Getenv("DRIVER"), "specify a driver to use")
 	flag.StringVar(&driverOptions, "driver-options", os.Getenv("DRIVER_OPTIONS"), "specify a json string of driver options")
+	flag.StringVar(&admissionPolicy, "admission-policy", os.Getenv("ADMISSION_POLICY"), "specify a json string of admission policy of volume creation")
 	flag.Parse()
 
 	// Create logger
 	var logger = log.NewWithLogLevel("main", log.StringToLogLevel(logLevel))
 
+	// Run administrative command instead of serving the plugin if specified
+	if flag.NArg() != 0 {
+		if err := cli.Run(logger, os.Stdout, flag.Args()); err != nil {
+			logger.Fatalf("failed to run command %s: %v", flag.Arg(0), err)
+		}
+		return
+	}
+
 	// Create driver adapter
 	driverAdapter, err := adapters.NewVolumePlugin(
 		context.Background(),
@@ -35,6 +46,7 @@ func main() {
 		driver,
 		volume.DefaultDockerRootDirectory,
 		driverOptions,
+		admissionPolicy,
 	)
 	if err != nil {
 		logger.Fatalf("failed to create docker volume plugin adapter: %v", err)
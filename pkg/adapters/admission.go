@@ -0,0 +1,207 @@
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+// AdmissionPolicy validates the create requests before they reach the driver.
+type AdmissionPolicy struct {
+	// Rules are all applied to the volumes whose names they select
+	Rules []*AdmissionRule `json:"rules,omitempty"`
+}
+
+// AdmissionRule restricts the create requests of the volumes whose names start with the prefix.
+type AdmissionRule struct {
+	// Name of the rule displayed in the rejections, default to its index
+	Name string `json:"name,omitempty"`
+
+	// Prefix selects the volume names the rule applies to, empty means all volumes
+	Prefix string `json:"prefix,omitempty"`
+
+	// NamePattern is a regular expression the whole volume name must match
+	NamePattern string `json:"namePattern,omitempty"`
+
+	// RequiredLabels must be set by the label options
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+
+	// MaxQuota is the maximum quota option, zero means no limit
+	MaxQuota apis.Size `json:"maxQuota,omitempty"`
+
+	// ForbiddenOptions can't be specified
+	ForbiddenOptions []string `json:"forbiddenOptions,omitempty"`
+
+	// StorageClasses are the storage classes the volumes must be created with, empty means any
+	StorageClasses []string `json:"storageClasses,omitempty"`
+
+	// MaxVolumes is the maximum number of volumes whose names start with the prefix, zero means no limit
+	MaxVolumes int `json:"maxVolumes,omitempty"`
+
+	namePattern *regexp.Regexp
+}
+
+// NewAdmissionPolicy parses the admission policy from a json string, an empty string admits everything.
+func NewAdmissionPolicy(admissionPolicy string) (*AdmissionPolicy, error) {
+	policy := &AdmissionPolicy{}
+	if admissionPolicy == "" {
+		return policy, nil
+	}
+
+	if err := json.Unmarshal([]byte(admissionPolicy), policy); err != nil {
+		return nil, fmt.Errorf("failed to parse admission policy: %v", err)
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprint(i)
+		}
+		if rule.NamePattern != "" {
+			namePattern, err := regexp.Compile("^(?:" + rule.NamePattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid name pattern of admission rule %s: %v", rule.Name, err)
+			}
+			rule.namePattern = namePattern
+		}
+		for _, key := range rule.RequiredLabels {
+			if err := apis.ValidateLabelKey(key); err != nil {
+				return nil, fmt.Errorf("invalid required label of admission rule %s: %v", rule.Name, err)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// Admit validates the create request of the volume, existing is true if the volume already exists, resolve applies the defaults of the driver
+// to the spec options of a new volume and volumes are the names of all volumes. An update request of an existing volume is only validated
+// against the options, since its name and labels were admitted on creation, and a volume restored from the trash or a backup keeps its spec,
+// so only its name is validated.
+func (policy *AdmissionPolicy) Admit(name string, options map[string]string, existing bool, resolve func(map[string]string) (map[string]string, error),
+	volumes func() ([]string, error)) error {
+	createOptions := &apis.CreateOptions{}
+	specOptions, err := createOptions.Unmarshal(options)
+	if err != nil {
+		return err
+	}
+	if existing && !createOptions.Update {
+		// Creating an existing volume doesn't change it
+		return nil
+	}
+
+	restoring := createOptions.RestoreTrash != "" || createOptions.RestoreFrom != ""
+	var resolvedOptions map[string]string
+	for _, rule := range policy.Rules {
+		if !strings.HasPrefix(name, rule.Prefix) {
+			continue
+		}
+
+		if err := rule.admitOptions(options); err != nil {
+			return fmt.Errorf("volume %s is rejected by admission rule %s: %v", name, rule.Name, err)
+		}
+		if existing {
+			// The quota of an existing volume only changes if the option is specified
+			if value, existed := specOptions["quota"]; existed {
+				if err := rule.admitQuota(value); err != nil {
+					return fmt.Errorf("volume %s is rejected by admission rule %s: %v", name, rule.Name, err)
+				}
+			}
+			continue
+		}
+
+		if !restoring && resolvedOptions == nil && rule.MaxQuota != 0 {
+			resolvedOptions, err = resolve(specOptions)
+			if err != nil {
+				return err
+			}
+		}
+		if err := rule.admitVolume(name, specOptions, resolvedOptions, restoring, volumes); err != nil {
+			return fmt.Errorf("volume %s is rejected by admission rule %s: %v", name, rule.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// admitOptions validates the options of a create or update request, including the create directives.
+func (rule *AdmissionRule) admitOptions(options map[string]string) error {
+	for key := range options {
+		if slices.Contains(rule.ForbiddenOptions, key) {
+			return fmt.Errorf("option %s is forbidden", key)
+		}
+	}
+	return nil
+}
+
+// admitQuota validates the quota option, an empty value means the volume has no quota.
+func (rule *AdmissionRule) admitQuota(value string) error {
+	if rule.MaxQuota == 0 {
+		return nil
+	}
+
+	quota := apis.Size(0)
+	if value != "" {
+		var err error
+		quota, err = apis.ParseSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for quota: %v", err)
+		}
+	}
+	if quota == 0 {
+		return fmt.Errorf("unlimited quota exceeds the maximum %s", rule.MaxQuota)
+	}
+	if quota > rule.MaxQuota {
+		return fmt.Errorf("quota %s exceeds the maximum %s", quota, rule.MaxQuota)
+	}
+	return nil
+}
+
+// admitVolume validates the name and spec of a new volume, the resolved options are the spec options with the defaults of the driver,
+// the spec of a restored volume is not validated.
+func (rule *AdmissionRule) admitVolume(name string, specOptions map[string]string, resolvedOptions map[string]string, restoring bool,
+	volumes func() ([]string, error)) error {
+	if rule.namePattern != nil && !rule.namePattern.MatchString(name) {
+		return fmt.Errorf("name doesn't match the pattern %s", rule.NamePattern)
+	}
+
+	if !restoring {
+		labels := apis.LabelsFromOptions(specOptions)
+		for _, key := range rule.RequiredLabels {
+			if _, existed := labels[key]; !existed {
+				return fmt.Errorf("label %s is required, set it by the option %s%s=<value>", key, apis.LabelOptionPrefix, key)
+			}
+		}
+
+		if len(rule.StorageClasses) != 0 && !slices.Contains(rule.StorageClasses, specOptions["storageClass"]) {
+			return fmt.Errorf("storageClass must be one of %s", strings.Join(rule.StorageClasses, ", "))
+		}
+
+		// The quota may come from a label policy or storage class
+		if err := rule.admitQuota(resolvedOptions["quota"]); err != nil {
+			return err
+		}
+	}
+
+	if rule.MaxVolumes != 0 {
+		names, err := volumes()
+		if err != nil {
+			return fmt.Errorf("failed to count volumes: %v", err)
+		}
+
+		count := 0
+		for _, volume := range names {
+			if strings.HasPrefix(volume, rule.Prefix) {
+				count++
+			}
+		}
+		if count >= rule.MaxVolumes {
+			return fmt.Errorf("the number of volumes with prefix %q reaches the maximum %d", rule.Prefix, rule.MaxVolumes)
+		}
+	}
+
+	return nil
+}
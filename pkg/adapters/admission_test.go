@@ -0,0 +1,134 @@
+package adapters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmissionPolicy(t *testing.T) {
+	_, err := NewAdmissionPolicy(`{"rules": [{"namePattern": "("}]}`)
+	assert.Error(t, err)
+	_, err = NewAdmissionPolicy(`{"rules": [{"requiredLabels": ["-invalid"]}]}`)
+	assert.Error(t, err)
+
+	policy, err := NewAdmissionPolicy(`{"rules": [
+		{"name": "names", "namePattern": "[a-z][a-z0-9-]*", "forbiddenOptions": ["adopt"]},
+		{"name": "teams", "prefix": "team-", "requiredLabels": ["owner"], "maxQuota": "10Gi"},
+		{"name": "databases", "prefix": "db-", "storageClasses": ["database"], "maxVolumes": 2}
+	]}`)
+	assert.NoError(t, err)
+
+	volumes := func() ([]string, error) {
+		return []string{"db-a", "db-b", "team-a"}, nil
+	}
+	resolve := func(options map[string]string) (map[string]string, error) {
+		classes := map[string]string{"small": "5Gi", "large": "20Gi"}
+		resolved := map[string]string{}
+		if quota, existed := classes[options["storageClass"]]; existed {
+			resolved["quota"] = quota
+		}
+		for key, value := range options {
+			resolved[key] = value
+		}
+		return resolved, nil
+	}
+	cases := []struct {
+		description string
+		name        string
+		options     map[string]string
+		existing    bool
+		expectErr   string
+	}{
+		{
+			description: "admitted",
+			name:        "team-b",
+			options:     map[string]string{"label.owner": "b", "quota": "5Gi"},
+		},
+		{
+			description: "invalid name",
+			name:        "Invalid_Name",
+			expectErr:   "volume Invalid_Name is rejected by admission rule names: name doesn't match the pattern [a-z][a-z0-9-]*",
+		},
+		{
+			description: "forbidden option",
+			name:        "test",
+			options:     map[string]string{"adopt": "true"},
+			expectErr:   "volume test is rejected by admission rule names: option adopt is forbidden",
+		},
+		{
+			description: "missing label",
+			name:        "team-b",
+			expectErr:   "volume team-b is rejected by admission rule teams: label owner is required, set it by the option label.owner=<value>",
+		},
+		{
+			description: "quota exceeds maximum",
+			name:        "team-b",
+			options:     map[string]string{"label.owner": "b", "quota": "20Gi"},
+			expectErr:   "volume team-b is rejected by admission rule teams: quota 20Gi exceeds the maximum 10Gi",
+		},
+		{
+			description: "missing quota",
+			name:        "team-b",
+			options:     map[string]string{"label.owner": "b"},
+			expectErr:   "volume team-b is rejected by admission rule teams: unlimited quota exceeds the maximum 10Gi",
+		},
+		{
+			description: "quota of storage class",
+			name:        "team-b",
+			options:     map[string]string{"label.owner": "b", "storageClass": "small"},
+		},
+		{
+			description: "quota of storage class exceeds maximum",
+			name:        "team-b",
+			options:     map[string]string{"label.owner": "b", "storageClass": "large"},
+			expectErr:   "volume team-b is rejected by admission rule teams: quota 20Gi exceeds the maximum 10Gi",
+		},
+		{
+			description: "update of existing volume only validates options",
+			name:        "team-a",
+			options:     map[string]string{"update": "true", "quota": "20Gi"},
+			existing:    true,
+			expectErr:   "volume team-a is rejected by admission rule teams: quota 20Gi exceeds the maximum 10Gi",
+		},
+		{
+			description: "update of existing volume without labels",
+			name:        "team-a",
+			options:     map[string]string{"update": "true", "quota": "1Gi"},
+			existing:    true,
+		},
+		{
+			description: "create of existing volume is ignored",
+			name:        "db-a",
+			existing:    true,
+		},
+		{
+			description: "storage class not allowed",
+			name:        "db-c",
+			options:     map[string]string{"storageClass": "scratch"},
+			expectErr:   "volume db-c is rejected by admission rule databases: storageClass must be one of database",
+		},
+		{
+			description: "too many volumes",
+			name:        "db-c",
+			options:     map[string]string{"storageClass": "database"},
+			expectErr:   `volume db-c is rejected by admission rule databases: the number of volumes with prefix "db-" reaches the maximum 2`,
+		},
+		{
+			description: "restored volume keeps its spec",
+			name:        "team-c",
+			options:     map[string]string{"restoreTrash": "team-c-20261018T000000Z"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			err := policy.Admit(c.name, c.options, c.existing, resolve, volumes)
+			if c.expectErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, c.expectErr)
+			}
+		})
+	}
+}
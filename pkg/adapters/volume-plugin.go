@@ -16,11 +16,17 @@ type VolumePlugin struct {
 	driverInstance  apis.Driver
 	logger          *log.Logger
 	propagatedMount string
+	admissionPolicy *AdmissionPolicy
 	volume.Driver
 }
 
-// NewVolumePlugin creates a new VolumePlugin instance with the specified driver and options.
-func NewVolumePlugin(ctx context.Context, logger *log.Logger, driver string, propagatedMount string, driverOptions string) (*VolumePlugin, error) {
+// NewVolumePlugin creates a new VolumePlugin instance with the specified driver and options, the create requests are validated by the admission policy.
+func NewVolumePlugin(ctx context.Context, logger *log.Logger, driver string, propagatedMount string, driverOptions string, admissionPolicy string) (*VolumePlugin, error) {
+	policy, err := NewAdmissionPolicy(admissionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
 	driverInstance, err := drivers.New(ctx, logger.WithService(driver), driver, propagatedMount, driverOptions)
 	if err != nil {
 		return nil, err
@@ -30,14 +36,46 @@ func NewVolumePlugin(ctx context.Context, logger *log.Logger, driver string, pro
 		driverInstance:  driverInstance,
 		logger:          logger,
 		propagatedMount: propagatedMount,
+		admissionPolicy: policy,
 	}, nil
 }
 
 func (d *VolumePlugin) Create(req *volume.CreateRequest) error {
 	d.logger.Debugf("creating volume %s with options %v", req.Name, req.Options)
+
+	metadata, err := d.driverInstance.Get(req.Name)
+	existing := err == nil && metadata != nil
+	err = d.admissionPolicy.Admit(req.Name, req.Options, existing, d.resolveSpecOptions, d.listVolumeNames)
+	if err != nil {
+		d.logger.Warningf("rejected creating volume %s: %v", req.Name, err)
+		return err
+	}
+
 	return d.driverInstance.Create(req.Name, req.Options)
 }
 
+// resolveSpecOptions applies the defaults of the driver to the spec options of a new volume.
+func (d *VolumePlugin) resolveSpecOptions(options map[string]string) (map[string]string, error) {
+	if resolver, ok := d.driverInstance.(apis.SpecResolver); ok {
+		return resolver.ResolveSpecOptions(options)
+	}
+	return options, nil
+}
+
+// listVolumeNames lists the names of all volumes.
+func (d *VolumePlugin) listVolumeNames() ([]string, error) {
+	volumeMetadataMap, err := d.driverInstance.List()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(volumeMetadataMap))
+	for name := range volumeMetadataMap {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
 func (d *VolumePlugin) List() (*volume.ListResponse, error) {
 	d.logger.Debug("listing all volumes")
 
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/backup"
+)
+
+func init() {
+	registerCommand("backup", &command{
+		description: "Back up one or more volumes to archives in the backup target, or list the archives",
+		run:         backupVolumes,
+	})
+	registerCommand("restore", &command{
+		description: "Restore a volume from an archive in the backup target",
+		run:         restoreVolume,
+	})
+	registerCommand("jobs", &command{
+		description: "List the history of the backup jobs run by the backup schedules",
+		run:         listJobs,
+	})
+	registerCommand("verify", &command{
+		description: "Verify the chunks in a backup repository by re-hashing them",
+		run:         verifyRepository,
+	})
+}
+
+// addBackupTargetFlag adds the flag of the backup target, which takes the backup driver option.
+func addBackupTargetFlag(flagSet *flag.FlagSet) *string {
+	return flagSet.String("target", "", `specify the backup target in the format of the backup driver option, e.g. {"directory": "/backups"}`)
+}
+
+// openBackupTarget opens the backup target of the flag.
+func openBackupTarget(targetFlag string) (backup.Target, error) {
+	opts := &backup.Options{}
+	if err := json.Unmarshal([]byte(targetFlag), opts); err != nil {
+		return nil, fmt.Errorf("failed to parse backup target: %v", err)
+	}
+	return backup.NewTarget(opts)
+}
+
+func backupVolumes(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("backup")
+	targetFlag := addBackupTargetFlag(flagSet)
+	quiesce := flagSet.Bool("quiesce", false, "hold the metadata lock of each volume during its backup, so it can't be updated or removed meanwhile")
+	list := flagSet.Bool("list", false, "list the archives in the backup target instead of backing up")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	target, err := openBackupTarget(*targetFlag)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		archives, err := backup.ListArchives(target)
+		if err != nil {
+			return fmt.Errorf("failed to list backup archives: %v", err)
+		}
+		for _, archive := range archives {
+			if flagSet.NArg() == 0 || slices.Contains(flagSet.Args(), archive.Volume) {
+				_, _ = fmt.Fprintln(env.stdout, archive.Name)
+			}
+		}
+		return nil
+	}
+
+	if flagSet.NArg() == 0 {
+		return fmt.Errorf("at least one volume name is required")
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	for _, name := range flagSet.Args() {
+		archive, manifest, err := backup.Backup(s, target, name, *quiesce)
+		if err != nil {
+			return fmt.Errorf("failed to back up volume %s: %v", name, err)
+		}
+		env.logger.Infof("backed up %d files of volume %s", len(manifest.Files), name)
+		_, _ = fmt.Fprintln(env.stdout, archive)
+	}
+
+	return nil
+}
+
+func restoreVolume(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("restore")
+	targetFlag := addBackupTargetFlag(flagSet)
+	from := flagSet.String("from", "", "specify the archive to restore the volume from")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		return fmt.Errorf("exactly one volume name is required")
+	}
+	if *from == "" {
+		return fmt.Errorf("the archive to restore from is required")
+	}
+
+	target, err := openBackupTarget(*targetFlag)
+	if err != nil {
+		return err
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	name := flagSet.Arg(0)
+	if _, err := backup.Restore(s, target, *from, name); err != nil {
+		return fmt.Errorf("failed to restore volume %s: %v", name, err)
+	}
+	_, _ = fmt.Fprintln(env.stdout, name)
+
+	return nil
+}
+
+func verifyRepository(env *environment, args []string) error {
+	flagSet := flag.NewFlagSet("verify", flag.ContinueOnError)
+	targetFlag := addBackupTargetFlag(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	target, err := openBackupTarget(*targetFlag)
+	if err != nil {
+		return err
+	}
+	repository, ok := target.(*backup.Repository)
+	if !ok {
+		return fmt.Errorf("only backup repositories can be verified")
+	}
+
+	result, err := repository.Verify()
+	if err != nil {
+		return fmt.Errorf("failed to verify backup repository: %v", err)
+	}
+	for _, problem := range result.Problems {
+		_, _ = fmt.Fprintln(env.stdout, problem)
+	}
+	_, _ = fmt.Fprintf(env.stdout, "verified %d chunks of %d archives, found %d problems\n", result.Chunks, result.Archives, len(result.Problems))
+	if len(result.Problems) > 0 {
+		return fmt.Errorf("backup repository is damaged")
+	}
+	return nil
+}
+
+func listJobs(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("jobs")
+	schedule := flagSet.String("schedule", "", "only list the jobs of the backup schedule")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	states, err := backup.ReadScheduleStates(storageFlags.rootPath)
+	if err != nil {
+		return err
+	}
+
+	records := []*backup.JobRecord{}
+	for name, state := range states {
+		if *schedule == "" || name == *schedule {
+			records = append(records, state.History...)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartedAt.Before(records[j].StartedAt)
+	})
+
+	writer := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "SCHEDULE\tVOLUME\tNODE\tSTARTED AT\tDURATION\tRESULT")
+	for _, record := range records {
+		result := record.Archive
+		if record.Error != "" {
+			result = "failed: " + record.Error
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", record.Schedule, record.Volume, record.Node,
+			record.StartedAt.Local().Format(time.RFC3339), record.FinishedAt.Sub(record.StartedAt).Round(time.Millisecond), result)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// command is an administrative subcommand which operates directly on a storage root, without docker.
+type command struct {
+	// description is displayed in the usage
+	description string
+
+	// run executes the command with its arguments
+	run func(env *environment, args []string) error
+}
+
+// environment is passed to the commands when they run.
+type environment struct {
+	logger *log.Logger
+	stdout io.Writer
+}
+
+var commands map[string]*command = map[string]*command{}
+
+// registerCommand to register command
+func registerCommand(name string, cmd *command) {
+	commands[name] = cmd
+}
+
+// Run the subcommand named by the first argument with the remaining arguments
+func Run(logger *log.Logger, stdout io.Writer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command specified, supported commands:\n%s", usage())
+	}
+
+	cmd := commands[args[0]]
+	if cmd == nil {
+		return fmt.Errorf("command %s is invalid, supported commands:\n%s", args[0], usage())
+	}
+
+	return cmd.run(&environment{logger: logger.WithService(args[0]), stdout: stdout}, args[1:])
+}
+
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var text string
+	for _, name := range names {
+		text += fmt.Sprintf("    %-10s %s\n", name, commands[name].description)
+	}
+	return text
+}
+
+// storageFlags are the flags of a command to open the storage.
+type storageFlags struct {
+	rootPath      string
+	metadataStore storage.MetadataStoreOptions
+	nameMapping   storage.NameMappingOptions
+}
+
+// newFlagSet creates the flag set of a command with the flags of storage root path, metadata store and name mapping.
+func newFlagSet(name string) (*flag.FlagSet, *storageFlags) {
+	flags := &storageFlags{}
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.StringVar(&flags.rootPath, "root", volume.DefaultDockerRootDirectory, "specify the root path of volumes, e.g. the mounted share")
+	flagSet.StringVar(&flags.metadataStore.Type, "metadata-store", "file", "specify the metadata store of the volumes (file, bolt)")
+	flagSet.StringVar(&flags.metadataStore.Path, "metadata-store-path", "", "specify the database file of the bolt metadata store, default to .metadata.db in the root path")
+	flagSet.BoolVar(&flags.metadataStore.SingleNode, "metadata-store-single-node", false, "specify whether the database file outside the root path is only used by this node")
+	flagSet.StringVar(&flags.nameMapping.Mode, "name-mapping", "none", "specify how the volume names are mapped to the volume directories (none, escape, hash)")
+	flagSet.BoolVar(&flags.nameMapping.CaseInsensitive, "case-insensitive", false, "specify whether the root path is on a case-insensitive file system, e.g. a CIFS share")
+	return flagSet, flags
+}
+
+// openStorage opens the storage at the root path with the metadata store.
+func (env *environment) openStorage(flags *storageFlags) (*storage.Builtin, error) {
+	logger := env.logger.WithService("storage")
+	store, err := storage.NewMetadataStore(logger, flags.rootPath, &flags.metadataStore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata store: %v", err)
+	}
+
+	s := storage.NewBuiltinWithMetadataStore(logger, flags.rootPath, store)
+	if err := s.SetNameMapping(&flags.nameMapping); err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("invalid name mapping: %v", err)
+	}
+	return s, nil
+}
+
+// optionsFlag collects repeated key=value flags into options.
+type optionsFlag map[string]string
+
+func (f optionsFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f optionsFlag) Set(value string) error {
+	key, val, found := strings.Cut(value, "=")
+	if !found || key == "" {
+		return fmt.Errorf("option %s should be in key=value format", value)
+	}
+	f[key] = val
+	return nil
+}
+
+// volumeInspection is the inspection output of a volume.
+type volumeInspection struct {
+	Name string `json:"name"`
+	*apis.VolumeMetadata
+}
+
+// sortedNames returns the volume names of the metadata map in order.
+func sortedNames(volumeMetadataMap map[string]*apis.VolumeMetadata) []string {
+	names := make([]string, 0, len(volumeMetadataMap))
+	for name := range volumeMetadataMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// closeStorage closes the storage opened by the command.
+func (env *environment) closeStorage(s *storage.Builtin) {
+	if err := s.Close(); err != nil {
+		env.logger.Errorf("failed to close storage: %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/replication"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	logger := log.New("test")
+	stdout := &bytes.Buffer{}
+
+	err := Run(logger, stdout, []string{})
+	assert.Error(t, err)
+
+	err = Run(logger, stdout, []string{"invalid-command"})
+	assert.Error(t, err)
+
+	err = Run(logger, stdout, []string{"orphans", "-invalid-flag"})
+	assert.Error(t, err)
+}
+
+func TestOrphans(t *testing.T) {
+	logger := log.New("test")
+	rootPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(rootPath, "orphan", "_data"), 0755))
+
+	stdout := &bytes.Buffer{}
+	err := Run(logger, stdout, []string{"orphans", "-root", rootPath})
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "orphan")
+
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"orphans", "-root", rootPath, "-older-than", "1d", "-purge"})
+	assert.NoError(t, err)
+	assert.Empty(t, stdout.String())
+
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"orphans", "-root", rootPath, "-purge"})
+	assert.NoError(t, err)
+	assert.Equal(t, "purged orphan\n", stdout.String())
+	assert.NoDirExists(t, path.Join(rootPath, "orphan"))
+}
+
+func TestFsck(t *testing.T) {
+	logger := log.New("test")
+	rootPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(rootPath, "missing-data"), 0755))
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, "missing-data", "_metadata.json"), []byte(`{"createAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"missing-data/_data"}}`), 0644))
+
+	stdout := &bytes.Buffer{}
+	err := Run(logger, stdout, []string{"fsck", "-root", rootPath, "-dry-run"})
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "recreate empty data directory")
+	assert.NoDirExists(t, path.Join(rootPath, "missing-data", "_data"))
+
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"fsck", "-root", rootPath, "-repair"})
+	assert.NoError(t, err)
+	assert.DirExists(t, path.Join(rootPath, "missing-data", "_data"))
+}
+
+func TestVolumeCommands(t *testing.T) {
+	logger := log.New("test")
+	rootPath := t.TempDir()
+	stdout := &bytes.Buffer{}
+
+	// Test create
+	err := Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "purgeAfterDelete=true", "test"})
+	assert.NoError(t, err)
+	err = Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "invalid", "test"})
+	assert.Error(t, err)
+	err = Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "unknown=true", "test"})
+	assert.Error(t, err)
+
+	// Test list
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"list", "-root", rootPath, "-q"})
+	assert.NoError(t, err)
+	assert.Equal(t, "test\n", stdout.String())
+
+	// Test inspect
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"inspect", "-root", rootPath, "test"})
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), `"purgeAfterDelete": true`)
+	err = Run(logger, stdout, []string{"inspect", "-root", rootPath, "non-exist"})
+	assert.Error(t, err)
+
+	// Test list by selector
+	err = Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "label.owner=team-a", "labeled"})
+	assert.NoError(t, err)
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"list", "-root", rootPath, "-q", "-selector", "owner=team-a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "labeled\n", stdout.String())
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"list", "-root", rootPath, "-q", "-selector", "!owner"})
+	assert.NoError(t, err)
+	assert.Equal(t, "test\n", stdout.String())
+	err = Run(logger, stdout, []string{"list", "-root", rootPath, "-selector", "=invalid"})
+	assert.Error(t, err)
+	err = Run(logger, stdout, []string{"rm", "-root", rootPath, "labeled"})
+	assert.NoError(t, err)
+
+	// Test update
+	err = Run(logger, stdout, []string{"update", "-root", rootPath, "-o", "quota=10Gi", "-o", "readOnly=true", "test"})
+	assert.NoError(t, err)
+	err = Run(logger, stdout, []string{"update", "-root", rootPath, "-o", "unknown=true", "test"})
+	assert.Error(t, err)
+	err = Run(logger, stdout, []string{"update", "-root", rootPath, "-o", "quota=1Gi", "non-exist"})
+	assert.Error(t, err)
+
+	// Test export
+	output := path.Join(t.TempDir(), "export.json")
+	err = Run(logger, stdout, []string{"export", "-root", rootPath, "-output", output})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"name": "test"`)
+	assert.Contains(t, string(data), `"quota": "10Gi"`)
+
+	// Test rm
+	err = Run(logger, stdout, []string{"rm", "-root", rootPath, "test"})
+	assert.NoError(t, err)
+	err = Run(logger, stdout, []string{"rm", "-root", rootPath, "test"})
+	assert.Error(t, err)
+	assert.NoDirExists(t, path.Join(rootPath, "test"))
+	entries, err := os.ReadDir(path.Join(rootPath, ".trash"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	err = Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "purgeAfterDelete=true", "purged"})
+	assert.NoError(t, err)
+	err = Run(logger, stdout, []string{"rm", "-root", rootPath, "-trash-retention", "invalid", "purged"})
+	assert.Error(t, err)
+	err = Run(logger, stdout, []string{"rm", "-root", rootPath, "-trash-retention", "0s", "purged"})
+	assert.NoError(t, err)
+	assert.NoDirExists(t, path.Join(rootPath, "purged"))
+	entries, err = os.ReadDir(path.Join(rootPath, ".trash"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestMigrate(t *testing.T) {
+	logger := log.New("test")
+	rootPath := t.TempDir()
+	for name, metadata := range map[string]string{
+		"v0":     `{"createAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"v0/_data"}}`,
+		"v1":     `{"schemaVersion":1,"createdAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"v1/_data"}}`,
+		"future": `{"schemaVersion":65535,"createdAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"future/_data"}}`,
+	} {
+		assert.NoError(t, os.MkdirAll(path.Join(rootPath, name, "_data"), 0755))
+		assert.NoError(t, os.WriteFile(path.Join(rootPath, name, "_metadata.json"), []byte(metadata), 0644))
+	}
+	assert.NoError(t, os.MkdirAll(path.Join(rootPath, "team-a", "v0", "_data"), 0755))
+	assert.NoError(t, storage.MarkNamespace(path.Join(rootPath, "team-a")))
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, "team-a", "v0", "_metadata.json"), []byte(`{"createAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"v0/_data"}}`), 0644))
+	assert.NoError(t, os.Mkdir(path.Join(rootPath, "unknown"), 0755))
+
+	// Test dry run
+	stdout := &bytes.Buffer{}
+	err := Run(logger, stdout, []string{"migrate", "-root", rootPath, "-dry-run"})
+	assert.Error(t, err)
+	assert.Equal(t, "would migrate v0 from schema version 0 to 1\nwould migrate team-a/v0 from schema version 0 to 1\n", stdout.String())
+
+	// Test migrate
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"migrate", "-root", rootPath})
+	assert.Error(t, err)
+	assert.Equal(t, "migrated v0 from schema version 0 to 1\nmigrated team-a/v0 from schema version 0 to 1\n", stdout.String())
+	for _, name := range []string{"v0", "team-a/v0"} {
+		data, err := os.ReadFile(path.Join(rootPath, name, "_metadata.json"))
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"schemaVersion":1,"createdAt":"2026-10-18T00:00:00Z"`)
+	}
+
+	// The directories which aren't volumes are left untouched
+	assert.NoFileExists(t, path.Join(rootPath, "unknown", "_metadata.json.lock"))
+	assert.NoFileExists(t, path.Join(rootPath, "team-a", "_metadata.json.lock"))
+
+	// Test migrate again
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"migrate", "-root", rootPath})
+	assert.Error(t, err)
+	assert.Empty(t, stdout.String())
+}
+
+func TestBackupCommands(t *testing.T) {
+	logger := log.New("test")
+	rootPath := t.TempDir()
+	target := fmt.Sprintf(`{"directory": %q}`, t.TempDir())
+	stdout := &bytes.Buffer{}
+
+	err := Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "quota=1Gi", "test"})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, "test", "_data", "file"), []byte("data"), 0644))
+
+	// Test backup
+	err = Run(logger, stdout, []string{"backup", "-root", rootPath, "test"})
+	assert.Error(t, err)
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"backup", "-root", rootPath, "-target", target, "-quiesce", "test"})
+	assert.NoError(t, err)
+	archive := strings.TrimSpace(stdout.String())
+	assert.True(t, strings.HasPrefix(archive, "test-"))
+
+	// Test backup list
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"backup", "-target", target, "-list"})
+	assert.NoError(t, err)
+	assert.Equal(t, archive+"\n", stdout.String())
+
+	// Test restore
+	err = Run(logger, stdout, []string{"restore", "-root", rootPath, "-target", target, "restored"})
+	assert.Error(t, err)
+	err = Run(logger, stdout, []string{"restore", "-root", rootPath, "-target", target, "-from", archive, "restored"})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path.Join(rootPath, "restored", "_data", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	err = Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "restoreFrom=" + archive, "other"})
+	assert.Error(t, err)
+
+	// Test verify
+	err = Run(logger, stdout, []string{"verify", "-target", target})
+	assert.Error(t, err)
+	repository := fmt.Sprintf(`{"repository": %q}`, t.TempDir())
+	err = Run(logger, stdout, []string{"backup", "-root", rootPath, "-target", repository, "test"})
+	assert.NoError(t, err)
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"verify", "-target", repository})
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "of 1 archives, found 0 problems")
+	err = Run(logger, stdout, []string{"verify", "-root", rootPath, "-target", repository})
+	assert.Error(t, err)
+
+	// Test jobs
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"jobs", "-root", rootPath})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(stdout.String(), "\n"))
+	assert.NoError(t, os.MkdirAll(path.Join(rootPath, ".scheduler"), 0755))
+	state := `{"history": [{"schedule": "daily", "volume": "test", "node": "node-a", "archive": "test-20260101T000000Z.tar.zst"},
+		{"schedule": "daily", "volume": "restored", "node": "node-a", "error": "no space left on device"}]}`
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, ".scheduler", "daily.json"), []byte(state), 0644))
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"jobs", "-root", rootPath, "-schedule", "daily"})
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "test-20260101T000000Z.tar.zst")
+	assert.Contains(t, stdout.String(), "failed: no space left on device")
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"jobs", "-root", rootPath, "-schedule", "hourly"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(stdout.String(), "\n"))
+}
+
+func TestMigrateVolumeCommand(t *testing.T) {
+	logger := log.New("test")
+	sourcePath, targetPath := t.TempDir(), t.TempDir()
+	stdout := &bytes.Buffer{}
+
+	err := Run(logger, stdout, []string{"create", "-root", sourcePath, "-o", "quota=1Gi", "test"})
+	assert.NoError(t, err)
+	err = Run(logger, stdout, []string{"create", "-root", sourcePath, "other"})
+	assert.NoError(t, err)
+
+	flags := []string{"migrate-volume", "-from-driver", "nfs", "-from-options", `{"mock": true}`, "-from-mountpoint", sourcePath,
+		"-to-driver", "nfs", "-to-options", `{"mock": true}`, "-to-mountpoint", targetPath}
+	err = Run(logger, stdout, flags)
+	assert.Error(t, err)
+	err = Run(logger, stdout, append(flags, "-all", "test"))
+	assert.Error(t, err)
+	err = Run(logger, stdout, append(flags, "-trash-retention", "0s", "test"))
+	assert.Error(t, err)
+	err = Run(logger, stdout, append(flags, "-root", sourcePath, "-all"))
+	assert.Error(t, err)
+
+	stdout.Reset()
+	err = Run(logger, stdout, append(flags, "-all"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(stdout.String(), "migrated "))
+	for _, name := range []string{"test", "other"} {
+		_, err = os.Stat(path.Join(targetPath, name, "_data"))
+		assert.NoError(t, err)
+		_, err = os.Stat(path.Join(sourcePath, name))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestFailover(t *testing.T) {
+	logger := log.New("test")
+	rootPath, replicaPath := t.TempDir(), t.TempDir()
+	stdout := &bytes.Buffer{}
+
+	err := Run(logger, stdout, []string{"create", "-root", rootPath, "-o", "replicate=dr", "test"})
+	assert.NoError(t, err)
+	source := storage.NewBuiltin(logger, rootPath)
+	replicator, err := replication.NewReplicator(logger, source, rootPath, map[string]*replication.Target{"dr": {Path: replicaPath}}, &storage.NameMappingOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, replicator.Run("dr"))
+	assert.NoError(t, replicator.Close())
+	assert.NoError(t, source.Close())
+	err = Run(logger, stdout, []string{"create", "-root", replicaPath, "other"})
+	assert.NoError(t, err)
+
+	err = Run(logger, stdout, []string{"failover", "-root", replicaPath})
+	assert.Error(t, err)
+	err = Run(logger, stdout, []string{"failover", "-root", replicaPath, "other"})
+	assert.Error(t, err)
+	stdout.Reset()
+	err = Run(logger, stdout, []string{"failover", "-root", replicaPath, "-all"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stdout.String(), "promoted test, last synced at "))
+	err = Run(logger, stdout, []string{"failover", "-root", replicaPath, "test"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+func init() {
+	registerCommand("create", &command{
+		description: "Create a volume, the options are the same as the volume options of drivers",
+		run:         create,
+	})
+}
+
+func create(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("create")
+	options := optionsFlag{}
+	flagSet.Var(options, "o", "set a volume option in key=value format, can be repeated")
+	requireAdopt := flagSet.Bool("require-adopt", false, "specify whether creating a volume over orphaned data requires the adopt option, like the requireAdopt driver option")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		return fmt.Errorf("exactly one volume name is required")
+	}
+	name := flagSet.Arg(0)
+
+	createOptions := &apis.CreateOptions{}
+	specOptions, err := createOptions.Unmarshal(options)
+	if err != nil {
+		return err
+	}
+
+	if createOptions.RestoreFrom != "" {
+		return fmt.Errorf("restore the volume from a backup archive by the restore command")
+	}
+	if _, existed := specOptions["storageClass"]; existed && !createOptions.Update {
+		return fmt.Errorf("storage classes are defined in the driver options, create the volume through the plugin to use storageClass")
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	if createOptions.Update {
+		if createOptions.RestoreTrash != "" || createOptions.Adopt {
+			return fmt.Errorf("update can't be combined with restoreTrash or adopt")
+		}
+		err = s.UpdateVolumeSpec(name, specOptions)
+	} else if createOptions.RestoreTrash != "" {
+		if len(specOptions) != 0 {
+			return fmt.Errorf("volume options can't be specified when restoring from trash")
+		}
+		err = s.RestoreVolume(createOptions.RestoreTrash, name)
+	} else {
+		spec := &apis.VolumeSpec{}
+		if err := spec.Unmarshal(specOptions); err != nil {
+			return err
+		}
+		err = s.CreateVolume(name, spec, createOptions.Adopt || !*requireAdopt)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(env.stdout, name)
+	return nil
+}
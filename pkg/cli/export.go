@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+func init() {
+	registerCommand("export", &command{
+		description: "Export the metadata of all volumes as JSON",
+		run:         export,
+	})
+}
+
+func export(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("export")
+	output := flagSet.String("output", "", "write to the file instead of stdout")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	volumeMetadataMap, err := s.ListVolumeMetadata()
+	if err != nil {
+		return err
+	}
+
+	inspections := make([]*volumeInspection, 0, len(volumeMetadataMap))
+	for _, name := range sortedNames(volumeMetadataMap) {
+		inspections = append(inspections, &volumeInspection{Name: name, VolumeMetadata: volumeMetadataMap[name]})
+	}
+
+	var writer io.Writer = env.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				env.logger.Errorf("failed to close %s: %v", *output, err)
+			}
+		}()
+		writer = file
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(inspections)
+}
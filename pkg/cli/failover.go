@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/replication"
+)
+
+func init() {
+	registerCommand("failover", &command{
+		description: "Promote the replicas in a replication target to regular volumes when the primary is lost",
+		run:         failover,
+	})
+}
+
+func failover(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("failover")
+	all := flagSet.Bool("all", false, "promote all replicas in the root path")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if *all == (flagSet.NArg() != 0) {
+		return fmt.Errorf("either volume names or -all is required")
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	names := flagSet.Args()
+	if *all {
+		err = s.WalkVolumeMetadata(func(name string, metadata *apis.VolumeMetadata) error {
+			if metadata.Status.Replica != nil {
+				names = append(names, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list replicas: %v", err)
+		}
+		sort.Strings(names)
+	}
+
+	failed := 0
+	for _, name := range names {
+		syncedAt, err := replication.Promote(s, name)
+		if err != nil {
+			failed++
+			env.logger.Errorf("failed to promote volume %s: %v", name, err)
+			continue
+		}
+		if syncedAt.IsZero() {
+			_, _ = fmt.Fprintf(env.stdout, "promoted %s, never synced completely, the data may be partial\n", name)
+			continue
+		}
+		_, _ = fmt.Fprintf(env.stdout, "promoted %s, last synced at %s\n", name, syncedAt.Local().Format(time.RFC3339))
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to promote %d volumes", failed)
+	}
+	return nil
+}
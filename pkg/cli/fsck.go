@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+)
+
+func init() {
+	registerCommand("fsck", &command{
+		description: "Check the consistency of volumes and optionally repair them",
+		run:         fsck,
+	})
+}
+
+func fsck(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("fsck")
+	repair := flagSet.Bool("repair", false, "repair the inconsistent volumes, corrupt metadata is quarantined")
+	dryRun := flagSet.Bool("dry-run", false, "only print the repair actions without applying them")
+	all := flagSet.Bool("all", false, "include the valid volumes in the report")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	results, err := s.Check()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	writer := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "NAME\tSTATE\tDETAIL\tACTION")
+	for _, result := range results {
+		if result.State == storage.CheckStateValid && !*all {
+			continue
+		}
+
+		action := ""
+		if *repair || *dryRun {
+			action, err = s.Repair(result, *dryRun)
+			if err != nil {
+				failed++
+				action = fmt.Sprintf("failed to %s: %v", action, err)
+			}
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", result.Name, result.State, result.Detail, action)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to repair %d volumes", failed)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	registerCommand("inspect", &command{
+		description: "Display the metadata of one or more volumes",
+		run:         inspect,
+	})
+}
+
+func inspect(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("inspect")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() == 0 {
+		return fmt.Errorf("at least one volume name is required")
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	inspections := make([]*volumeInspection, 0, flagSet.NArg())
+	for _, name := range flagSet.Args() {
+		metadata, err := s.FetchVolumeMetadata(name)
+		if err != nil {
+			return fmt.Errorf("failed to get volume %s: %v", name, err)
+		}
+		inspections = append(inspections, &volumeInspection{Name: name, VolumeMetadata: metadata})
+	}
+
+	encoder := json.NewEncoder(env.stdout)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(inspections)
+}
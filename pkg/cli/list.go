@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+func init() {
+	registerCommand("list", &command{
+		description: "List volumes",
+		run:         list,
+	})
+}
+
+func list(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("list")
+	quiet := flagSet.Bool("q", false, "only display volume names")
+	selectorFlag := flagSet.String("selector", "", "only display the volumes whose labels match the selector, e.g. owner=team-a,!temporary")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	selector, err := apis.ParseSelector(*selectorFlag)
+	if err != nil {
+		return err
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	volumeMetadataMap, err := s.ListVolumeMetadata()
+	if err != nil {
+		return err
+	}
+	for name, metadata := range volumeMetadataMap {
+		if !selector.Matches(metadata.Spec.Labels) {
+			delete(volumeMetadataMap, name)
+		}
+	}
+
+	if *quiet {
+		for _, name := range sortedNames(volumeMetadataMap) {
+			_, _ = fmt.Fprintln(env.stdout, name)
+		}
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "NAME\tCREATED AT\tMOUNTPOINT\tPURGE AFTER DELETE\tLABELS")
+	for _, name := range sortedNames(volumeMetadataMap) {
+		vol := volumeMetadataMap[name].ToVolume(name, storageFlags.rootPath)
+		spec := volumeMetadataMap[name].Spec
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%t\t%s\n", vol.Name, vol.CreatedAt, vol.Mountpoint, spec.PurgeAfterDelete, apis.FormatLabels(spec.Labels))
+	}
+	return writer.Flush()
+}
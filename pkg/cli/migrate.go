@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+)
+
+func init() {
+	registerCommand("migrate", &command{
+		description: "Migrate the metadata of all volumes, including those in namespaces, to the current schema version",
+		run:         migrate,
+	})
+	registerCommand("migrate-volume", &command{
+		description: "Migrate volumes from one driver to another, e.g. from an old NFS server to a new one",
+		run:         migrateVolumes,
+	})
+}
+
+func migrate(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("migrate")
+	dryRun := flagSet.Bool("dry-run", false, "only print the volumes to migrate without rewriting them")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	failed, err := migrateStorage(env, s, "", *dryRun)
+	if err != nil {
+		return err
+	}
+
+	// The namespaces keep their own metadata, which must be migrated too
+	namespaces, err := s.ListNamespaces()
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		namespaceFlags := *storageFlags
+		namespaceFlags.rootPath = path.Join(storageFlags.rootPath, namespace)
+		namespaceFlags.metadataStore.Path = ""
+		namespaceStorage, err := env.openStorage(&namespaceFlags)
+		if err != nil {
+			return fmt.Errorf("failed to open namespace %s: %v", namespace, err)
+		}
+		namespaceFailed, err := migrateStorage(env, namespaceStorage, namespace+"/", *dryRun)
+		env.closeStorage(namespaceStorage)
+		if err != nil {
+			return err
+		}
+		failed += namespaceFailed
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to migrate %d volumes", failed)
+	}
+	return nil
+}
+
+// migrateStorage migrates the metadata of the volumes in the storage, the volumes are printed with the prefix, and returns the number of failed volumes.
+func migrateStorage(env *environment, s *storage.Builtin, prefix string, dryRun bool) (int, error) {
+	// List the volume directories rather than the volumes, since the volumes of unknown schema versions are not listed
+	names, err := s.ListVolumeDirs()
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, name := range names {
+		version, err := s.MigrateVolumeMetadata(name, dryRun)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			failed++
+			env.logger.Errorf("failed to migrate volume %s%s: %v", prefix, name, err)
+			continue
+		}
+		if version == apis.CurrentSchemaVersion {
+			continue
+		}
+
+		if dryRun {
+			_, _ = fmt.Fprintf(env.stdout, "would migrate %s%s from schema version %d to %d\n", prefix, name, version, apis.CurrentSchemaVersion)
+		} else {
+			_, _ = fmt.Fprintf(env.stdout, "migrated %s%s from schema version %d to %d\n", prefix, name, version, apis.CurrentSchemaVersion)
+		}
+	}
+	return failed, nil
+}
+
+func migrateVolumes(env *environment, args []string) error {
+	flagSet := flag.NewFlagSet("migrate-volume", flag.ContinueOnError)
+	fromDriver := flagSet.String("from-driver", "", "specify the driver of the source volumes")
+	fromOptions := flagSet.String("from-options", "{}", "specify a json string of the driver options of the source")
+	fromMountpoint := flagSet.String("from-mountpoint", "/mnt/migrate/source", "specify where the source driver mounts its share")
+	toDriver := flagSet.String("to-driver", "", "specify the driver of the target volumes")
+	toOptions := flagSet.String("to-options", "{}", "specify a json string of the driver options of the target")
+	toMountpoint := flagSet.String("to-mountpoint", "/mnt/migrate/target", "specify where the target driver mounts its share")
+	all := flagSet.Bool("all", false, "migrate all volumes of the source")
+	trashRetention := flagSet.String("trash-retention", "7d", "specify how long the source volumes are kept in its trash")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if *all == (flagSet.NArg() != 0) {
+		return fmt.Errorf("either volume names or -all is required")
+	}
+	retention, err := apis.ParseDuration(*trashRetention)
+	if err != nil {
+		return fmt.Errorf("invalid value for trash-retention: %v", err)
+	}
+
+	source, err := newDriver(env, *fromDriver, *fromMountpoint, *fromOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create source driver: %v", err)
+	}
+	defer destroyDriver(env, source)
+	target, err := newDriver(env, *toDriver, *toMountpoint, *toOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create target driver: %v", err)
+	}
+	defer destroyDriver(env, target)
+
+	names := flagSet.Args()
+	if *all {
+		volumes, err := source.List()
+		if err != nil {
+			return fmt.Errorf("failed to list source volumes: %v", err)
+		}
+		names = make([]string, 0, len(volumes))
+		for name := range volumes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	failed := 0
+	for _, name := range names {
+		entry, err := drivers.MigrateVolume(source, target, name, time.Duration(retention))
+		if err != nil {
+			failed++
+			env.logger.Errorf("failed to migrate volume %s: %v", name, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(env.stdout, "migrated %s, the source is kept in trash entry %s\n", name, entry)
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to migrate %d volumes", failed)
+	}
+	return nil
+}
+
+// newDriver creates the driver which mounts its share at the mount point.
+func newDriver(env *environment, name string, mountpoint string, options string) (apis.Driver, error) {
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mount point: %v", err)
+	}
+	return drivers.New(context.Background(), env.logger, name, mountpoint, options)
+}
+
+func destroyDriver(env *environment, driver apis.Driver) {
+	if err := driver.Destroy(); err != nil {
+		env.logger.Errorf("failed to destroy driver: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+func init() {
+	registerCommand("orphans", &command{
+		description: "List or purge the data left by volumes deleted without purging",
+		run:         orphans,
+	})
+}
+
+func orphans(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("orphans")
+	olderThan := flagSet.String("older-than", "0s", "only include the orphaned data older than the duration, e.g. 30d")
+	purge := flagSet.Bool("purge", false, "purge the included orphaned data instead of listing it")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	age, err := apis.ParseDuration(*olderThan)
+	if err != nil {
+		return fmt.Errorf("invalid value for older-than: %v", err)
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	if *purge {
+		purged, err := s.PurgeOrphans(time.Duration(age))
+		for _, name := range purged {
+			_, _ = fmt.Fprintf(env.stdout, "purged %s\n", name)
+		}
+		return err
+	}
+
+	orphans, err := s.ListOrphans()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "NAME\tORPHANED AT\tAGE")
+	for _, orphan := range orphans {
+		if time.Since(orphan.OrphanedAt) < time.Duration(age) {
+			continue
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", orphan.Name, orphan.OrphanedAt.Local().Format(time.RFC3339), time.Since(orphan.OrphanedAt).Round(time.Second))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+func init() {
+	registerCommand("rm", &command{
+		description: "Remove one or more volumes, the data is handled according to the volume spec",
+		run:         rm,
+	})
+}
+
+func rm(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("rm")
+	trashRetention := flagSet.String("trash-retention", "7d", "trash retention of the volumes without trash retention in spec, 0s purges them immediately")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() == 0 {
+		return fmt.Errorf("at least one volume name is required")
+	}
+
+	retention, err := apis.ParseDuration(*trashRetention)
+	if err != nil {
+		return fmt.Errorf("invalid value for trash-retention: %v", err)
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	for _, name := range flagSet.Args() {
+		if err := s.RemoveVolume(name, time.Duration(retention)); err != nil {
+			return fmt.Errorf("failed to remove volume %s: %v", name, err)
+		}
+		_, _ = fmt.Fprintln(env.stdout, name)
+	}
+
+	return nil
+}
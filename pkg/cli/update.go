@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"fmt"
+)
+
+func init() {
+	registerCommand("update", &command{
+		description: "Update the mutable options of one or more volumes",
+		run:         update,
+	})
+}
+
+func update(env *environment, args []string) error {
+	flagSet, storageFlags := newFlagSet("update")
+	options := optionsFlag{}
+	flagSet.Var(options, "o", "set a volume option in key=value format, can be repeated")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() == 0 {
+		return fmt.Errorf("at least one volume name is required")
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("at least one option is required")
+	}
+
+	s, err := env.openStorage(storageFlags)
+	if err != nil {
+		return err
+	}
+	defer env.closeStorage(s)
+
+	for _, name := range flagSet.Args() {
+		if err := s.UpdateVolumeSpec(name, options); err != nil {
+			return fmt.Errorf("failed to update volume %s: %v", name, err)
+		}
+		_, _ = fmt.Fprintln(env.stdout, name)
+	}
+
+	return nil
+}
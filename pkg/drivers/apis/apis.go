@@ -27,3 +27,9 @@ type Driver interface {
 	// Destroy cleans up any resources used by the driver.
 	Destroy() error
 }
+
+// SpecResolver is implemented by the drivers which apply defaults to the spec options of new volumes, e.g. from label policies and storage classes.
+type SpecResolver interface {
+	// ResolveSpecOptions returns the spec options of a new volume with the defaults of the driver applied.
+	ResolveSpecOptions(options map[string]string) (map[string]string, error)
+}
@@ -0,0 +1,40 @@
+package apis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// checksumPlaceholder takes the place of the checksum while it is computed over the marshaled volume metadata.
+var checksumPlaceholder = strings.Repeat("0", sha256.Size*2)
+
+// checksumPattern matches the checksum field, which is the first field of the marshaled volume metadata.
+var checksumPattern = regexp.MustCompile(`"checksum":"([0-9a-f]{64})"`)
+
+// sealChecksum replaces the checksum placeholder in the marshaled volume metadata by the SHA-256 of the data and returns the sealed data and the checksum.
+func sealChecksum(data []byte) ([]byte, string) {
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	return bytes.Replace(data, []byte(checksumPlaceholder), []byte(checksum), 1), checksum
+}
+
+// VerifyChecksum verifies the checksum embedded in the marshaled volume metadata, the data without checksum written by older versions is accepted.
+func VerifyChecksum(data []byte) error {
+	match := checksumPattern.FindSubmatchIndex(data)
+	if match == nil {
+		return nil
+	}
+
+	unsealed := bytes.Clone(data)
+	copy(unsealed[match[2]:match[3]], checksumPlaceholder)
+	sum := sha256.Sum256(unsealed)
+	if hex.EncodeToString(sum[:]) != string(data[match[2]:match[3]]) {
+		return fmt.Errorf("checksum mismatch, the volume metadata is corrupted")
+	}
+
+	return nil
+}
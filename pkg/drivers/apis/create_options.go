@@ -0,0 +1,50 @@
+package apis
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CreateOptions are the directives of a create request, they control how a volume is created and are not persisted in the volume spec.
+type CreateOptions struct {
+	// RestoreTrash is the name of the trash entry to restore the volume from
+	RestoreTrash string
+
+	// Adopt indicates whether to reuse the orphaned data left by a deleted volume with the same name
+	Adopt bool
+
+	// Update indicates whether to merge the options into the spec of the existing volume instead of creating it
+	Update bool
+
+	// RestoreFrom is the name of the backup archive to restore the volume from
+	RestoreFrom string
+}
+
+// Unmarshal extracts the create directives from data and returns the remaining options which belong to the volume spec.
+func (opts *CreateOptions) Unmarshal(data map[string]string) (map[string]string, error) {
+	specData := make(map[string]string, len(data))
+	for key, value := range data {
+		switch key {
+		case "restoreTrash":
+			opts.RestoreTrash = value
+		case "restoreFrom":
+			opts.RestoreFrom = value
+		case "adopt":
+			adopt, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for adopt: %v", err)
+			}
+			opts.Adopt = adopt
+		case "update":
+			update, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for update: %v", err)
+			}
+			opts.Update = update
+		default:
+			specData[key] = value
+		}
+	}
+
+	return specData, nil
+}
@@ -0,0 +1,161 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands of the common cron expressions.
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// cronFields are the ranges of the fields of a cron expression.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 6},
+}
+
+// CronSchedule is a cron expression of five fields: minute, hour, day of month, month and day of week, each field is *, a value,
+// a range a-b, a step */n or a-b/n, or a comma separated list of them. The macros @hourly, @daily, @weekly, @monthly and @yearly are accepted too.
+type CronSchedule struct {
+	expression string
+	fields     [5]uint64
+	anyDay     [2]bool
+}
+
+// ParseCronSchedule parses a cron expression.
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	schedule := &CronSchedule{expression: expression}
+	if macro, existed := cronMacros[expression]; existed {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields", schedule.expression, len(cronFields))
+	}
+	for i, field := range fields {
+		bits, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of cron expression %q: %v", cronFields[i].name, schedule.expression, err)
+		}
+		schedule.fields[i] = bits
+	}
+	schedule.anyDay = [2]bool{fields[2] == "*", fields[4] == "*"}
+
+	return schedule, nil
+}
+
+// parseCronField parses a field into the bits of the values it matches.
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, term := range strings.Split(field, ",") {
+		valueRange, stepValue, hasStep := strings.Cut(term, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepValue)
+			}
+		}
+
+		start, end := min, max
+		if valueRange != "*" {
+			startValue, endValue, isRange := strings.Cut(valueRange, "-")
+			var err error
+			start, err = strconv.Atoi(startValue)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", startValue)
+			}
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(endValue)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q", endValue)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", term, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time matching the schedule after the time, with the precision of minutes.
+func (schedule *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Every schedule matches within a few years, e.g. February 29th
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !schedule.matches(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !schedule.matches(1, t.Hour()):
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !schedule.matches(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day matches, when both the day of month and the day of week are restricted either of them matches as cron does.
+func (schedule *CronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := schedule.matches(2, t.Day())
+	dayOfWeek := schedule.matches(4, int(t.Weekday()))
+	if schedule.anyDay[0] || schedule.anyDay[1] {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func (schedule *CronSchedule) matches(field int, value int) bool {
+	return schedule.fields[field]&(1<<uint(value)) != 0
+}
+
+// String returns the cron expression.
+func (schedule *CronSchedule) String() string {
+	return schedule.expression
+}
+
+// MarshalJSON encodes the schedule as a string.
+func (schedule CronSchedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(schedule.expression)
+}
+
+// UnmarshalJSON decodes the schedule from a string.
+func (schedule *CronSchedule) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("cron schedule should be a string: %v", err)
+	}
+
+	parsed, err := ParseCronSchedule(value)
+	if err != nil {
+		return err
+	}
+	*schedule = *parsed
+	return nil
+}
@@ -0,0 +1,53 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration which is represented as a string like "90m" or "7d" in options and metadata.
+type Duration time.Duration
+
+// ParseDuration parses a duration string, in addition to the units of time.ParseDuration it accepts a "d" suffix for days.
+func ParseDuration(value string) (Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return Duration(n * float64(24*time.Hour)), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return Duration(d), nil
+}
+
+// String returns the duration in time.Duration format.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes the duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var value string
+	if err = json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration should be a string: %v", err)
+	}
+
+	*d, err = ParseDuration(value)
+	return err
+}
@@ -0,0 +1,164 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LabelOptionPrefix is the prefix of the volume options which set labels, e.g. label.owner=team-a.
+const LabelOptionPrefix = "label."
+
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// ValidateLabelKey checks the key of a label, it is made of alphanumerics, dots, dashes, underscores and slashes.
+func ValidateLabelKey(key string) error {
+	if !labelKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
+
+// LabelsFromOptions returns the labels set by the label options.
+func LabelsFromOptions(options map[string]string) map[string]string {
+	labels := map[string]string{}
+	for key, value := range options {
+		if labelKey, found := strings.CutPrefix(key, LabelOptionPrefix); found {
+			labels[labelKey] = value
+		}
+	}
+	return labels
+}
+
+// selectorOperator is the operator of a selector requirement.
+type selectorOperator string
+
+const (
+	selectorEquals    selectorOperator = "="
+	selectorNotEquals selectorOperator = "!="
+	selectorExists    selectorOperator = "exists"
+	selectorNotExists selectorOperator = "!exists"
+)
+
+// selectorRequirement is a requirement on one label.
+type selectorRequirement struct {
+	key      string
+	operator selectorOperator
+	value    string
+}
+
+// Selector selects volumes by their labels, it is a comma separated list of requirements which all must match:
+// key=value, key!=value, key (the label exists) and !key (the label doesn't exist). An empty selector matches everything.
+type Selector struct {
+	requirements []selectorRequirement
+}
+
+// ParseSelector parses a selector string.
+func ParseSelector(value string) (*Selector, error) {
+	selector := &Selector{}
+	for _, term := range strings.Split(value, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		requirement := selectorRequirement{}
+		switch {
+		case strings.Contains(term, "!="):
+			requirement.key, requirement.value, _ = strings.Cut(term, "!=")
+			requirement.operator = selectorNotEquals
+		case strings.Contains(term, "="):
+			requirement.key, requirement.value, _ = strings.Cut(term, "=")
+			requirement.operator = selectorEquals
+		case strings.HasPrefix(term, "!"):
+			requirement.key = strings.TrimPrefix(term, "!")
+			requirement.operator = selectorNotExists
+		default:
+			requirement.key = term
+			requirement.operator = selectorExists
+		}
+
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if err := ValidateLabelKey(requirement.key); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", value, err)
+		}
+		selector.requirements = append(selector.requirements, requirement)
+	}
+
+	return selector, nil
+}
+
+// Matches reports whether the labels satisfy all requirements of the selector.
+func (selector *Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range selector.requirements {
+		value, existed := labels[requirement.key]
+		switch requirement.operator {
+		case selectorEquals:
+			if !existed || value != requirement.value {
+				return false
+			}
+		case selectorNotEquals:
+			if existed && value == requirement.value {
+				return false
+			}
+		case selectorExists:
+			if !existed {
+				return false
+			}
+		case selectorNotExists:
+			if existed {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the selector in its string form.
+func (selector *Selector) String() string {
+	terms := make([]string, 0, len(selector.requirements))
+	for _, requirement := range selector.requirements {
+		switch requirement.operator {
+		case selectorEquals, selectorNotEquals:
+			terms = append(terms, requirement.key+string(requirement.operator)+requirement.value)
+		case selectorExists:
+			terms = append(terms, requirement.key)
+		case selectorNotExists:
+			terms = append(terms, "!"+requirement.key)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// MarshalJSON encodes the selector as a string.
+func (selector Selector) MarshalJSON() ([]byte, error) {
+	return json.Marshal(selector.String())
+}
+
+// UnmarshalJSON decodes the selector from a string.
+func (selector *Selector) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("selector should be a string: %v", err)
+	}
+
+	parsed, err := ParseSelector(value)
+	if err != nil {
+		return err
+	}
+	*selector = *parsed
+	return nil
+}
+
+// FormatLabels returns the labels as sorted key=value pairs.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,69 @@
+package apis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// CurrentSchemaVersion is the schema version of the volume metadata written by this version.
+const CurrentSchemaVersion = 1
+
+// ErrNewerSchema is returned for the volume metadata of a schema version newer than CurrentSchemaVersion, which must not be
+// replaced by an older copy or overwritten, since the fields of the newer schema would be lost.
+var ErrNewerSchema = errors.New("schema version is newer than the supported version, please upgrade the plugin")
+
+// schemaMigration migrates a volume metadata document from its schema version to the next one.
+type schemaMigration func(document map[string]json.RawMessage) error
+
+// schemaMigrations is the registry of forward migrations, the migration at index i migrates schema version i to i+1.
+// A schema version must be added with its migration whenever a field is renamed, removed or changes its meaning,
+// since the plugins of older versions on other nodes refuse to read the metadata of newer schema versions. The plugins
+// before schema version 1 don't check it though, so the fields they require must still be written.
+var schemaMigrations = []schemaMigration{
+	// 0 -> 1: fix the name of createdAt, the old key is kept for the plugins before schema version 1
+	func(document map[string]json.RawMessage) error {
+		if createdAt, existed := document["createAt"]; existed {
+			document["createdAt"] = createdAt
+		}
+		return nil
+	},
+}
+
+// MigrateVolumeMetadata applies the forward migrations to the volume metadata document and returns the migrated document
+// and the schema version it was stored in, documents of newer unknown schema versions are refused.
+func MigrateVolumeMetadata(data []byte) ([]byte, int, error) {
+	document := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal volume metadata: %v", err)
+	}
+
+	version := 0
+	if rawVersion, existed := document["schemaVersion"]; existed {
+		if err := json.Unmarshal(rawVersion, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid schema version: %v", err)
+		}
+	}
+	if version > CurrentSchemaVersion {
+		return nil, version, fmt.Errorf("%w: %d > %d", ErrNewerSchema, version, CurrentSchemaVersion)
+	}
+	if version < 0 {
+		return nil, version, fmt.Errorf("invalid schema version %d", version)
+	}
+	if version == CurrentSchemaVersion {
+		return data, version, nil
+	}
+
+	for v := version; v < CurrentSchemaVersion; v++ {
+		if err := schemaMigrations[v](document); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate schema version %d to %d: %v", v, v+1, err)
+		}
+	}
+	document["schemaVersion"] = json.RawMessage(fmt.Sprint(CurrentSchemaVersion))
+
+	migrated, err := json.Marshal(document)
+	if err != nil {
+		return nil, version, fmt.Errorf("failed to marshal migrated volume metadata: %v", err)
+	}
+	return migrated, version, nil
+}
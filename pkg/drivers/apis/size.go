@@ -0,0 +1,64 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes which is represented as a string like "512Mi" or "10G" in options and metadata.
+type Size int64
+
+// sizeUnits are the supported suffixes of sizes, the binary ones must be checked before the decimal ones.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// ParseSize parses a size string, which is a number of bytes optionally followed by a decimal (K, M, G, T) or binary (Ki, Mi, Gi, Ti) suffix.
+func ParseSize(value string) (Size, error) {
+	number, multiplier := value, int64(1)
+	for _, unit := range sizeUnits {
+		if n, found := strings.CutSuffix(value, unit.suffix); found {
+			number, multiplier = n, unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return Size(n * multiplier), nil
+}
+
+// String returns the size with the largest binary suffix which divides it.
+func (s Size) String() string {
+	for i := 3; i >= 0; i-- {
+		unit := sizeUnits[i]
+		if s != 0 && int64(s)%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", int64(s)/unit.multiplier, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+// MarshalJSON encodes the size as a string.
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes the size from a string.
+func (s *Size) UnmarshalJSON(data []byte) (err error) {
+	var value string
+	if err = json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("size should be a string: %v", err)
+	}
+
+	*s, err = ParseSize(value)
+	return err
+}
@@ -0,0 +1,64 @@
+package apis
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// TmpfsOptions are the options of the tmpfs mounted at the data directory of a volume of the tmpfs driver.
+type TmpfsOptions struct {
+	// Size is the maximum size of the tmpfs, zero means the quota of the volume or the default of the driver
+	Size Size `json:"size,omitempty"`
+
+	// Mode is the permission bits of the root directory of the tmpfs, nil means the default of tmpfs
+	Mode *uint32 `json:"mode,omitempty"`
+
+	// UID is the owner of the root directory of the tmpfs, nil means root
+	UID *uint32 `json:"uid,omitempty"`
+
+	// GID is the group of the root directory of the tmpfs, nil means root
+	GID *uint32 `json:"gid,omitempty"`
+}
+
+// Unmarshal extracts the tmpfs options from data and returns the remaining options, it reports whether any tmpfs option is found.
+func (opts *TmpfsOptions) Unmarshal(data map[string]string) (map[string]string, bool, error) {
+	remaining := make(map[string]string, len(data))
+	found := false
+	for key, value := range data {
+		switch key {
+		case "size":
+			size, err := ParseSize(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid value for size: %v", err)
+			}
+			opts.Size = size
+		case "mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || mode > 0o7777 {
+				return nil, false, fmt.Errorf("invalid value for mode: %q should be octal permission bits", value)
+			}
+			opts.Mode = uint32Pointer(mode)
+		case "uid", "gid":
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid value for %s: %v", key, err)
+			}
+			if key == "uid" {
+				opts.UID = uint32Pointer(id)
+			} else {
+				opts.GID = uint32Pointer(id)
+			}
+		default:
+			remaining[key] = value
+			continue
+		}
+		found = true
+	}
+
+	return remaining, found, nil
+}
+
+func uint32Pointer(value uint64) *uint32 {
+	v := uint32(value)
+	return &v
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
@@ -14,8 +15,21 @@ import (
 var globalValidator *validator.Validate = validator.New()
 
 type VolumeMetadata struct {
+	// Checksum is the SHA-256 of the marshaled metadata with a placeholder in place of itself, it must be the first field
+	Checksum string `json:"checksum,omitempty"`
+
+	// SchemaVersion is the version of the metadata schema, it is always CurrentSchemaVersion once marshaled
+	SchemaVersion int `json:"schemaVersion"`
+
+	// Name is the original volume name, the name of the volume directory differs from it when the names are mapped
+	Name string `json:"name,omitempty"`
+
 	// CreatedAt is the timestamp when the volume was created
-	CreatedAt time.Time `json:"createAt" validate:"required"`
+	CreatedAt time.Time `json:"createdAt" validate:"required"`
+
+	// LegacyCreatedAt is CreatedAt under its key before schema version 1, it is still written since the plugins of older versions
+	// don't check the schema version and require it, it can only be dropped by a later schema version
+	LegacyCreatedAt *time.Time `json:"createAt,omitempty"`
 
 	// Spec contains the volume specification, including options and settings
 	Spec *VolumeSpec `json:"spec" validate:"required"`
@@ -26,24 +40,32 @@ type VolumeMetadata struct {
 
 // Marshal validates the VolumeMetadata struct and marshals it into JSON format.
 func (vm *VolumeMetadata) Marshal() (data []byte, err error) {
+	vm.SchemaVersion = CurrentSchemaVersion
+	createdAt := vm.CreatedAt
+	vm.LegacyCreatedAt = &createdAt
 	err = globalValidator.Struct(vm)
 	if err != nil {
 		return data, fmt.Errorf("failed to validate volume metadata: %v", err)
 	}
 
+	vm.Checksum = checksumPlaceholder
 	data, err = json.Marshal(vm)
 	if err != nil {
 		return data, fmt.Errorf("failed to unmarshal volume metadata: %v", err)
 	}
+	data, vm.Checksum = sealChecksum(data)
 	return data, err
 }
 
-// Unmarshal the JSON data into the VolumeMetadata struct and validates it.
+// Unmarshal the JSON data into the VolumeMetadata struct and validates it, the data should be migrated to the current schema version by MigrateVolumeMetadata.
 func (vm *VolumeMetadata) Unmarshal(data []byte) (err error) {
 	err = json.Unmarshal(data, vm)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal volume metadata: %v", err)
 	}
+	if vm.SchemaVersion != CurrentSchemaVersion {
+		return fmt.Errorf("schema version %d is not migrated to %d", vm.SchemaVersion, CurrentSchemaVersion)
+	}
 
 	err = globalValidator.Struct(vm)
 	if err != nil {
@@ -55,16 +77,62 @@ func (vm *VolumeMetadata) Unmarshal(data []byte) (err error) {
 
 // ToVolume converts the VolumeMetadata struct to a volume.Volume struct, using the provided name and mountpointBase.
 func (vm *VolumeMetadata) ToVolume(name string, mountpointBase string) *volume.Volume {
+	status := map[string]interface{}{}
+	if len(vm.Spec.Labels) != 0 {
+		status["labels"] = vm.Spec.Labels
+	}
+	if vm.Status.Backup != nil {
+		status["backup"] = vm.Status.Backup
+	}
+	if vm.Status.Replication != nil {
+		status["replication"] = vm.Status.Replication
+	}
+	if vm.Status.Replica != nil {
+		status["replica"] = vm.Status.Replica
+	}
+
 	return &volume.Volume{
 		Name:       name,
 		Mountpoint: path.Join(mountpointBase, vm.Status.Mountpoint),
 		CreatedAt:  vm.CreatedAt.Local().Format(time.RFC3339),
-		Status:     map[string]interface{}{},
+		Status:     status,
 	}
 }
 
 type VolumeSpec struct {
 	PurgeAfterDelete bool `json:"purgeAfterDelete,omitempty"`
+
+	// TrashRetention is how long the purged volume data is kept in the trash before it is deleted, zero means purge immediately
+	// and nil means follow the driver options
+	TrashRetention *Duration `json:"trashRetention,omitempty"`
+
+	// Quota is the maximum size of the volume data, zero means unlimited, it is enforced by the drivers which support quotas
+	Quota Size `json:"quota,omitempty"`
+
+	// ReadOnly indicates whether the volume is mounted read-only
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Labels are set by the label.<key>=<value> options, they record ownership info and are matched by selectors
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// StorageClass is the name of the storage class whose options were applied when the volume was created
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Replicate is the name of the replication target the volume is synced to, empty means the volume isn't replicated
+	Replicate string `json:"replicate,omitempty"`
+
+	// Tmpfs are the options of the tmpfs of the volumes of the tmpfs driver
+	Tmpfs *TmpfsOptions `json:"tmpfs,omitempty"`
+}
+
+// specOptionMutability tells whether each spec option can be changed after the volume is created.
+var specOptionMutability = map[string]bool{
+	"purgeAfterDelete": true,
+	"trashRetention":   true,
+	"quota":            true,
+	"readOnly":         true,
+	"storageClass":     false,
+	"replicate":        true,
 }
 
 // Unmarshal takes a map of string key-value pairs and populates the VolumeSpec struct based on the provided data. It returns an error if any of the values are invalid or if there are unknown options.
@@ -76,15 +144,124 @@ func (spec *VolumeSpec) Unmarshal(data map[string]string) (err error) {
 			if err != nil {
 				return fmt.Errorf("invalid value for purgeAfterDelete: %v", err)
 			}
+		case "trashRetention":
+			retention, err := ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for trashRetention: %v", err)
+			}
+			spec.TrashRetention = &retention
+		case "quota":
+			spec.Quota, err = ParseSize(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for quota: %v", err)
+			}
+		case "readOnly":
+			spec.ReadOnly, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for readOnly: %v", err)
+			}
+		case "storageClass":
+			spec.StorageClass = value
+		case "replicate":
+			spec.Replicate = value
 		default:
-			return fmt.Errorf("unknown option %s with value %s", key, value)
+			labelKey, found := strings.CutPrefix(key, LabelOptionPrefix)
+			if !found {
+				return fmt.Errorf("unknown option %s with value %s", key, value)
+			}
+			if err := ValidateLabelKey(labelKey); err != nil {
+				return err
+			}
+			if spec.Labels == nil {
+				spec.Labels = map[string]string{}
+			}
+			spec.Labels[labelKey] = value
+		}
+	}
+
+	return nil
+}
+
+// Update merges the options into the spec of an existing volume, the options which can't be changed after the volume is created are rejected.
+// A label option with an empty value removes the label.
+func (spec *VolumeSpec) Update(data map[string]string) error {
+	for key := range data {
+		if mutable, known := specOptionMutability[key]; known && !mutable {
+			return fmt.Errorf("option %s can't be changed after the volume is created", key)
 		}
 	}
 
+	err := spec.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range LabelsFromOptions(data) {
+		if value == "" {
+			delete(spec.Labels, key)
+		}
+	}
+	if len(spec.Labels) == 0 {
+		spec.Labels = nil
+	}
 	return nil
 }
 
 type VolumeStatus struct {
 	// Mountpoint is the relative path to the volume's mount point
 	Mountpoint string `json:"mountpoint" validate:"required"`
+
+	// TrashedAt is the timestamp when the volume was moved into the trash
+	TrashedAt *time.Time `json:"trashedAt,omitempty"`
+
+	// PurgeAt is the timestamp after which the trashed volume will be purged
+	PurgeAt *time.Time `json:"purgeAt,omitempty"`
+
+	// Backup is the result of the last scheduled backups of the volume
+	Backup *BackupStatus `json:"backup,omitempty"`
+
+	// Replication is the result of the last syncs of the volume to its replica
+	Replication *ReplicationStatus `json:"replication,omitempty"`
+
+	// Replica is set on the replicas of volumes, it is cleared when the replica is promoted
+	Replica *ReplicaStatus `json:"replica,omitempty"`
+
+	// ProjectID is the XFS project which enforces the quota of the volume, zero means the volume has no project
+	ProjectID uint32 `json:"projectId,omitempty"`
+}
+
+// BackupStatus records the last success and failure of the scheduled backups of a volume.
+type BackupStatus struct {
+	// LastSuccess is when the last successful backup was started
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+
+	// LastArchive is the archive of the last successful backup
+	LastArchive string `json:"lastArchive,omitempty"`
+
+	// LastFailure is when the last failed backup was started
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+
+	// LastError is the error of the last failed backup
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ReplicationStatus records the last success and failure of the syncs of a volume to its replica.
+type ReplicationStatus struct {
+	// LastSync is when the last successful sync was started
+	LastSync *time.Time `json:"lastSync,omitempty"`
+
+	// LastFailure is when the last failed sync was started
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+
+	// LastError is the error of the last failed sync
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ReplicaStatus describes the replica of a volume.
+type ReplicaStatus struct {
+	// Target is the name of the replication target the replica is in
+	Target string `json:"target"`
+
+	// SyncedAt is when the last successful sync to the replica was started, zero until the first sync succeeds
+	SyncedAt time.Time `json:"syncedAt"`
 }
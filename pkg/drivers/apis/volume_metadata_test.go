@@ -1,7 +1,10 @@
 package apis
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,6 +31,69 @@ func TestUnmarshalVolumeSpec(t *testing.T) {
 				PurgeAfterDelete: true},
 			hasErr: false,
 		},
+		{
+			name: "valid trashRetention",
+			data: map[string]string{
+				"trashRetention": "7d",
+			},
+			excepted: &VolumeSpec{
+				TrashRetention: func() *Duration { d := Duration(7 * 24 * time.Hour); return &d }()},
+			hasErr: false,
+		},
+		{
+			name: "valid quota and readOnly",
+			data: map[string]string{
+				"quota":    "512Mi",
+				"readOnly": "true",
+			},
+			excepted: &VolumeSpec{
+				Quota:    Size(512 << 20),
+				ReadOnly: true},
+			hasErr: false,
+		},
+		{
+			name: "valid replicate",
+			data: map[string]string{
+				"replicate": "dr-site",
+			},
+			excepted: &VolumeSpec{
+				Replicate: "dr-site"},
+			hasErr: false,
+		},
+		{
+			name: "valid labels",
+			data: map[string]string{
+				"label.owner":            "team-a",
+				"label.example.com/tier": "",
+			},
+			excepted: &VolumeSpec{
+				Labels: map[string]string{"owner": "team-a", "example.com/tier": ""}},
+			hasErr: false,
+		},
+		{
+			name: "invalid label key",
+			data: map[string]string{
+				"label.": "team-a",
+			},
+			excepted: &VolumeSpec{},
+			hasErr:   true,
+		},
+		{
+			name: "invalid value for quota",
+			data: map[string]string{
+				"quota": "-1G",
+			},
+			excepted: &VolumeSpec{},
+			hasErr:   true,
+		},
+		{
+			name: "invalid value for trashRetention",
+			data: map[string]string{
+				"trashRetention": "-1h",
+			},
+			excepted: &VolumeSpec{},
+			hasErr:   true,
+		},
 		{
 			name: "invalid value for purgeAfterDelete",
 			data: map[string]string{
@@ -55,3 +121,263 @@ func TestUnmarshalVolumeSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateVolumeSpec(t *testing.T) {
+	spec := &VolumeSpec{PurgeAfterDelete: true}
+	assert.NoError(t, spec.Update(map[string]string{"quota": "10G"}))
+	assert.Equal(t, &VolumeSpec{PurgeAfterDelete: true, Quota: Size(10e9)}, spec)
+
+	// Test labels are merged and removed by empty values
+	assert.NoError(t, spec.Update(map[string]string{"label.owner": "team-a", "label.tier": "scratch"}))
+	assert.NoError(t, spec.Update(map[string]string{"label.owner": "team-b", "label.tier": ""}))
+	assert.Equal(t, map[string]string{"owner": "team-b"}, spec.Labels)
+	assert.NoError(t, spec.Update(map[string]string{"label.owner": ""}))
+	assert.Nil(t, spec.Labels)
+
+	assert.EqualError(t, spec.Update(map[string]string{"storageClass": "database", "quota": "1G"}), "option storageClass can't be changed after the volume is created")
+	assert.Equal(t, Size(10e9), spec.Quota)
+	assert.Error(t, spec.Update(map[string]string{"unknownOption": "value"}))
+}
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{"owner": "team-a", "tier": "scratch"}
+	tests := []struct {
+		selector string
+		matches  bool
+		hasErr   bool
+	}{
+		{selector: "", matches: true},
+		{selector: "owner=team-a", matches: true},
+		{selector: "owner=team-b", matches: false},
+		{selector: "owner!=team-b,tier", matches: true},
+		{selector: "owner, !backup", matches: true},
+		{selector: "!tier", matches: false},
+		{selector: "backup=nightly", matches: false},
+		{selector: "=value", hasErr: true},
+		{selector: "owner=team-a,!", hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := ParseSelector(tt.selector)
+			assert.True(t, (err != nil) == tt.hasErr, "ParseSelector got not excepted error: %v", err)
+			if err == nil {
+				assert.Equal(t, tt.matches, selector.Matches(labels))
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		excepted Size
+		hasErr   bool
+	}{
+		{value: "0", excepted: 0},
+		{value: "1024", excepted: 1024},
+		{value: "10K", excepted: 10000},
+		{value: "10Ki", excepted: 10240},
+		{value: "5Gi", excepted: 5 << 30},
+		{value: "2T", excepted: 2e12},
+		{value: "1.5G", hasErr: true},
+		{value: "-1", hasErr: true},
+		{value: "10Pi", hasErr: true},
+		{value: "9223372036854775807Ki", hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			size, err := ParseSize(tt.value)
+			assert.True(t, (err != nil) == tt.hasErr, "ParseSize got not excepted error: %v", err)
+			assert.Equal(t, tt.excepted, size)
+			if err == nil {
+				parsed, err := ParseSize(size.String())
+				assert.NoError(t, err)
+				assert.Equal(t, size, parsed)
+			}
+		})
+	}
+}
+
+func TestMigrateVolumeMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		excepted int
+		hasErr   bool
+	}{
+		{
+			name:     "schema version 0",
+			data:     `{"createAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"test/_data"}}`,
+			excepted: 0,
+			hasErr:   false,
+		},
+		{
+			name:     "current schema version",
+			data:     `{"schemaVersion":1,"createdAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"test/_data"}}`,
+			excepted: 1,
+			hasErr:   false,
+		},
+		{
+			name:     "newer schema version",
+			data:     `{"schemaVersion":2,"createdAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"test/_data"}}`,
+			excepted: 2,
+			hasErr:   true,
+		},
+		{
+			name:     "invalid schema version",
+			data:     `{"schemaVersion":"1"}`,
+			excepted: 0,
+			hasErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, version, err := MigrateVolumeMetadata([]byte(tt.data))
+			assert.True(t, (err != nil) == tt.hasErr, "MigrateVolumeMetadata got not excepted error: %v", err)
+			assert.Equal(t, tt.excepted, version)
+			if err != nil {
+				return
+			}
+
+			metadata := &VolumeMetadata{}
+			assert.NoError(t, metadata.Unmarshal(data))
+			assert.Equal(t, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), metadata.CreatedAt.UTC())
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	metadata := &VolumeMetadata{
+		CreatedAt: time.Now(),
+		Spec:      &VolumeSpec{},
+		Status:    &VolumeStatus{Mountpoint: "test/_data"},
+	}
+	data, err := metadata.Marshal()
+	assert.NoError(t, err)
+	assert.Len(t, metadata.Checksum, 64)
+	assert.Contains(t, string(data), metadata.Checksum)
+	assert.NoError(t, VerifyChecksum(data))
+
+	// The plugins before schema version 1 read createAt
+	legacy := &struct {
+		CreateAt time.Time `json:"createAt"`
+	}{}
+	assert.NoError(t, json.Unmarshal(data, legacy))
+	assert.True(t, metadata.CreatedAt.Equal(legacy.CreateAt))
+
+	// Test modified data
+	assert.Error(t, VerifyChecksum([]byte(strings.Replace(string(data), "test/_data", "fake/_data", 1))))
+
+	// Test data without checksum
+	assert.NoError(t, VerifyChecksum([]byte(`{"schemaVersion":1}`)))
+}
+
+func TestCronSchedule(t *testing.T) {
+	// 2026-01-01 is a Thursday
+	after := time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expression string
+		excepted   time.Time
+		hasErr     bool
+	}{
+		{expression: "@hourly", excepted: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{expression: "@daily", excepted: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{expression: "@weekly", excepted: time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{expression: "@monthly", excepted: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{expression: "*/15 * * * *", excepted: time.Date(2026, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{expression: "30 2-4 * * *", excepted: time.Date(2026, 1, 2, 2, 30, 0, 0, time.UTC)},
+		{expression: "0 0 15 * 1", excepted: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 12 * * 1-5/2", excepted: time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)},
+		{expression: "0 0 29 2 *", excepted: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 31 2 *", excepted: time.Time{}},
+		{expression: "0 0 * *", hasErr: true},
+		{expression: "60 * * * *", hasErr: true},
+		{expression: "5-1 * * * *", hasErr: true},
+		{expression: "*/0 * * * *", hasErr: true},
+		{expression: "@never", hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			schedule, err := ParseCronSchedule(tt.expression)
+			assert.True(t, (err != nil) == tt.hasErr, "ParseCronSchedule got not excepted error: %v", err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.excepted, schedule.Next(after))
+
+			data, err := json.Marshal(schedule)
+			assert.NoError(t, err)
+			parsed := &CronSchedule{}
+			assert.NoError(t, json.Unmarshal(data, parsed))
+			assert.Equal(t, schedule, parsed)
+		})
+	}
+}
+
+func TestUnmarshalTmpfsOptions(t *testing.T) {
+	uint32Of := func(value uint32) *uint32 {
+		return &value
+	}
+
+	tests := []struct {
+		name      string
+		data      map[string]string
+		expected  *TmpfsOptions
+		remaining map[string]string
+		found     bool
+		hasErr    bool
+	}{
+		{
+			name:      "no tmpfs options",
+			data:      map[string]string{"quota": "1Gi"},
+			expected:  &TmpfsOptions{},
+			remaining: map[string]string{"quota": "1Gi"},
+		},
+		{
+			name:      "valid options",
+			data:      map[string]string{"size": "64Mi", "mode": "1777", "uid": "1000", "gid": "0", "readOnly": "true"},
+			expected:  &TmpfsOptions{Size: 64 << 20, Mode: uint32Of(0o1777), UID: uint32Of(1000), GID: uint32Of(0)},
+			remaining: map[string]string{"readOnly": "true"},
+			found:     true,
+		},
+		{
+			name:   "invalid size",
+			data:   map[string]string{"size": "big"},
+			hasErr: true,
+		},
+		{
+			name:   "invalid mode",
+			data:   map[string]string{"mode": "0999"},
+			hasErr: true,
+		},
+		{
+			name:   "mode out of range",
+			data:   map[string]string{"mode": "17777"},
+			hasErr: true,
+		},
+		{
+			name:   "negative uid",
+			data:   map[string]string{"uid": "-1"},
+			hasErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &TmpfsOptions{}
+			remaining, found, err := opts.Unmarshal(tt.data)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, opts)
+			assert.Equal(t, tt.remaining, remaining)
+			assert.Equal(t, tt.found, found)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+)
+
+const (
+	// archiveSuffix is the suffix of the archive names, the archives are zstd compressed tar files
+	archiveSuffix = ".tar.zst"
+
+	// archiveTimeFormat is the format of the creation time in the archive names, the nanoseconds keep the backups of the same second apart
+	archiveTimeFormat = "20060102T150405.000000000Z"
+
+	// legacyArchiveTimeFormat is the format of the creation time in the names of the archives created before the nanoseconds were added
+	legacyArchiveTimeFormat = "20060102T150405Z"
+)
+
+// Options selects the target the backup archives are kept in, exactly one of the directory, the S3 bucket and the repository must be specified.
+type Options struct {
+	// Directory keeps the archives in a local directory
+	Directory string `json:"directory,omitempty"`
+
+	// S3 keeps the archives in a bucket of an S3-compatible endpoint
+	S3 *S3Options `json:"s3,omitempty"`
+
+	// Repository keeps the archives deduplicated in chunks in a local directory, so each backup only stores the changed chunks
+	Repository string `json:"repository,omitempty"`
+}
+
+// Target stores the backup archives by name.
+type Target interface {
+	// Put stores the archive of the size, it replaces the archive with the same name.
+	Put(name string, archive io.Reader, size int64) error
+
+	// Get opens the archive, the error wraps fs.ErrNotExist if it doesn't exist.
+	Get(name string) (io.ReadCloser, error)
+
+	// List lists the names of all archives.
+	List() ([]string, error)
+
+	// Delete deletes the archive, the error wraps fs.ErrNotExist if it doesn't exist.
+	Delete(name string) error
+}
+
+// NewTarget creates the target according to the options.
+func NewTarget(opts *Options) (Target, error) {
+	specified := 0
+	for _, isSpecified := range []bool{opts.Directory != "", opts.S3 != nil, opts.Repository != ""} {
+		if isSpecified {
+			specified++
+		}
+	}
+
+	switch {
+	case specified > 1:
+		return nil, fmt.Errorf("only one of directory, s3 and repository can be specified as backup target")
+	case opts.Directory != "":
+		return NewDirectoryTarget(opts.Directory)
+	case opts.S3 != nil:
+		return NewS3Target(opts.S3)
+	case opts.Repository != "":
+		return NewRepository(opts.Repository)
+	default:
+		return nil, fmt.Errorf("no backup target is specified")
+	}
+}
+
+// Backup writes the backup archive of the volume to the target and returns its name. The archive is written to a temporary file first,
+// since the size of the archive must be known to upload it, except for a repository which the archive is streamed into.
+func Backup(s *storage.Builtin, target Target, name string, quiesce bool) (string, *storage.BackupManifest, error) {
+	if repository, ok := target.(*Repository); ok {
+		return backupToRepository(s, repository, name, quiesce)
+	}
+
+	file, err := os.CreateTemp("", "backup-*"+archiveSuffix)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary archive: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	manifest, err := s.BackupVolume(name, file, quiesce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to rewind temporary archive: %v", err)
+	}
+
+	archive := ArchiveName(name, manifest.CreatedAt)
+	err = target.Put(archive, file, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store backup archive %s: %v", archive, err)
+	}
+	return archive, manifest, nil
+}
+
+// backupToRepository streams the backup archive of the volume into the repository, the archive is named after it is written
+// since the name contains the creation time of the backup.
+func backupToRepository(s *storage.Builtin, repository *Repository, name string, quiesce bool) (string, *storage.BackupManifest, error) {
+	reader, writer := io.Pipe()
+	var manifest *storage.BackupManifest
+	var backupErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		manifest, backupErr = s.BackupVolume(name, writer, quiesce)
+		writer.CloseWithError(backupErr)
+	}()
+
+	archive, err := repository.putStream(reader, func() (string, error) {
+		<-done
+		if backupErr != nil {
+			return "", backupErr
+		}
+		return ArchiveName(name, manifest.CreatedAt), nil
+	})
+	// Unblock the backup if the repository stopped reading early
+	reader.CloseWithError(err)
+	<-done
+	if backupErr != nil {
+		return "", nil, backupErr
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store backup archive: %v", err)
+	}
+	return archive, manifest, nil
+}
+
+// Restore creates the volume from the backup archive in the target.
+func Restore(s *storage.Builtin, target Target, archive string, name string) (*storage.BackupManifest, error) {
+	if err := validateArchiveName(archive); err != nil {
+		return nil, err
+	}
+
+	reader, err := target.Get(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup archive %s: %w", archive, err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	return s.RestoreVolumeFromBackup(name, reader)
+}
+
+// ArchiveName returns the name of the backup archive of the volume created at the time.
+func ArchiveName(volume string, createdAt time.Time) string {
+	return fmt.Sprintf("%s-%s%s", volume, createdAt.UTC().Format(archiveTimeFormat), archiveSuffix)
+}
+
+// Archive is a backup archive in a target.
+type Archive struct {
+	// Name of the archive
+	Name string
+
+	// Volume is the name of the backed up volume
+	Volume string
+
+	// CreatedAt is when the backup was started
+	CreatedAt time.Time
+}
+
+// ListArchives lists the archives in the target sorted by name, the names which aren't created by Backup are skipped.
+func ListArchives(target Target) ([]*Archive, error) {
+	names, err := target.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	archives := make([]*Archive, 0, len(names))
+	for _, name := range names {
+		archive, ok := parseArchiveName(name)
+		if ok {
+			archives = append(archives, archive)
+		}
+	}
+	return archives, nil
+}
+
+// Prune deletes the archives of the volume in the target but the newest keep ones, and returns the names of the deleted archives.
+func Prune(target Target, volume string, keep int) ([]string, error) {
+	archives, err := ListArchives(target)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeArchives := []*Archive{}
+	for _, archive := range archives {
+		if archive.Volume == volume {
+			volumeArchives = append(volumeArchives, archive)
+		}
+	}
+	sort.Slice(volumeArchives, func(i, j int) bool {
+		return volumeArchives[i].CreatedAt.After(volumeArchives[j].CreatedAt)
+	})
+
+	deleted := []string{}
+	for i := keep; i < len(volumeArchives); i++ {
+		if err := target.Delete(volumeArchives[i].Name); err != nil {
+			return deleted, fmt.Errorf("failed to delete backup archive %s: %v", volumeArchives[i].Name, err)
+		}
+		deleted = append(deleted, volumeArchives[i].Name)
+	}
+	return deleted, nil
+}
+
+// parseArchiveName parses the volume name and the creation time from the archive name.
+func parseArchiveName(name string) (*Archive, bool) {
+	trimmed, found := strings.CutSuffix(name, archiveSuffix)
+	index := strings.LastIndex(trimmed, "-")
+	if !found || index <= 0 {
+		return nil, false
+	}
+
+	createdAt, err := time.Parse(archiveTimeFormat, trimmed[index+1:])
+	if err != nil {
+		createdAt, err = time.Parse(legacyArchiveTimeFormat, trimmed[index+1:])
+		if err != nil {
+			return nil, false
+		}
+	}
+	return &Archive{Name: name, Volume: trimmed[:index], CreatedAt: createdAt}, true
+}
+
+// validateArchiveName rejects the archive names which aren't plain file names.
+func validateArchiveName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("invalid backup archive name %q", name)
+	}
+	return nil
+}
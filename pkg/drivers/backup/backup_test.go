@@ -0,0 +1,415 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 is an in-memory S3 endpoint which serves the requests of the s3 target.
+type fakeS3 struct {
+	objects map[string][]byte
+	mutex   sync.Mutex
+}
+
+func (server *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		server.objects[r.URL.Path] = data
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		_, _ = io.WriteString(w, "<ListBucketResult>")
+		for key := range server.objects {
+			if strings.HasPrefix(key, r.URL.Path+r.URL.Query().Get("prefix")) {
+				_, _ = io.WriteString(w, "<Contents><Key>"+strings.TrimPrefix(key, r.URL.Path)+"</Key></Contents>")
+			}
+		}
+		_, _ = io.WriteString(w, "</ListBucketResult>")
+	case r.Method == http.MethodGet:
+		data, existed := server.objects[r.URL.Path]
+		if !existed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(server.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	createdAt := time.Date(2026, 10, 19, 9, 30, 0, 482913005, time.UTC)
+	name := ArchiveName("my-volume", createdAt)
+	assert.Equal(t, "my-volume-20261019T093000.482913005Z.tar.zst", name)
+	assert.NotEqual(t, name, ArchiveName("my-volume", createdAt.Add(time.Millisecond)))
+
+	testCases := []struct {
+		description string
+		name        string
+		expected    *Archive
+	}{
+		{
+			description: "nanoseconds",
+			name:        name,
+			expected:    &Archive{Name: name, Volume: "my-volume", CreatedAt: createdAt},
+		},
+		{
+			description: "legacy seconds",
+			name:        "my-volume-20261019T093000Z.tar.zst",
+			expected:    &Archive{Name: "my-volume-20261019T093000Z.tar.zst", Volume: "my-volume", CreatedAt: createdAt.Truncate(time.Second)},
+		},
+		{
+			description: "invalid time",
+			name:        "my-volume-latest.tar.zst",
+		},
+		{
+			description: "invalid suffix",
+			name:        "my-volume-20261019T093000Z.tar",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			archive, ok := parseArchiveName(testCase.name)
+			assert.Equal(t, testCase.expected != nil, ok)
+			assert.Equal(t, testCase.expected, archive)
+		})
+	}
+}
+
+func TestTargets(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+
+	testCases := []struct {
+		description string
+		opts        *Options
+		expectErr   bool
+	}{
+		{
+			description: "directory",
+			opts:        &Options{Directory: t.TempDir()},
+		},
+		{
+			description: "s3",
+			opts:        &Options{S3: &S3Options{Endpoint: server.URL, Bucket: "backups", Prefix: "cluster-a", AccessKey: "access", SecretKey: "secret"}},
+		},
+		{
+			description: "repository",
+			opts:        &Options{Repository: t.TempDir()},
+		},
+		{
+			description: "directory and repository",
+			opts:        &Options{Directory: t.TempDir(), Repository: t.TempDir()},
+			expectErr:   true,
+		},
+		{
+			description: "no target",
+			opts:        &Options{},
+			expectErr:   true,
+		},
+		{
+			description: "s3 without bucket",
+			opts:        &Options{S3: &S3Options{Endpoint: server.URL}},
+			expectErr:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			target, err := NewTarget(testCase.opts)
+			if testCase.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			s := storage.NewBuiltin(log.New("test"), t.TempDir())
+			defer func() {
+				assert.NoError(t, s.Close())
+			}()
+			assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{}, false))
+			metadata, err := s.FetchVolumeMetadata("test")
+			assert.NoError(t, err)
+
+			// Test Backup and ListArchives
+			archive, _, err := Backup(s, target, "test", false)
+			assert.NoError(t, err)
+			archives, err := ListArchives(target)
+			assert.NoError(t, err)
+			assert.Len(t, archives, 1)
+			assert.Equal(t, archive, archives[0].Name)
+			assert.Equal(t, "test", archives[0].Volume)
+
+			// Test Restore
+			_, err = Restore(s, target, archive, "restored")
+			assert.NoError(t, err)
+			restored, err := s.FetchVolumeMetadata("restored")
+			assert.NoError(t, err)
+			assert.Equal(t, metadata.CreatedAt.Unix(), restored.CreatedAt.Unix())
+
+			// Test Restore of an archive which doesn't exist
+			_, err = Restore(s, target, "non-exist"+archiveSuffix, "other")
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+			_, err = Restore(s, target, "../escaped", "other")
+			assert.Error(t, err)
+
+			// Test Prune
+			deleted, err := Prune(target, "test", 0)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{archive}, deleted)
+			archives, err = ListArchives(target)
+			assert.NoError(t, err)
+			assert.Empty(t, archives)
+		})
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	logger := log.New("test")
+	rootPath := t.TempDir()
+	s := storage.NewBuiltin(logger, rootPath)
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+	assert.NoError(t, s.CreateVolume("labeled", &apis.VolumeSpec{Labels: map[string]string{"backup": "daily"}}, false))
+	assert.NoError(t, s.CreateVolume("listed", &apis.VolumeSpec{}, false))
+	assert.NoError(t, s.CreateVolume("other", &apis.VolumeSpec{}, false))
+
+	targetPath := t.TempDir()
+	target, err := NewDirectoryTarget(targetPath)
+	assert.NoError(t, err)
+	for _, createdAt := range []time.Time{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)} {
+		assert.NoError(t, os.WriteFile(path.Join(targetPath, ArchiveName("labeled", createdAt)), nil, 0644))
+	}
+	brokenPath := path.Join(t.TempDir(), "broken")
+
+	daily, err := apis.ParseCronSchedule("@daily")
+	assert.NoError(t, err)
+	selector, err := apis.ParseSelector("backup=daily")
+	assert.NoError(t, err)
+	schedules := []*Schedule{
+		{Name: "daily", Cron: *daily, Volumes: []string{"listed"}, Selector: selector, Keep: 2},
+		{Name: "broken", Cron: *daily, Volumes: []string{"other"}, Target: &Options{Directory: brokenPath}},
+	}
+
+	// Test the validation of the schedules
+	invalidSchedules := []*Schedule{
+		{Name: "", Cron: *daily, Volumes: []string{"listed"}},
+		{Name: "no-cron", Volumes: []string{"listed"}},
+		{Name: "no-volumes", Cron: *daily},
+		{Name: "negative-keep", Cron: *daily, Volumes: []string{"listed"}, Keep: -1},
+	}
+	for _, schedule := range invalidSchedules {
+		_, err = NewScheduler(logger, s, rootPath, []*Schedule{schedule}, target)
+		assert.Error(t, err, schedule.Name)
+	}
+	_, err = NewScheduler(logger, s, rootPath, []*Schedule{schedules[0], schedules[0]}, target)
+	assert.Error(t, err)
+	_, err = NewScheduler(logger, s, rootPath, schedules[:1], nil)
+	assert.Error(t, err)
+
+	scheduler, err := NewScheduler(logger, s, rootPath, schedules, target)
+	assert.NoError(t, err)
+	assert.NoError(t, os.RemoveAll(brokenPath))
+
+	// The first run only records the time, and the next run isn't due until the next day
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, scheduler.Run(now))
+	assert.NoError(t, scheduler.Run(now.Add(time.Hour)))
+	states, err := ReadScheduleStates(rootPath)
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+	assert.Empty(t, states["daily"].History)
+	assert.Equal(t, now, states["daily"].LastScheduledAt)
+
+	// The missed runs are skipped
+	assert.NoError(t, scheduler.Run(now.Add(37*time.Hour)))
+	states, err = ReadScheduleStates(rootPath)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC), states["daily"].LastScheduledAt)
+	history := states["daily"].History
+	assert.Len(t, history, 2)
+	assert.Equal(t, "labeled", history[0].Volume)
+	assert.Equal(t, "listed", history[1].Volume)
+	assert.Empty(t, history[0].Error)
+	assert.Equal(t, []string{ArchiveName("labeled", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}, history[0].Pruned)
+	assert.Len(t, states["broken"].History, 1)
+	assert.NotEmpty(t, states["broken"].History[0].Error)
+
+	archives, err := ListArchives(target)
+	assert.NoError(t, err)
+	assert.Len(t, archives, 3)
+
+	// Test the backup status of the volumes
+	metadata, err := s.FetchVolumeMetadata("labeled")
+	assert.NoError(t, err)
+	assert.NotNil(t, metadata.Status.Backup.LastSuccess)
+	assert.Equal(t, history[0].Archive, metadata.Status.Backup.LastArchive)
+	assert.Nil(t, metadata.Status.Backup.LastFailure)
+	metadata, err = s.FetchVolumeMetadata("other")
+	assert.NoError(t, err)
+	assert.Nil(t, metadata.Status.Backup.LastSuccess)
+	assert.NotNil(t, metadata.Status.Backup.LastFailure)
+	assert.NotEmpty(t, metadata.Status.Backup.LastError)
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	cut := func(data []byte) []string {
+		chunks := []string{}
+		chunker := newChunker(bytes.NewReader(data))
+		for {
+			chunk, err := chunker.next()
+			if err == io.EOF {
+				return chunks
+			}
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, len(chunk), maxChunkSize)
+			chunks = append(chunks, string(chunk))
+		}
+	}
+
+	chunks := cut(data)
+	assert.Equal(t, string(data), strings.Join(chunks, ""))
+	for _, chunk := range chunks[:len(chunks)-1] {
+		assert.GreaterOrEqual(t, len(chunk), minChunkSize)
+	}
+
+	// An insertion only changes the chunks around it
+	inserted := append(append(append([]byte{}, data[:1<<20]...), "inserted"...), data[1<<20:]...)
+	insertedChunks := cut(inserted)
+	assert.Equal(t, string(inserted), strings.Join(insertedChunks, ""))
+	common := 0
+	for _, chunk := range insertedChunks {
+		for _, original := range chunks {
+			if chunk == original {
+				common++
+				break
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, common, len(chunks)-3)
+
+	assert.Empty(t, cut(nil))
+}
+
+func TestRepository(t *testing.T) {
+	repositoryPath := t.TempDir()
+	repository, err := NewRepository(repositoryPath)
+	assert.NoError(t, err)
+
+	rootPath := t.TempDir()
+	s := storage.NewBuiltin(log.New("test"), rootPath)
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{}, false))
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	filePath := path.Join(rootPath, "test", "_data", "file")
+	assert.NoError(t, os.WriteFile(filePath, data, 0644))
+
+	countChunks := func() int {
+		count := 0
+		assert.NoError(t, repository.walkChunks(func(hash string, chunkPath string) error {
+			count++
+			return nil
+		}))
+		return count
+	}
+
+	// The archive is streamed into the repository without a temporary archive
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	_, _, err = Backup(s, repository, "missing", false)
+	assert.Error(t, err)
+	names, err := repository.List()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	// The second backup only stores the changed chunks
+	first, _, err := Backup(s, repository, "test", false)
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	chunks := countChunks()
+	copy(data[4<<20:], "changed")
+	assert.NoError(t, os.WriteFile(filePath, data, 0644))
+	second, _, err := Backup(s, repository, "test", false)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.LessOrEqual(t, countChunks(), chunks+4)
+
+	// Both backups can be restored
+	_, err = Restore(s, repository, first, "first")
+	assert.NoError(t, err)
+	restored, err := os.ReadFile(path.Join(rootPath, "first", "_data", "file"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, data, restored)
+	_, err = Restore(s, repository, second, "second")
+	assert.NoError(t, err)
+	restored, err = os.ReadFile(path.Join(rootPath, "second", "_data", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, restored)
+
+	result, err := repository.Verify()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Archives)
+	assert.Equal(t, countChunks(), result.Chunks)
+	assert.Empty(t, result.Problems)
+
+	// Deleting a backup collects the chunks only it references
+	assert.NoError(t, repository.Delete(first))
+	assert.LessOrEqual(t, countChunks(), chunks)
+	_, err = Restore(s, repository, first, "deleted")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// Verify detects the corrupt chunks and the archives referencing them
+	manifest, err := repository.readManifest(second)
+	assert.NoError(t, err)
+	var hash string
+	for _, entry := range manifest.Entries {
+		if len(entry.Chunks) > 0 {
+			hash = entry.Chunks[0]
+		}
+	}
+	assert.NoError(t, os.WriteFile(repository.chunkPath(hash), []byte("corrupt"), 0644))
+	result, err = repository.Verify()
+	assert.NoError(t, err)
+	assert.Len(t, result.Problems, 2)
+	_, err = Restore(s, repository, second, "corrupt")
+	assert.Error(t, err)
+	_, err = os.Stat(path.Join(rootPath, "corrupt"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"io"
+)
+
+const (
+	// minChunkSize, chunkMask and maxChunkSize bound the chunks, the average size of the chunks is about 1MiB
+	minChunkSize = 256 << 10
+	chunkMask    = 1<<20 - 1
+	maxChunkSize = 4 << 20
+)
+
+// gearTable maps the bytes to the random values of the gear hash, it is generated from a fixed seed and must never change,
+// otherwise the chunks of the same content are cut differently and no longer deduplicated.
+var gearTable = func() [256]uint64 {
+	table := [256]uint64{}
+	seed := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		value := seed
+		value = (value ^ value>>30) * 0xbf58476d1ce4e5b9
+		value = (value ^ value>>27) * 0x94d049bb133111eb
+		table[i] = value ^ value>>31
+	}
+	return table
+}()
+
+// chunker cuts a stream into content-defined chunks by a gear rolling hash, so an insertion only changes the chunks around it.
+type chunker struct {
+	reader io.Reader
+	buffer []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+func newChunker(reader io.Reader) *chunker {
+	return &chunker{reader: reader, buffer: make([]byte, 2*maxChunkSize)}
+}
+
+// next returns the next chunk, which is only valid until the next call, or io.EOF after the last chunk.
+func (c *chunker) next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	data := c.buffer[c.start:c.end]
+	size := len(data)
+	if size > maxChunkSize {
+		size = maxChunkSize
+	}
+	if size > minChunkSize {
+		var hash uint64
+		for i := minChunkSize; i < size; i++ {
+			hash = hash<<1 + gearTable[data[i]]
+			if hash&chunkMask == 0 {
+				size = i + 1
+				break
+			}
+		}
+	}
+
+	c.start += size
+	return data[:size], nil
+}
+
+// fill reads the stream until the buffer holds a whole chunk of the maximum size or the stream ends.
+func (c *chunker) fill() error {
+	if c.eof || c.end-c.start >= maxChunkSize {
+		return nil
+	}
+
+	copy(c.buffer, c.buffer[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+	for c.end < len(c.buffer) {
+		n, err := c.reader.Read(c.buffer[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+// directoryTarget keeps the archives in a local directory, e.g. a mounted backup share.
+type directoryTarget struct {
+	path string
+}
+
+// NewDirectoryTarget creates the target of the directory, the directory is created if it doesn't exist.
+func NewDirectoryTarget(dirPath string) (Target, error) {
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	return &directoryTarget{path: dirPath}, nil
+}
+
+// Put writes the archive to a temporary file and renames it, so a partial archive is never seen by its name.
+func (target *directoryTarget) Put(name string, archive io.Reader, size int64) error {
+	if err := validateArchiveName(name); err != nil {
+		return err
+	}
+
+	_, err := utils.CopyFileAtomic(path.Join(target.path, name), &sizedReader{reader: archive, size: size}, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %v", name, err)
+	}
+	return nil
+}
+
+func (target *directoryTarget) Get(name string) (io.ReadCloser, error) {
+	if err := validateArchiveName(name); err != nil {
+		return nil, err
+	}
+	return os.Open(path.Join(target.path, name))
+}
+
+func (target *directoryTarget) List() ([]string, error) {
+	entries, err := os.ReadDir(target.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %v", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (target *directoryTarget) Delete(name string) error {
+	if err := validateArchiveName(name); err != nil {
+		return err
+	}
+	return os.Remove(path.Join(target.path, name))
+}
+
+// sizedReader fails the read at the end of the reader if it didn't read exactly the size.
+type sizedReader struct {
+	reader io.Reader
+	size   int64
+	count  int64
+}
+
+func (reader *sizedReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.count += int64(n)
+	if reader.count > reader.size || (err == io.EOF && reader.count != reader.size) {
+		return n, fmt.Errorf("read %d bytes, expected %d", reader.count, reader.size)
+	}
+	return n, err
+}
@@ -0,0 +1,468 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+const (
+	// RepositoryFormatVersion is the version of the manifests in the repository
+	RepositoryFormatVersion = 1
+
+	repositoryChunksDirName    = "chunks"
+	repositoryManifestsDirName = "manifests"
+	repositoryLockName         = ".lock"
+	repositoryManifestSuffix   = ".json"
+)
+
+// repositoryManifest records the entries of an archive, the content of the regular files is recorded as the hashes of their chunks.
+type repositoryManifest struct {
+	// Version of the manifest format
+	Version int `json:"version"`
+
+	// CreatedAt is when the archive was stored
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Entries of the archive in their order
+	Entries []*repositoryEntry `json:"entries"`
+}
+
+// repositoryEntry is an entry of an archive.
+type repositoryEntry struct {
+	Name    string    `json:"name"`
+	Type    byte      `json:"type"`
+	Mode    int64     `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	Link    string    `json:"link,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Chunks  []string  `json:"chunks,omitempty"`
+}
+
+// Repository keeps the archives deduplicated in a directory: the regular files in the archives are cut into content-defined chunks,
+// which are stored once by their SHA-256 in chunks/, and each archive is a manifest of its entries in manifests/.
+// So only the changed chunks are written by each backup, and every archive can be reconstructed as long as its manifest exists.
+type Repository struct {
+	path    string
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewRepository creates the repository in the directory, the directory is created if it doesn't exist.
+func NewRepository(dirPath string) (*Repository, error) {
+	for _, dirName := range []string{repositoryChunksDirName, repositoryManifestsDirName} {
+		if err := os.MkdirAll(path.Join(dirPath, dirName), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create backup repository: %v", err)
+		}
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %v", err)
+	}
+	return &Repository{path: dirPath, encoder: encoder, decoder: decoder}, nil
+}
+
+// Put stores the chunks of the archive which aren't in the repository yet, and then its manifest.
+func (repository *Repository) Put(name string, archive io.Reader, size int64) error {
+	if err := validateArchiveName(name); err != nil {
+		return err
+	}
+
+	counter := &countingReader{reader: archive}
+	_, err := repository.putStream(counter, func() (string, error) {
+		if counter.count != size {
+			return "", fmt.Errorf("read %d bytes of archive %s, expected %d", counter.count, name, size)
+		}
+		return name, nil
+	})
+	return err
+}
+
+// putStream stores the chunks of the archive of unknown size, and then its manifest by the name returned by the name function
+// once the archive is read completely, so the name may depend on how the archive was written.
+func (repository *Repository) putStream(archive io.Reader, name func() (string, error)) (string, error) {
+	unlock, err := repository.acquireLock(true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	decoder, err := zstd.NewReader(archive)
+	if err != nil {
+		return "", fmt.Errorf("failed to create zstd decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	manifest := &repositoryManifest{Version: RepositoryFormatVersion, CreatedAt: time.Now(), Entries: []*repositoryEntry{}}
+	reader := tar.NewReader(decoder)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		entry := &repositoryEntry{Name: header.Name, Type: header.Typeflag, Mode: header.Mode, ModTime: header.ModTime, Link: header.Linkname}
+		if header.Typeflag == tar.TypeReg {
+			entry.Size = header.Size
+			entry.Chunks, err = repository.putChunks(reader)
+			if err != nil {
+				return "", fmt.Errorf("failed to store %s of archive: %v", header.Name, err)
+			}
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	if _, err := io.Copy(io.Discard, archive); err != nil {
+		return "", fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	archiveName, err := name()
+	if err != nil {
+		return "", err
+	}
+	if err := validateArchiveName(archiveName); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest of archive %s: %v", archiveName, err)
+	}
+	return archiveName, utils.WriteFileAtomic(repository.manifestPath(archiveName), data, 0644)
+}
+
+// putChunks stores the chunks of the content which aren't in the repository yet and returns their hashes.
+func (repository *Repository) putChunks(content io.Reader) ([]string, error) {
+	hashes := []string{}
+	chunker := newChunker(content)
+	for {
+		chunk, err := chunker.next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		hashes = append(hashes, hash)
+		chunkPath := repository.chunkPath(hash)
+		if _, err := os.Stat(chunkPath); err == nil {
+			continue
+		}
+
+		if err := os.MkdirAll(path.Dir(chunkPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := utils.WriteFileAtomic(chunkPath, repository.encoder.EncodeAll(chunk, nil), 0644); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Get reconstructs the archive from its manifest and chunks, the chunks are verified against their hashes while they are read.
+func (repository *Repository) Get(name string) (io.ReadCloser, error) {
+	manifest, err := repository.readManifest(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(repository.writeArchive(writer, manifest))
+	}()
+	return reader, nil
+}
+
+// writeArchive writes the archive of the manifest as a zstd compressed tar.
+func (repository *Repository) writeArchive(archive io.Writer, manifest *repositoryManifest) error {
+	encoder, err := zstd.NewWriter(archive)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+	writer := tar.NewWriter(encoder)
+	for _, entry := range manifest.Entries {
+		header := &tar.Header{Name: entry.Name, Typeflag: entry.Type, Mode: entry.Mode, ModTime: entry.ModTime, Linkname: entry.Link, Size: entry.Size}
+		if err = writer.WriteHeader(header); err != nil {
+			break
+		}
+		for _, hash := range entry.Chunks {
+			var chunk []byte
+			chunk, err = repository.readChunk(hash)
+			if err == nil {
+				_, err = writer.Write(chunk)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := encoder.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// List lists the names of the archives which have manifests.
+func (repository *Repository) List() ([]string, error) {
+	entries, err := os.ReadDir(path.Join(repository.path, repositoryManifestsDirName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup repository: %v", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), repositoryManifestSuffix)
+		if found && entry.Type().IsRegular() && !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Delete deletes the manifest of the archive and the chunks which are no longer referenced by any manifest.
+func (repository *Repository) Delete(name string) error {
+	if err := validateArchiveName(name); err != nil {
+		return err
+	}
+	unlock, err := repository.acquireLock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(repository.manifestPath(name)); err != nil {
+		return err
+	}
+
+	referenced, err := repository.referencedChunks()
+	if err != nil {
+		return err
+	}
+	return repository.walkChunks(func(hash string, chunkPath string) error {
+		if referenced[hash] != nil {
+			return nil
+		}
+		return os.Remove(chunkPath)
+	})
+}
+
+// VerifyResult is the result of verifying a repository.
+type VerifyResult struct {
+	// Archives is the number of the verified archives
+	Archives int
+
+	// Chunks is the number of the verified chunks
+	Chunks int
+
+	// Problems are the corrupt and missing chunks, and the archives which can't be restored because of them
+	Problems []string
+}
+
+// Verify re-hashes all stored chunks and checks the chunks referenced by the manifests exist and are intact.
+func (repository *Repository) Verify() (*VerifyResult, error) {
+	unlock, err := repository.acquireLock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	referenced, err := repository.referencedChunks()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{Problems: []string{}}
+	intact := map[string]bool{}
+	err = repository.walkChunks(func(hash string, chunkPath string) error {
+		result.Chunks++
+		_, err := repository.readChunk(hash)
+		if err != nil {
+			result.Problems = append(result.Problems, err.Error())
+		}
+		intact[hash] = err == nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	broken := map[string]bool{}
+	for hash, names := range referenced {
+		if intact[hash] {
+			continue
+		}
+		if _, existed := intact[hash]; !existed {
+			result.Problems = append(result.Problems, fmt.Sprintf("chunk %s is missing", hash))
+		}
+		for _, name := range names {
+			broken[name] = true
+		}
+	}
+	for name := range broken {
+		result.Problems = append(result.Problems, fmt.Sprintf("archive %s can't be restored because of missing or corrupt chunks", name))
+	}
+	sort.Strings(result.Problems)
+
+	names, err := repository.List()
+	if err != nil {
+		return nil, err
+	}
+	result.Archives = len(names)
+	return result, nil
+}
+
+// referencedChunks returns the names of the archives referencing each chunk.
+func (repository *Repository) referencedChunks() (map[string][]string, error) {
+	names, err := repository.List()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string][]string{}
+	for _, name := range names {
+		manifest, err := repository.readManifest(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range manifest.Entries {
+			for _, hash := range entry.Chunks {
+				if len(referenced[hash]) == 0 || referenced[hash][len(referenced[hash])-1] != name {
+					referenced[hash] = append(referenced[hash], name)
+				}
+			}
+		}
+	}
+	return referenced, nil
+}
+
+// walkChunks calls the function with the hash and the path of each stored chunk.
+func (repository *Repository) walkChunks(fn func(hash string, chunkPath string) error) error {
+	chunksPath := path.Join(repository.path, repositoryChunksDirName)
+	prefixes, err := os.ReadDir(chunksPath)
+	if err != nil {
+		return fmt.Errorf("failed to read chunks: %v", err)
+	}
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		chunks, err := os.ReadDir(path.Join(chunksPath, prefix.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read chunks: %v", err)
+		}
+		for _, chunk := range chunks {
+			if strings.HasPrefix(chunk.Name(), ".") {
+				continue
+			}
+			if err := fn(chunk.Name(), path.Join(chunksPath, prefix.Name(), chunk.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readChunk reads the chunk and verifies its hash.
+func (repository *Repository) readChunk(hash string) ([]byte, error) {
+	compressed, err := os.ReadFile(repository.chunkPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %v", hash, err)
+	}
+
+	chunk, err := repository.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is corrupt: %v", hash, err)
+	}
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("chunk %s is corrupt: hash mismatch", hash)
+	}
+	return chunk, nil
+}
+
+// readManifest reads the manifest of the archive, the error wraps fs.ErrNotExist if it doesn't exist.
+func (repository *Repository) readManifest(name string) (*repositoryManifest, error) {
+	if err := validateArchiveName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(repository.manifestPath(name))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &repositoryManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of archive %s: %v", name, err)
+	}
+	if manifest.Version > RepositoryFormatVersion {
+		return nil, fmt.Errorf("manifest format version %d of archive %s is newer than the supported version %d", manifest.Version, name, RepositoryFormatVersion)
+	}
+	return manifest, nil
+}
+
+func (repository *Repository) manifestPath(name string) string {
+	return path.Join(repository.path, repositoryManifestsDirName, name+repositoryManifestSuffix)
+}
+
+// chunkPath returns the path of the chunk, the chunks are spread into directories by the first byte of their hashes.
+func (repository *Repository) chunkPath(hash string) string {
+	if len(hash) < 2 || strings.ContainsAny(hash, "/\\.") {
+		return path.Join(repository.path, repositoryChunksDirName, "invalid")
+	}
+	return path.Join(repository.path, repositoryChunksDirName, hash[:2], hash)
+}
+
+// acquireLock acquires the lock of the repository, the archives are stored and verified under the shared lock,
+// and deleted under the exclusive lock, so the chunks are never collected while they are reused.
+func (repository *Repository) acquireLock(shared bool) (func(), error) {
+	lock := flock.New(path.Join(repository.path, repositoryLockName))
+	var err error
+	if shared {
+		err = lock.RLock()
+	} else {
+		err = lock.Lock()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock of backup repository: %v", err)
+	}
+	return func() {
+		_ = lock.Unlock()
+	}, nil
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,281 @@
+package backup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region   = "us-east-1"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+)
+
+// S3Options configures a bucket of an S3-compatible endpoint, e.g. a local MinIO, which is addressed in path style.
+type S3Options struct {
+	// Endpoint is the URL of the S3 service, e.g. http://minio.local:9000
+	Endpoint string `json:"endpoint"`
+
+	// Bucket of the archives
+	Bucket string `json:"bucket"`
+
+	// Prefix of the archive keys
+	Prefix string `json:"prefix,omitempty"`
+
+	// Region used to sign the requests, default to us-east-1
+	Region string `json:"region,omitempty"`
+
+	// AccessKey is the access key id, default to the AWS_ACCESS_KEY_ID environment variable
+	AccessKey string `json:"accessKey,omitempty"`
+
+	// SecretKey is the secret access key, default to the AWS_SECRET_ACCESS_KEY environment variable
+	SecretKey string `json:"secretKey,omitempty"`
+}
+
+// s3Target keeps the archives in a bucket, the requests are signed by AWS signature version 4.
+type s3Target struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Target creates the target of the bucket.
+func NewS3Target(opts *S3Options) (Target, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("endpoint and bucket of s3 backup target are required")
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint of s3 backup target %q", opts.Endpoint)
+	}
+
+	target := &s3Target{opts: *opts, endpoint: endpoint, client: &http.Client{}}
+	if target.opts.Region == "" {
+		target.opts.Region = defaultS3Region
+	}
+	if target.opts.AccessKey == "" {
+		target.opts.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if target.opts.SecretKey == "" {
+		target.opts.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	target.opts.Prefix = strings.Trim(target.opts.Prefix, "/")
+	return target, nil
+}
+
+func (target *s3Target) Put(name string, archive io.Reader, size int64) error {
+	if err := validateArchiveName(name); err != nil {
+		return err
+	}
+
+	request, err := target.newRequest(http.MethodPut, target.key(name), nil, archive)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	response, err := target.do(request)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (target *s3Target) Get(name string) (io.ReadCloser, error) {
+	if err := validateArchiveName(name); err != nil {
+		return nil, err
+	}
+
+	request, err := target.newRequest(http.MethodGet, target.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := target.do(request)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (target *s3Target) Delete(name string) error {
+	if err := validateArchiveName(name); err != nil {
+		return err
+	}
+
+	request, err := target.newRequest(http.MethodDelete, target.key(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	response, err := target.do(request)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// s3ListResult is the result of the ListObjectsV2 request.
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (target *s3Target) List() ([]string, error) {
+	prefix := target.key("")
+	names := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		request, err := target.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		response, err := target.do(request)
+		if err != nil {
+			return nil, err
+		}
+		result := &s3ListResult{}
+		err = xml.NewDecoder(response.Body).Decode(result)
+		_ = response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse list result: %v", err)
+		}
+
+		for _, content := range result.Contents {
+			names = append(names, strings.TrimPrefix(content.Key, prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// key returns the object key of the archive.
+func (target *s3Target) key(name string) string {
+	if target.opts.Prefix == "" {
+		return name
+	}
+	return target.opts.Prefix + "/" + name
+}
+
+// newRequest creates the signed request of the object key in the bucket, the payload is not signed so it can be streamed.
+func (target *s3Target) newRequest(method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	requestURL := *target.endpoint
+	basePath := strings.TrimSuffix(requestURL.Path, "/")
+	requestURL.Path = basePath + "/" + target.opts.Bucket + "/" + key
+	// Escape the path as the signature requires, the escaping of url.URL keeps some reserved characters
+	segments := strings.Split(target.opts.Bucket+"/"+key, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	requestURL.RawPath = basePath + "/" + strings.Join(segments, "/")
+	requestURL.RawQuery = canonicalQuery(query)
+
+	request, err := http.NewRequest(method, requestURL.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request: %v", err)
+	}
+	target.sign(request, time.Now().UTC())
+	return request, nil
+}
+
+// do sends the request and converts the error responses to errors, a missing object wraps fs.ErrNotExist.
+func (target *s3Target) do(request *http.Request) (*http.Response, error) {
+	response, err := target.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send s3 request: %v", err)
+	}
+	if response.StatusCode/100 == 2 {
+		return response, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	_ = response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3 object %s: %w", request.URL.Path, fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("s3 request %s %s failed with status %s: %s", request.Method, request.URL.Path, response.Status, strings.TrimSpace(string(message)))
+}
+
+// sign signs the request by AWS signature version 4, the requests are sent anonymously without credentials.
+func (target *s3Target) sign(request *http.Request, now time.Time) {
+	request.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	request.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	if target.opts.AccessKey == "" {
+		return
+	}
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", request.URL.Host, s3UnsignedPayload, now.Format(s3TimeFormat))
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		s3UnsignedPayload,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + target.opts.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", now.Format(s3TimeFormat), scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+target.opts.SecretKey), date)
+	key = hmacSHA256(key, target.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		target.opts.AccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// canonicalQuery encodes the query sorted by key with the escaping required by the signature.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape escapes everything but the unreserved characters, as required by the signature.
+func s3Escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,327 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+const (
+	// schedulerDirName is the directory in the root path which keeps the locks and the states of the schedules
+	schedulerDirName = ".scheduler"
+
+	// maxJobHistory is the number of job records kept in the state of a schedule
+	maxJobHistory = 100
+)
+
+// Schedule backs up the selected volumes periodically and keeps the newest archives of each volume.
+type Schedule struct {
+	// Name identifies the schedule on all nodes
+	Name string `json:"name"`
+
+	// Cron is when the backups run, in UTC
+	Cron apis.CronSchedule `json:"cron"`
+
+	// Volumes are the names of the volumes to back up
+	Volumes []string `json:"volumes,omitempty"`
+
+	// Selector selects the volumes to back up by their labels, in addition to the volumes
+	Selector *apis.Selector `json:"selector,omitempty"`
+
+	// Keep is the number of the newest archives of each volume kept in the target, zero keeps all of them
+	Keep int `json:"keep,omitempty"`
+
+	// Quiesce indicates whether to hold the metadata lock of each volume during its backup
+	Quiesce bool `json:"quiesce,omitempty"`
+
+	// Target of the archives, default to the backup target of the driver
+	Target *Options `json:"target,omitempty"`
+}
+
+// ScheduleState is the state of a schedule shared by all nodes.
+type ScheduleState struct {
+	// LastScheduledAt is the scheduled time of the last run
+	LastScheduledAt time.Time `json:"lastScheduledAt"`
+
+	// History is the records of the last jobs, the oldest first
+	History []*JobRecord `json:"history"`
+}
+
+// JobRecord records the backup of a volume by a schedule.
+type JobRecord struct {
+	// Schedule is the name of the schedule
+	Schedule string `json:"schedule"`
+
+	// Volume is the name of the backed up volume
+	Volume string `json:"volume"`
+
+	// Node is the hostname of the node which ran the job
+	Node string `json:"node"`
+
+	// ScheduledAt is the scheduled time of the run
+	ScheduledAt time.Time `json:"scheduledAt"`
+
+	// StartedAt is when the job started
+	StartedAt time.Time `json:"startedAt"`
+
+	// FinishedAt is when the job finished
+	FinishedAt time.Time `json:"finishedAt"`
+
+	// Archive is the created archive
+	Archive string `json:"archive,omitempty"`
+
+	// Pruned are the archives deleted by the retention
+	Pruned []string `json:"pruned,omitempty"`
+
+	// Error of the failed job
+	Error string `json:"error,omitempty"`
+}
+
+// Scheduler runs the schedules of the volumes in a storage, the nodes sharing the root path coordinate by a lock file of each schedule,
+// so only one of them runs each scheduled backup.
+type Scheduler struct {
+	logger    *log.Logger
+	storage   *storage.Builtin
+	stateDir  string
+	schedules []*Schedule
+	targets   map[string]Target
+	node      string
+}
+
+// NewScheduler creates the scheduler of the schedules of the volumes in the storage at the root path, the default target is used by the schedules without target.
+func NewScheduler(logger *log.Logger, s *storage.Builtin, rootPath string, schedules []*Schedule, defaultTarget Target) (*Scheduler, error) {
+	node, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %v", err)
+	}
+
+	scheduler := &Scheduler{
+		logger:    logger,
+		storage:   s,
+		stateDir:  path.Join(rootPath, schedulerDirName),
+		schedules: schedules,
+		targets:   map[string]Target{},
+		node:      node,
+	}
+	for i, schedule := range schedules {
+		if err := validateArchiveName(schedule.Name); err != nil || strings.HasPrefix(schedule.Name, ".") {
+			return nil, fmt.Errorf("invalid name of backup schedule %d: %q", i, schedule.Name)
+		}
+		if scheduler.targets[schedule.Name] != nil {
+			return nil, fmt.Errorf("backup schedule %s is defined more than once", schedule.Name)
+		}
+		if schedule.Cron.String() == "" {
+			return nil, fmt.Errorf("cron of backup schedule %s is required", schedule.Name)
+		}
+		if len(schedule.Volumes) == 0 && schedule.Selector == nil {
+			return nil, fmt.Errorf("backup schedule %s selects no volumes, specify volumes or selector", schedule.Name)
+		}
+		if schedule.Keep < 0 {
+			return nil, fmt.Errorf("keep of backup schedule %s can't be negative", schedule.Name)
+		}
+
+		target := defaultTarget
+		if schedule.Target != nil {
+			target, err = NewTarget(schedule.Target)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target of backup schedule %s: %v", schedule.Name, err)
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("backup schedule %s has no target, specify its target or the backup driver option", schedule.Name)
+		}
+		scheduler.targets[schedule.Name] = target
+	}
+
+	return scheduler, nil
+}
+
+// Run runs the schedules which are due at the time, the missed runs are skipped, and the first run of a new schedule is its next scheduled time.
+func (scheduler *Scheduler) Run(now time.Time) error {
+	if err := os.MkdirAll(scheduler.stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create scheduler directory: %v", err)
+	}
+
+	errs := []error{}
+	for _, schedule := range scheduler.schedules {
+		if err := scheduler.runSchedule(schedule, now.UTC()); err != nil {
+			errs = append(errs, fmt.Errorf("backup schedule %s: %v", schedule.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runSchedule runs the schedule if it is due and no other node is running it.
+func (scheduler *Scheduler) runSchedule(schedule *Schedule, now time.Time) error {
+	lock := flock.New(path.Join(scheduler.stateDir, schedule.Name+".lock"))
+	locked, err := lock.TryLock()
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	if !locked {
+		scheduler.logger.Debugf("backup schedule %s is running on another node", schedule.Name)
+		return nil
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			scheduler.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	statePath := path.Join(scheduler.stateDir, schedule.Name+".json")
+	state, err := readScheduleState(statePath)
+	if err != nil {
+		return err
+	}
+	if state.LastScheduledAt.IsZero() {
+		state.LastScheduledAt = now
+		return writeScheduleState(statePath, state)
+	}
+
+	scheduledAt := schedule.Cron.Next(state.LastScheduledAt)
+	if scheduledAt.IsZero() || scheduledAt.After(now) {
+		return nil
+	}
+	for next := schedule.Cron.Next(scheduledAt); !next.IsZero() && !next.After(now); next = schedule.Cron.Next(next) {
+		scheduledAt = next
+	}
+
+	volumes, err := scheduler.selectVolumes(schedule)
+	if err != nil {
+		return err
+	}
+	for _, volume := range volumes {
+		record := scheduler.runJob(schedule, volume, scheduledAt)
+		state.History = append(state.History, record)
+	}
+	if len(state.History) > maxJobHistory {
+		state.History = state.History[len(state.History)-maxJobHistory:]
+	}
+	state.LastScheduledAt = scheduledAt
+
+	return writeScheduleState(statePath, state)
+}
+
+// runJob backs up the volume, prunes its old archives and records the result in the volume status.
+func (scheduler *Scheduler) runJob(schedule *Schedule, volume string, scheduledAt time.Time) *JobRecord {
+	record := &JobRecord{Schedule: schedule.Name, Volume: volume, Node: scheduler.node, ScheduledAt: scheduledAt, StartedAt: time.Now()}
+	target := scheduler.targets[schedule.Name]
+
+	archive, _, err := Backup(scheduler.storage, target, volume, schedule.Quiesce)
+	if err == nil {
+		record.Archive = archive
+		if schedule.Keep > 0 {
+			record.Pruned, err = Prune(target, volume, schedule.Keep)
+		}
+	}
+	record.FinishedAt = time.Now()
+	if err != nil {
+		record.Error = err.Error()
+		scheduler.logger.Errorf("backup schedule %s failed to back up volume %s: %v", schedule.Name, volume, err)
+	} else {
+		scheduler.logger.Infof("backup schedule %s backed up volume %s to %s", schedule.Name, volume, archive)
+	}
+
+	err = scheduler.storage.UpdateVolumeMetadata(volume, func(metadata *apis.VolumeMetadata) error {
+		if metadata.Status.Backup == nil {
+			metadata.Status.Backup = &apis.BackupStatus{}
+		}
+		startedAt := record.StartedAt
+		if record.Error == "" {
+			metadata.Status.Backup.LastSuccess = &startedAt
+			metadata.Status.Backup.LastArchive = record.Archive
+		} else {
+			metadata.Status.Backup.LastFailure = &startedAt
+			metadata.Status.Backup.LastError = record.Error
+		}
+		return nil
+	})
+	if err != nil {
+		scheduler.logger.Warningf("failed to record backup status of volume %s: %v", volume, err)
+	}
+
+	return record
+}
+
+// selectVolumes returns the sorted names of the existing volumes which are listed or selected by the schedule.
+func (scheduler *Scheduler) selectVolumes(schedule *Schedule) ([]string, error) {
+	volumes := []string{}
+	err := scheduler.storage.WalkVolumeMetadata(func(name string, metadata *apis.VolumeMetadata) error {
+		if slices.Contains(schedule.Volumes, name) || schedule.Selector != nil && schedule.Selector.Matches(metadata.Spec.Labels) {
+			volumes = append(volumes, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %v", err)
+	}
+
+	sort.Strings(volumes)
+	return volumes, nil
+}
+
+// ReadScheduleStates reads the states of all schedules which have run on the volumes in the root path by schedule name.
+func ReadScheduleStates(rootPath string) (map[string]*ScheduleState, error) {
+	entries, err := os.ReadDir(path.Join(rootPath, schedulerDirName))
+	if os.IsNotExist(err) {
+		return map[string]*ScheduleState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduler directory: %v", err)
+	}
+
+	states := map[string]*ScheduleState{}
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), ".json")
+		if !found || strings.HasPrefix(name, ".") {
+			continue
+		}
+		states[name], err = readScheduleState(path.Join(rootPath, schedulerDirName, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+// readScheduleState reads the state file, a missing file is an empty state.
+func readScheduleState(statePath string) (*ScheduleState, error) {
+	state := &ScheduleState{History: []*JobRecord{}}
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule state %s: %v", statePath, err)
+	}
+	return state, nil
+}
+
+// writeScheduleState writes the state file atomically, so the other nodes never read a partial state.
+func writeScheduleState(statePath string, state *ScheduleState) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule state: %v", err)
+	}
+
+	if err := utils.WriteFileAtomic(statePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write schedule state: %v", err)
+	}
+	return nil
+}
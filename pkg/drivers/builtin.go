@@ -0,0 +1,672 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/backup"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/replication"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+// builtinDriverOptions are the options shared by the drivers which keep their volumes in a storage.Builtin root.
+type builtinDriverOptions struct {
+	// PurgeAfterDelete indicates whether to purge the volume data after deletion
+	PurgeAfterDelete bool `json:"purgeAfterDelete,omitempty"`
+
+	// TrashRetention is how long purged volumes are kept in the trash, zero means purge immediately
+	TrashRetention apis.Duration `json:"trashRetention,omitempty"`
+
+	// OrphanRetention is how long the data left by volumes deleted without purging is kept, zero means forever
+	OrphanRetention apis.Duration `json:"orphanRetention,omitempty"`
+
+	// RequireAdopt indicates whether creating a volume over the orphaned data of a deleted volume requires the adopt option,
+	// otherwise the data is reused like docker's local driver does
+	RequireAdopt bool `json:"requireAdopt,omitempty"`
+
+	// JanitorInterval is the interval of the background janitor which purges expired trash entries and orphaned data
+	JanitorInterval apis.Duration `json:"janitorInterval,omitempty"`
+
+	// FsckInterval is the interval of the background consistency check of the volumes, zero disables it
+	FsckInterval apis.Duration `json:"fsckInterval,omitempty"`
+
+	// FsckRepair indicates whether the background consistency check repairs the inconsistent volumes or only reports them
+	FsckRepair bool `json:"fsckRepair,omitempty"`
+
+	// MetadataStore selects where the volume metadata is kept, default to a file in each volume directory
+	MetadataStore storage.MetadataStoreOptions `json:"metadataStore,omitempty"`
+
+	// LabelPolicies apply default volume options to the new volumes by their labels
+	LabelPolicies []labelPolicy `json:"labelPolicies,omitempty"`
+
+	// StorageClasses are named presets of volume options, which are selected by the storageClass volume option
+	StorageClasses map[string]map[string]string `json:"storageClasses,omitempty"`
+
+	// NameMapping configures how the volume names are mapped to the volume directories
+	NameMapping storage.NameMappingOptions `json:"nameMapping,omitempty"`
+
+	// Namespace isolates the volumes under <root>/<namespace>, empty means the root itself
+	Namespace string `json:"namespace,omitempty"`
+
+	// SharedVolumes maps the names to the volumes of other namespaces in the form of <namespace>/<volume>, which are accessed read-only
+	SharedVolumes map[string]string `json:"sharedVolumes,omitempty"`
+
+	// Backup is the target of the backup archives which the volumes are restored from by the restoreFrom option
+	Backup *backup.Options `json:"backup,omitempty"`
+
+	// BackupSchedules back up the volumes periodically, the nodes sharing the root coordinate so only one of them runs each backup
+	BackupSchedules []*backup.Schedule `json:"backupSchedules,omitempty"`
+
+	// Replicas are the replication targets by name, the volumes are synced to the target named by their replicate option
+	Replicas map[string]*replication.Target `json:"replicas,omitempty"`
+}
+
+// labelPolicy applies the options to the new volumes whose labels match the selector, the explicit volume options override them.
+type labelPolicy struct {
+	// Selector of the volume labels
+	Selector apis.Selector `json:"selector"`
+
+	// Options are the default volume options
+	Options map[string]string `json:"options"`
+}
+
+func defaultBuiltinDriverOptions() builtinDriverOptions {
+	return builtinDriverOptions{
+		PurgeAfterDelete: false,
+		TrashRetention:   0,
+		OrphanRetention:  0,
+		JanitorInterval:  apis.Duration(time.Hour),
+		FsckInterval:     0,
+		FsckRepair:       false,
+	}
+}
+
+// readOnlyDirName is the directory in the volume directory which the data directory is bound to read-only while the volume is mounted read-only.
+const readOnlyDirName = "_readonly"
+
+// sharedVolume is a volume of another namespace which is accessed read-only.
+type sharedVolume struct {
+	namespace string
+	name      string
+}
+
+// builtin implements the volume operations of the drivers which keep their volumes in a storage.Builtin root,
+// the drivers embed it and only take care of providing the root.
+type builtin struct {
+	logger    *log.Logger
+	opts      *builtinDriverOptions
+	rootPath  string
+	mock      bool
+	storage   *storage.Builtin
+	ctx       context.Context
+	cancel    context.CancelFunc
+	waitGroup sync.WaitGroup
+
+	// sharedVolumes are the volumes of other namespaces by their names, and sharedStorages are the storages of those namespaces
+	sharedVolumes  map[string]*sharedVolume
+	sharedStorages map[string]*storage.Builtin
+
+	// backupTarget keeps the backup archives, nil if no target is configured
+	backupTarget backup.Target
+
+	// scheduler runs the backup schedules, nil if no schedule is configured
+	scheduler *backup.Scheduler
+
+	// replicator syncs the volumes to their replicas, nil if no replication target is configured
+	replicator *replication.Replicator
+
+	// readOnlyMounts are the ids of the mounts of the volumes which are mounted read-only
+	readOnlyMounts map[string]map[string]struct{}
+	mountsMutex    sync.Mutex
+
+	// specExtension parses the driver specific volume options into the spec, nil if the driver has none
+	specExtension specExtension
+}
+
+// specExtension parses the driver specific options into the spec of a new or updated volume and returns the other options.
+type specExtension func(spec *apis.VolumeSpec, options map[string]string) (map[string]string, error)
+
+// newBuiltin creates the builtin driver of the volumes in the root path, in mock mode the read-only volumes are not bound read-only.
+func newBuiltin(ctx context.Context, logger *log.Logger, rootPath string, opts *builtinDriverOptions, mock bool) (*builtin, error) {
+	for i, policy := range opts.LabelPolicies {
+		if err := (&apis.VolumeSpec{}).Unmarshal(policy.Options); err != nil {
+			return nil, fmt.Errorf("invalid options of label policy %d: %v", i, err)
+		}
+	}
+	for name, options := range opts.StorageClasses {
+		if _, existed := options["storageClass"]; existed {
+			return nil, fmt.Errorf("storage class %s can't set storageClass", name)
+		}
+		if err := (&apis.VolumeSpec{}).Unmarshal(options); err != nil {
+			return nil, fmt.Errorf("invalid options of storage class %s: %v", name, err)
+		}
+	}
+
+	sharedVolumes, err := parseSharedVolumes(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var backupTarget backup.Target
+	if opts.Backup != nil {
+		backupTarget, err = backup.NewTarget(opts.Backup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup target: %v", err)
+		}
+	}
+
+	volumeStorage, err := openStorage(logger, rootPath, opts.Namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	sharedStorages := map[string]*storage.Builtin{}
+	closeStorages := func() {
+		for _, s := range sharedStorages {
+			if s != nil {
+				_ = s.Close()
+			}
+		}
+		_ = volumeStorage.Close()
+	}
+	for _, shared := range sharedVolumes {
+		if sharedStorages[shared.namespace] != nil {
+			continue
+		}
+		sharedStorages[shared.namespace], err = openStorage(logger, rootPath, shared.namespace, opts)
+		if err != nil {
+			closeStorages()
+			return nil, err
+		}
+	}
+
+	var scheduler *backup.Scheduler
+	if len(opts.BackupSchedules) > 0 {
+		scheduler, err = backup.NewScheduler(logger.WithService("scheduler"), volumeStorage, path.Join(rootPath, opts.Namespace), opts.BackupSchedules, backupTarget)
+		if err != nil {
+			closeStorages()
+			return nil, err
+		}
+	}
+
+	var replicator *replication.Replicator
+	if len(opts.Replicas) > 0 {
+		// Each namespace is replicated into the same namespace of the targets
+		targets := map[string]*replication.Target{}
+		for name, target := range opts.Replicas {
+			namespaced := *target
+			namespaced.Path = path.Join(target.Path, opts.Namespace)
+			targets[name] = &namespaced
+		}
+		replicator, err = replication.NewReplicator(logger.WithService("replication"), volumeStorage, path.Join(rootPath, opts.Namespace), targets, &opts.NameMapping)
+		if err != nil {
+			closeStorages()
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	driver := &builtin{
+		logger:         logger,
+		opts:           opts,
+		rootPath:       rootPath,
+		mock:           mock,
+		storage:        volumeStorage,
+		ctx:            ctx,
+		cancel:         cancel,
+		sharedVolumes:  sharedVolumes,
+		sharedStorages: sharedStorages,
+		backupTarget:   backupTarget,
+		scheduler:      scheduler,
+		replicator:     replicator,
+		readOnlyMounts: map[string]map[string]struct{}{},
+	}
+
+	driver.startTask("janitor", time.Duration(opts.JanitorInterval), driver.janitor)
+	driver.startTask("fsck", time.Duration(opts.FsckInterval), driver.fsck)
+	if scheduler != nil {
+		driver.startTask("scheduler", time.Minute, func() error {
+			return scheduler.Run(time.Now())
+		})
+	}
+	if replicator != nil {
+		for name, target := range replicator.Targets() {
+			driver.startTask("replication "+name, time.Duration(target.Interval), func() error {
+				return replicator.Run(name)
+			})
+		}
+	}
+
+	return driver, nil
+}
+
+// openStorage opens the storage of the volumes of the namespace under the root path.
+func openStorage(logger *log.Logger, rootPath string, namespace string, opts *builtinDriverOptions) (*storage.Builtin, error) {
+	storageLogger := logger.WithService("storage").WithLogLevel(log.WarnLevel)
+	namespacePath := path.Join(rootPath, namespace)
+	if err := os.MkdirAll(namespacePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of namespace %s: %v", namespace, err)
+	}
+	if namespace != "" {
+		if err := storage.MarkNamespace(namespacePath); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := storage.NewMetadataStore(storageLogger, namespacePath, &opts.MetadataStore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata store: %v", err)
+	}
+
+	volumeStorage := storage.NewBuiltinWithMetadataStore(storageLogger, namespacePath, store)
+	if err := volumeStorage.SetNameMapping(&opts.NameMapping); err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("invalid name mapping: %v", err)
+	}
+	return volumeStorage, nil
+}
+
+// checkUnmountedData rejects the options which copy or share the data directories, for the drivers whose data is only there while the volumes are mounted.
+func checkUnmountedData(opts *builtinDriverOptions) error {
+	if opts.Backup != nil || len(opts.BackupSchedules) != 0 || len(opts.Replicas) != 0 {
+		return fmt.Errorf("doesn't support backups or replicas, the data is only there while the volumes are mounted")
+	}
+	if len(opts.SharedVolumes) != 0 {
+		return fmt.Errorf("doesn't support shared volumes, the data can't be mounted by other plugins")
+	}
+	return nil
+}
+
+// parseSharedVolumes validates the namespace and parses the shared volumes of other namespaces.
+func parseSharedVolumes(opts *builtinDriverOptions) (map[string]*sharedVolume, error) {
+	if opts.Namespace != "" {
+		if err := storage.ValidateVolumeName(opts.Namespace); err != nil {
+			return nil, fmt.Errorf("invalid namespace: %v", err)
+		}
+	}
+
+	sharedVolumes := map[string]*sharedVolume{}
+	for name, target := range opts.SharedVolumes {
+		namespace, volumeName, found := strings.Cut(target, "/")
+		if !found || namespace == "" || volumeName == "" {
+			return nil, fmt.Errorf("shared volume %s should be in <namespace>/<volume> format", name)
+		}
+		if namespace == opts.Namespace {
+			return nil, fmt.Errorf("shared volume %s is in the namespace of the driver", name)
+		}
+		if err := storage.ValidateVolumeName(namespace); err != nil {
+			return nil, fmt.Errorf("invalid namespace of shared volume %s: %v", name, err)
+		}
+		sharedVolumes[name] = &sharedVolume{namespace: namespace, name: volumeName}
+	}
+
+	if len(sharedVolumes) != 0 || opts.Namespace != "" {
+		if opts.MetadataStore.Path != "" {
+			return nil, fmt.Errorf("the path of the metadata store can't be specified with namespaces, each namespace keeps its own database")
+		}
+	}
+	return sharedVolumes, nil
+}
+
+func (driver *builtin) Create(name string, options map[string]string) error {
+	if shared := driver.sharedVolumes[name]; shared != nil {
+		return fmt.Errorf("volume %s is shared read-only from namespace %s", name, shared.namespace)
+	}
+
+	createOptions := &apis.CreateOptions{}
+	specOptions, err := createOptions.Unmarshal(options)
+	if err != nil {
+		return err
+	}
+
+	if createOptions.Update {
+		if createOptions.RestoreTrash != "" || createOptions.RestoreFrom != "" || createOptions.Adopt {
+			return fmt.Errorf("update can't be combined with restoreTrash, restoreFrom or adopt")
+		}
+		if err := driver.checkReplicate(specOptions["replicate"]); err != nil {
+			return err
+		}
+		driver.logger.Infof("updating volume %s with options %v", name, specOptions)
+		if driver.specExtension == nil {
+			return driver.storage.UpdateVolumeSpec(name, specOptions)
+		}
+		return driver.storage.UpdateVolumeMetadata(name, func(metadata *apis.VolumeMetadata) error {
+			options, err := driver.specExtension(metadata.Spec, specOptions)
+			if err != nil {
+				return err
+			}
+			return metadata.Spec.Update(options)
+		})
+	}
+
+	if createOptions.RestoreFrom != "" {
+		if createOptions.RestoreTrash != "" || len(specOptions) != 0 {
+			return fmt.Errorf("volume options can't be specified when restoring from backup")
+		}
+		if driver.backupTarget == nil {
+			return fmt.Errorf("no backup target is configured to restore volume %s from", name)
+		}
+		if _, err := driver.storage.FetchVolumeMetadata(name); err == nil {
+			driver.logger.Warningf("volume %s already exists, skipping restore from backup archive %s", name, createOptions.RestoreFrom)
+			return nil
+		}
+		driver.logger.Infof("restoring volume %s from backup archive %s", name, createOptions.RestoreFrom)
+		_, err := backup.Restore(driver.storage, driver.backupTarget, createOptions.RestoreFrom, name)
+		return err
+	}
+
+	if createOptions.RestoreTrash != "" {
+		if len(specOptions) != 0 {
+			return fmt.Errorf("volume options can't be specified when restoring from trash")
+		}
+		driver.logger.Infof("restoring volume %s from trash entry %s", name, createOptions.RestoreTrash)
+		return driver.storage.RestoreVolume(createOptions.RestoreTrash, name)
+	}
+
+	spec := &apis.VolumeSpec{
+		PurgeAfterDelete: driver.opts.PurgeAfterDelete,
+	}
+	resolvedOptions, err := driver.ResolveSpecOptions(specOptions)
+	if err != nil {
+		return err
+	}
+	if driver.specExtension != nil {
+		resolvedOptions, err = driver.specExtension(spec, resolvedOptions)
+		if err != nil {
+			return err
+		}
+	}
+	if err := spec.Unmarshal(resolvedOptions); err != nil {
+		return err
+	}
+	if err := driver.checkReplicate(spec.Replicate); err != nil {
+		return err
+	}
+
+	return driver.storage.CreateVolume(name, spec, createOptions.Adopt || !driver.opts.RequireAdopt)
+}
+
+// checkReplicate checks the replication target of the replicate option is defined, an empty target disables the replication.
+func (driver *builtin) checkReplicate(target string) error {
+	if target != "" && driver.opts.Replicas[target] == nil {
+		return fmt.Errorf("replication target %s is not defined", target)
+	}
+	return nil
+}
+
+// List lists the volumes of the namespace and the shared volumes of other namespaces, the shared volumes which can't be read are skipped.
+func (driver *builtin) List() (map[string]*apis.VolumeMetadata, error) {
+	volumeMetadataMap, err := driver.storage.ListVolumeMetadata()
+	if err != nil {
+		return nil, err
+	}
+	for _, metadata := range volumeMetadataMap {
+		metadata.Status.Mountpoint = path.Join(driver.opts.Namespace, metadata.Status.Mountpoint)
+	}
+
+	for name := range driver.sharedVolumes {
+		metadata, err := driver.fetch(name)
+		if err != nil {
+			driver.logger.Warningf("failed to get shared volume %s: %v", name, err)
+			continue
+		}
+		volumeMetadataMap[name] = metadata
+	}
+	return volumeMetadataMap, nil
+}
+
+func (driver *builtin) Get(name string) (*apis.VolumeMetadata, error) {
+	return driver.fetch(name)
+}
+
+func (driver *builtin) Remove(name string) error {
+	if shared := driver.sharedVolumes[name]; shared != nil {
+		return fmt.Errorf("volume %s is shared read-only from namespace %s", name, shared.namespace)
+	}
+	return driver.storage.RemoveVolume(name, time.Duration(driver.opts.TrashRetention))
+}
+
+func (driver *builtin) Path(name string) (string, error) {
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return "", err
+	}
+
+	driver.mountsMutex.Lock()
+	defer driver.mountsMutex.Unlock()
+	if len(driver.readOnlyMounts[name]) != 0 && !driver.mock {
+		return getReadOnlyPath(metadata), nil
+	}
+	return metadata.Status.Mountpoint, err
+}
+
+// Mount returns the data directory of the volume, or binds it read-only on the first mount if the volume is read-only.
+func (driver *builtin) Mount(name string, id string) (string, error) {
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return "", err
+	}
+
+	driver.mountsMutex.Lock()
+	defer driver.mountsMutex.Unlock()
+	mounts := driver.readOnlyMounts[name]
+	if !metadata.Spec.ReadOnly && len(mounts) == 0 {
+		return metadata.Status.Mountpoint, nil
+	}
+
+	readOnlyPath := getReadOnlyPath(metadata)
+	if len(mounts) == 0 && !driver.mock {
+		err = os.MkdirAll(path.Join(driver.rootPath, readOnlyPath), 0755)
+		if err != nil {
+			return "", fmt.Errorf("failed to create read-only mount point: %v", err)
+		}
+		err = utils.Bind(path.Join(driver.rootPath, metadata.Status.Mountpoint), path.Join(driver.rootPath, readOnlyPath), []string{"ro"})
+		if err != nil {
+			return "", fmt.Errorf("failed to bind volume %s read-only: %v", name, err)
+		}
+	}
+	if mounts == nil {
+		mounts = map[string]struct{}{}
+		driver.readOnlyMounts[name] = mounts
+	}
+	mounts[id] = struct{}{}
+
+	if driver.mock {
+		return metadata.Status.Mountpoint, nil
+	}
+	return readOnlyPath, nil
+}
+
+// Unmount unbinds the read-only data directory of the volume on the last unmount.
+func (driver *builtin) Unmount(name string, id string) error {
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return err
+	}
+
+	driver.mountsMutex.Lock()
+	defer driver.mountsMutex.Unlock()
+	mounts := driver.readOnlyMounts[name]
+	if _, existed := mounts[id]; !existed {
+		return nil
+	}
+	delete(mounts, id)
+	if len(mounts) != 0 {
+		return nil
+	}
+	delete(driver.readOnlyMounts, name)
+
+	if !driver.mock {
+		readOnlyPath := path.Join(driver.rootPath, getReadOnlyPath(metadata))
+		err = utils.Umount(readOnlyPath)
+		if err != nil {
+			return fmt.Errorf("failed to unbind read-only volume %s: %v", name, err)
+		}
+		err = os.Remove(readOnlyPath)
+		if err != nil {
+			driver.logger.Warningf("failed to remove read-only mount point of volume %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (driver *builtin) Destroy() error {
+	driver.cancel()
+	driver.waitGroup.Wait()
+
+	if driver.replicator != nil {
+		if err := driver.replicator.Close(); err != nil {
+			driver.logger.Errorf("failed to close replicator: %v", err)
+		}
+	}
+	for namespace, s := range driver.sharedStorages {
+		if err := s.Close(); err != nil {
+			driver.logger.Errorf("failed to close storage of namespace %s: %v", namespace, err)
+		}
+	}
+
+	err := driver.storage.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close storage: %s", err)
+	}
+
+	return nil
+}
+
+// fetch returns the metadata of the volume with the mountpoint relative to the root path, the shared volumes of other namespaces are read-only.
+func (driver *builtin) fetch(name string) (*apis.VolumeMetadata, error) {
+	shared := driver.sharedVolumes[name]
+	if shared == nil {
+		metadata, err := driver.storage.FetchVolumeMetadata(name)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Status.Mountpoint = path.Join(driver.opts.Namespace, metadata.Status.Mountpoint)
+		return metadata, nil
+	}
+
+	metadata, err := driver.sharedStorages[shared.namespace].FetchVolumeMetadata(shared.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume %s of namespace %s: %w", shared.name, shared.namespace, err)
+	}
+	metadata.Spec.ReadOnly = true
+	metadata.Status.Mountpoint = path.Join(shared.namespace, metadata.Status.Mountpoint)
+	return metadata, nil
+}
+
+// getReadOnlyPath returns the path of the read-only mount point relative to the root path, it is next to the data directory of the volume.
+func getReadOnlyPath(metadata *apis.VolumeMetadata) string {
+	return path.Join(path.Dir(metadata.Status.Mountpoint), readOnlyDirName)
+}
+
+// ResolveSpecOptions returns the options with the defaults of the label policies matching the labels in the options and of the storage class,
+// the later policies override the earlier ones, the storage class overrides the policies and the explicit options override all of them.
+func (driver *builtin) ResolveSpecOptions(options map[string]string) (map[string]string, error) {
+	labels := apis.LabelsFromOptions(options)
+	resolved := map[string]string{}
+	for _, policy := range driver.opts.LabelPolicies {
+		if !policy.Selector.Matches(labels) {
+			continue
+		}
+		for key, value := range policy.Options {
+			resolved[key] = value
+		}
+	}
+
+	if className := options["storageClass"]; className != "" {
+		class, existed := driver.opts.StorageClasses[className]
+		if !existed {
+			return nil, fmt.Errorf("storage class %s is not defined", className)
+		}
+		for key, value := range class {
+			resolved[key] = value
+		}
+	}
+
+	for key, value := range options {
+		resolved[key] = value
+	}
+
+	return resolved, nil
+}
+
+// startTask runs the task every interval in background until the driver is destroyed, a zero interval disables the task.
+func (driver *builtin) startTask(name string, interval time.Duration, task func() error) {
+	if interval <= 0 {
+		driver.logger.Debugf("background task %s is disabled", name)
+		return
+	}
+
+	driver.waitGroup.Add(1)
+	go func() {
+		defer driver.waitGroup.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-driver.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := task(); err != nil {
+					driver.logger.Errorf("background task %s failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// janitor purges the trash entries whose retention has elapsed and the expired orphaned data.
+func (driver *builtin) janitor() error {
+	purged, err := driver.storage.PurgeTrash()
+	for _, entry := range purged {
+		driver.logger.Infof("purged trash entry %s", entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	if driver.opts.OrphanRetention == 0 {
+		return nil
+	}
+	purged, err = driver.storage.PurgeOrphans(time.Duration(driver.opts.OrphanRetention))
+	for _, name := range purged {
+		driver.logger.Infof("purged orphaned data of volume %s", name)
+	}
+	return err
+}
+
+// fsck reports the inconsistent volumes and repairs them if enabled.
+func (driver *builtin) fsck() error {
+	results, err := driver.storage.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.State == storage.CheckStateValid || result.State == storage.CheckStateOrphan {
+			continue
+		}
+
+		driver.logger.Warningf("volume %s is inconsistent (%s): %s", result.Name, result.State, result.Detail)
+		if !driver.opts.FsckRepair {
+			continue
+		}
+
+		action, err := driver.storage.Repair(result, false)
+		if err != nil {
+			driver.logger.Errorf("failed to %s for volume %s: %v", action, result.Name, err)
+		} else if action != "" {
+			driver.logger.Infof("repaired volume %s: %s", result.Name, action)
+		}
+	}
+
+	return nil
+}
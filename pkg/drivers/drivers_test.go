@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"testing"
+	"time"
 
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/backup"
 	"github.com/zouy414/docker-volume-plugin/pkg/log"
 
+	"github.com/gofrs/flock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,6 +46,26 @@ func TestDrivers(t *testing.T) {
 			driver:        "cifs",
 			driverOptions: `{"address": "cifs-server.example.com", "remotePath": "/share", "username": "user", "password": "pass", "mock": true}`,
 		},
+		{
+			driver:        "local",
+			driverOptions: `{"path": "/srv/volumes", "mock": true}`,
+		},
+		{
+			driver:        "loopfs",
+			driverOptions: `{"backend": "nfs", "address": "nfs-server.example.com", "remotePath": "/mock", "defaultSize": "16Mi", "mock": true}`,
+		},
+		{
+			driver:        "sshfs",
+			driverOptions: `{"address": "ssh-server.example.com", "username": "user", "remotePath": "/srv/volumes", "mock": true}`,
+		},
+		{
+			driver:        "s3",
+			driverOptions: `{"bucket": "volumes", "prefix": "cluster-a", "endpoint": "http://127.0.0.1:9000", "pathStyle": true, "mock": true}`,
+		},
+		{
+			driver:        "tmpfs",
+			driverOptions: `{"defaultSize": "16Mi", "mock": true}`,
+		},
 	}
 	defer func() {
 		for _, c := range cases {
@@ -66,6 +91,21 @@ func TestDrivers(t *testing.T) {
 			assert.NotNil(t, volumeMetadata)
 			assert.Equal(t, true, volumeMetadata.Spec.PurgeAfterDelete)
 
+			// Test Update
+			err = driver.Create("test", map[string]string{"update": "true", "quota": "1Gi", "readOnly": "true"})
+			assert.NoError(t, err)
+			volumeMetadata, err = driver.Get("test")
+			assert.NoError(t, err)
+			assert.Equal(t, apis.Size(1<<30), volumeMetadata.Spec.Quota)
+			assert.True(t, volumeMetadata.Spec.ReadOnly)
+			assert.True(t, volumeMetadata.Spec.PurgeAfterDelete)
+
+			// Test Update with invalid options
+			err = driver.Create("test", map[string]string{"update": "true", "quota": "invalid"})
+			assert.Error(t, err)
+			err = driver.Create("non-exist", map[string]string{"update": "true", "quota": "1Gi"})
+			assert.Error(t, err)
+
 			// Test List
 			volumeMetadataMap, err := driver.List()
 			assert.NoError(t, err)
@@ -119,3 +159,603 @@ func TestDrivers(t *testing.T) {
 		})
 	}
 }
+
+func TestTrash(t *testing.T) {
+	driver, err := New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), `{"purgeAfterDelete": true, "trashRetention": "7d", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+	storage := driver.(*nfs).storage
+
+	// Test Remove moves the volume into trash
+	err = driver.Create("test", map[string]string{})
+	assert.NoError(t, err)
+	err = driver.Remove("test")
+	assert.NoError(t, err)
+	_, err = driver.Get("test")
+	assert.Error(t, err)
+	trashEntries, err := storage.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, trashEntries, 1)
+	assert.Equal(t, "test", trashEntries[0].Volume)
+
+	// Test Create restores the volume from trash
+	err = driver.Create("restored", map[string]string{"restoreTrash": trashEntries[0].Name, "purgeAfterDelete": "false"})
+	assert.Error(t, err)
+	err = driver.Create("restored", map[string]string{"restoreTrash": trashEntries[0].Name})
+	assert.NoError(t, err)
+	volumeMetadata, err := driver.Get("restored")
+	assert.NoError(t, err)
+	assert.True(t, volumeMetadata.Spec.PurgeAfterDelete)
+
+	// Test volume option disables trash
+	err = driver.Create("purged", map[string]string{"trashRetention": "0s"})
+	assert.NoError(t, err)
+	err = driver.Remove("purged")
+	assert.NoError(t, err)
+	trashEntries, err = storage.ListTrash()
+	assert.NoError(t, err)
+	assert.Empty(t, trashEntries)
+}
+
+func TestOrphans(t *testing.T) {
+	// Test Create reuses orphaned data by default
+	reusing, err := New(context.Background(), log.New("cifs"), "cifs", t.TempDir(), `{"mock": true}`)
+	assert.NoError(t, err)
+	assert.NoError(t, reusing.Create("test", map[string]string{}))
+	assert.NoError(t, reusing.Remove("test"))
+	assert.NoError(t, reusing.Create("test", map[string]string{}))
+	orphans, err := reusing.(*cifs).storage.ListOrphans()
+	assert.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.NoError(t, reusing.Destroy())
+
+	driver, err := New(context.Background(), log.New("cifs"), "cifs", t.TempDir(), `{"orphanRetention": "1h", "requireAdopt": true, "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+	storage := driver.(*cifs).storage
+
+	// Test Remove without purge leaves orphaned data
+	err = driver.Create("test", map[string]string{})
+	assert.NoError(t, err)
+	err = driver.Remove("test")
+	assert.NoError(t, err)
+	orphans, err = storage.ListOrphans()
+	assert.NoError(t, err)
+	assert.Len(t, orphans, 1)
+	assert.Equal(t, "test", orphans[0].Name)
+
+	// Test List hides orphaned data
+	volumeMetadataMap, err := driver.List()
+	assert.NoError(t, err)
+	assert.Empty(t, volumeMetadataMap)
+
+	// Test Create refuses orphaned data without adopt
+	err = driver.Create("test", map[string]string{})
+	assert.Error(t, err)
+	err = driver.Create("test", map[string]string{"adopt": "yes"})
+	assert.Error(t, err)
+
+	// Test Create adopts orphaned data
+	err = driver.Create("test", map[string]string{"adopt": "true"})
+	assert.NoError(t, err)
+	orphans, err = storage.ListOrphans()
+	assert.NoError(t, err)
+	assert.Empty(t, orphans)
+
+	// Test PurgeOrphans respects the retention
+	err = driver.Remove("test")
+	assert.NoError(t, err)
+	purged, err := storage.PurgeOrphans(time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, purged)
+	purged, err = storage.PurgeOrphans(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test"}, purged)
+}
+
+func TestLabelPolicies(t *testing.T) {
+	_, err := New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), `{"labelPolicies": [{"selector": "tier=scratch", "options": {"unknown": "true"}}], "mock": true}`)
+	assert.Error(t, err)
+
+	driver, err := New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), `{"labelPolicies": [{"selector": "tier=scratch", "options": {"purgeAfterDelete": "true", "quota": "5Gi"}}, {"selector": "tier=scratch,owner=team-a", "options": {"quota": "10Gi"}}], "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+
+	cases := []struct {
+		name                   string
+		options                map[string]string
+		expectPurgeAfterDelete bool
+		expectQuota            apis.Size
+	}{
+		{
+			name:    "unlabeled",
+			options: map[string]string{},
+		},
+		{
+			name:                   "scratch",
+			options:                map[string]string{"label.tier": "scratch"},
+			expectPurgeAfterDelete: true,
+			expectQuota:            5 << 30,
+		},
+		{
+			name:                   "later policy overrides",
+			options:                map[string]string{"label.tier": "scratch", "label.owner": "team-a"},
+			expectPurgeAfterDelete: true,
+			expectQuota:            10 << 30,
+		},
+		{
+			name:                   "explicit option overrides",
+			options:                map[string]string{"label.tier": "scratch", "quota": "1Gi"},
+			expectPurgeAfterDelete: true,
+			expectQuota:            1 << 30,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.NoError(t, driver.Create(c.name, c.options))
+			metadata, err := driver.Get(c.name)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectPurgeAfterDelete, metadata.Spec.PurgeAfterDelete)
+			assert.Equal(t, c.expectQuota, metadata.Spec.Quota)
+			assert.Equal(t, apis.FormatLabels(apis.LabelsFromOptions(c.options)), apis.FormatLabels(metadata.Spec.Labels))
+		})
+	}
+}
+
+func TestStorageClasses(t *testing.T) {
+	_, err := New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), `{"storageClasses": {"scratch": {"quota": "invalid"}}, "mock": true}`)
+	assert.Error(t, err)
+
+	driverOptions := `{
+		"storageClasses": {"scratch": {"purgeAfterDelete": "true", "quota": "5Gi", "trashRetention": "7d"}},
+		"labelPolicies": [{"selector": "owner=team-a", "options": {"quota": "20Gi", "readOnly": "true"}}],
+		"mock": true
+	}`
+	driver, err := New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), driverOptions)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+
+	// Test the storage class overrides the label policies and the explicit options override the storage class
+	assert.NoError(t, driver.Create("test", map[string]string{"storageClass": "scratch", "label.owner": "team-a", "trashRetention": "1d"}))
+	metadata, err := driver.Get("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "scratch", metadata.Spec.StorageClass)
+	assert.True(t, metadata.Spec.PurgeAfterDelete)
+	assert.True(t, metadata.Spec.ReadOnly)
+	assert.Equal(t, apis.Size(5<<30), metadata.Spec.Quota)
+	assert.Equal(t, apis.Duration(24*time.Hour), *metadata.Spec.TrashRetention)
+
+	// Test undefined storage class
+	assert.EqualError(t, driver.Create("undefined", map[string]string{"storageClass": "undefined"}), "storage class undefined is not defined")
+
+	// Test the storage class can't be changed
+	assert.Error(t, driver.Create("test", map[string]string{"update": "true", "storageClass": "database"}))
+}
+
+func TestNamespaces(t *testing.T) {
+	rootPath := t.TempDir()
+
+	_, err := New(context.Background(), log.New("nfs"), "nfs", rootPath, `{"namespace": "..", "mock": true}`)
+	assert.Error(t, err)
+	_, err = New(context.Background(), log.New("nfs"), "nfs", rootPath, `{"namespace": "b", "sharedVolumes": {"shared": "a"}, "mock": true}`)
+	assert.Error(t, err)
+
+	driverA, err := New(context.Background(), log.New("nfs"), "nfs", rootPath, `{"namespace": "a", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driverA.Destroy())
+	}()
+	driverB, err := New(context.Background(), log.New("nfs"), "nfs", rootPath, `{"namespace": "b", "sharedVolumes": {"shared": "a/data"}, "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driverB.Destroy())
+	}()
+
+	// Test the volumes are isolated in their namespaces
+	assert.NoError(t, driverA.Create("data", map[string]string{}))
+	assert.DirExists(t, rootPath+"/a/data/_data")
+	_, err = driverB.Get("data")
+	assert.Error(t, err)
+	mountpoint, err := driverA.Mount("data", "id")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/data/_data", mountpoint)
+
+	// Test the shared volume is listed and read-only in the other namespace
+	volumeMetadataMap, err := driverB.List()
+	assert.NoError(t, err)
+	assert.Len(t, volumeMetadataMap, 1)
+	assert.True(t, volumeMetadataMap["shared"].Spec.ReadOnly)
+	mountpoint, err = driverB.Mount("shared", "id")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/data/_data", mountpoint)
+	assert.NoError(t, driverB.Unmount("shared", "id"))
+	assert.Error(t, driverB.Create("shared", map[string]string{}))
+	assert.Error(t, driverB.Remove("shared"))
+
+	// Test the shared volume is still writable in its namespace
+	metadata, err := driverA.Get("data")
+	assert.NoError(t, err)
+	assert.False(t, metadata.Spec.ReadOnly)
+
+	// Test a non-namespaced driver on the same root skips the namespaces
+	driver, err := New(context.Background(), log.New("nfs"), "nfs", rootPath, `{"mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+	assert.Error(t, driver.Create("a", map[string]string{}))
+	results, err := driver.(*nfs).storage.Check()
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Test the volumes of a non-namespaced driver can't become namespaces
+	assert.NoError(t, driver.Create("c", map[string]string{}))
+	_, err = New(context.Background(), log.New("nfs"), "nfs", rootPath, `{"namespace": "c", "mock": true}`)
+	assert.Error(t, err)
+	assert.NoFileExists(t, path.Join(rootPath, "c", ".namespace"))
+	_, err = driver.Get("c")
+	assert.NoError(t, err)
+}
+
+func TestRestoreFromBackup(t *testing.T) {
+	backupPath := t.TempDir()
+	driver, err := New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), fmt.Sprintf(`{"backup": {"directory": %q}, "mock": true}`, backupPath))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+
+	// Back up a volume by the storage of the driver, as the backup command does
+	assert.NoError(t, driver.Create("test", map[string]string{"quota": "1Gi"}))
+	archive, _, err := backup.Backup(driver.(*nfs).storage, driver.(*nfs).backupTarget, "test", false)
+	assert.NoError(t, err)
+
+	// Test restoreFrom
+	assert.Error(t, driver.Create("restored", map[string]string{"restoreFrom": archive, "quota": "2Gi"}))
+	assert.Error(t, driver.Create("restored", map[string]string{"restoreFrom": "non-exist.tar.zst"}))
+	assert.NoError(t, driver.Create("restored", map[string]string{"restoreFrom": archive}))
+	metadata, err := driver.Get("restored")
+	assert.NoError(t, err)
+	assert.Equal(t, apis.Size(1<<30), metadata.Spec.Quota)
+
+	// Test restoreFrom without backup target
+	driver, err = New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), `{"mock": true}`)
+	assert.NoError(t, err)
+	assert.Error(t, driver.Create("restored", map[string]string{"restoreFrom": archive}))
+	assert.NoError(t, driver.Destroy())
+
+	// Test backupSchedules
+	_, err = New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), `{"backupSchedules": [{"name": "daily", "cron": "@daily", "volumes": ["test"]}], "mock": true}`)
+	assert.Error(t, err)
+	_, err = New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), fmt.Sprintf(`{"backup": {"directory": %q}, "backupSchedules": [{"name": "daily", "cron": "0 0 30 * * *", "volumes": ["test"]}], "mock": true}`, backupPath))
+	assert.Error(t, err)
+	driver, err = New(context.Background(), log.New("nfs"), "nfs", t.TempDir(), fmt.Sprintf(`{"backup": {"directory": %q}, "backupSchedules": [{"name": "daily", "cron": "@daily", "selector": "backup=daily", "keep": 30}], "mock": true}`, backupPath))
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Destroy())
+}
+
+func TestMigrateVolume(t *testing.T) {
+	sourcePath, targetPath := t.TempDir(), t.TempDir()
+	source, err := New(context.Background(), log.New("nfs"), "nfs", sourcePath, `{"mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, source.Destroy())
+	}()
+	target, err := New(context.Background(), log.New("cifs"), "cifs", targetPath, `{"mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, target.Destroy())
+	}()
+
+	assert.NoError(t, source.Create("test", map[string]string{"quota": "1Gi", "label.owner": "team-a"}))
+	mountpoint, err := source.Path("test")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, mountpoint, "file"), []byte("data"), 0644))
+	assert.NoError(t, os.Symlink("file", path.Join(sourcePath, mountpoint, "link")))
+
+	// Test the invalid migrations
+	_, err = MigrateVolume(source, target, "test", 0)
+	assert.Error(t, err)
+	_, err = MigrateVolume(source, target, "non-exist", time.Hour)
+	assert.Error(t, err)
+	mock, err := New(context.Background(), log.New("mock"), "mock", t.TempDir(), "")
+	assert.NoError(t, err)
+	_, err = MigrateVolume(mock, target, "test", time.Hour)
+	assert.Error(t, err)
+
+	// Test migration
+	entry, err := MigrateVolume(source, target, "test", time.Hour)
+	assert.NoError(t, err)
+	_, err = source.Get("test")
+	assert.Error(t, err)
+	metadata, err := target.Get("test")
+	assert.NoError(t, err)
+	assert.Equal(t, apis.Size(1<<30), metadata.Spec.Quota)
+	assert.Equal(t, "team-a", metadata.Spec.Labels["owner"])
+	data, err := os.ReadFile(path.Join(targetPath, metadata.Status.Mountpoint, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	entries, err := source.(*nfs).storage.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, entry, entries[0].Name)
+
+	// Test migration to a target which has the volume
+	assert.NoError(t, source.Create("test", nil))
+	_, err = MigrateVolume(source, target, "test", time.Hour)
+	assert.Error(t, err)
+	_, err = source.Get("test")
+	assert.NoError(t, err)
+
+	// Test the target drivers set up the migrated volumes
+	imagesPath := t.TempDir()
+	images, err := New(context.Background(), log.New("loopfs"), "loopfs", imagesPath, `{"defaultSize": "16Mi", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, images.Destroy())
+	}()
+	quotasPath := t.TempDir()
+	quotas, err := New(context.Background(), log.New("local"), "local", quotasPath, `{"quotaMode": "loop", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, quotas.Destroy())
+	}()
+	for name, targetPath := range map[string]string{"images": imagesPath, "quotas": quotasPath} {
+		target := images
+		if name == "quotas" {
+			target = quotas
+		}
+		assert.NoError(t, source.Create(name, map[string]string{"quota": "32Mi"}))
+		_, err = MigrateVolume(source, target, name, time.Hour)
+		assert.NoError(t, err)
+		info, err := os.Stat(path.Join(targetPath, name, loopImageName))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(32<<20), info.Size())
+	}
+
+	// Test the drivers which don't keep the data in the data directories
+	_, err = MigrateVolume(images, target, "images", time.Hour)
+	assert.Error(t, err)
+	memory, err := New(context.Background(), log.New("tmpfs"), "tmpfs", t.TempDir(), `{"mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, memory.Destroy())
+	}()
+	_, err = MigrateVolume(source, memory, "test", time.Hour)
+	assert.Error(t, err)
+	_, err = source.Get("test")
+	assert.NoError(t, err)
+}
+
+func TestReplicate(t *testing.T) {
+	rootPath, replicaPath := t.TempDir(), t.TempDir()
+	driver, err := New(context.Background(), log.New("nfs"), "nfs", rootPath, fmt.Sprintf(`{"replicas": {"dr": {"path": %q, "interval": "1h"}}, "namespace": "team-a", "mock": true}`, replicaPath))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+
+	assert.Error(t, driver.Create("test", map[string]string{"replicate": "undefined"}))
+	assert.NoError(t, driver.Create("test", map[string]string{"replicate": "dr"}))
+	assert.NoError(t, driver.Create("other", nil))
+	assert.Error(t, driver.Create("other", map[string]string{"update": "true", "replicate": "undefined"}))
+	assert.NoError(t, driver.Create("other", map[string]string{"update": "true", "replicate": "dr"}))
+
+	// The namespace is replicated into the same namespace of the target
+	assert.NoError(t, driver.(*nfs).replicator.Run("dr"))
+	for _, name := range []string{"test", "other"} {
+		_, err = os.Stat(path.Join(replicaPath, "team-a", name, "_data"))
+		assert.NoError(t, err)
+		metadata, err := driver.Get(name)
+		assert.NoError(t, err)
+		assert.NotNil(t, metadata.Status.Replication.LastSync)
+	}
+}
+
+func TestLocalQuotas(t *testing.T) {
+	_, err := New(context.Background(), log.New("local"), "local", t.TempDir(), `{"quotaMode": "invalid", "mock": true}`)
+	assert.Error(t, err)
+
+	// Test XFS project quotas
+	rootPath := t.TempDir()
+	driver, err := New(context.Background(), log.New("local"), "local", rootPath, `{"quotaMode": "xfs", "trashRetention": "1h", "mock": true}`)
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Create("unlimited", nil))
+	assert.NoError(t, driver.Create("test", map[string]string{"quota": "1Gi"}))
+	assert.NoError(t, driver.Create("other", map[string]string{"quota": "1Gi"}))
+	assert.NoError(t, driver.Create("unlimited", map[string]string{"update": "true", "quota": "2Gi"}))
+	projectIDs := map[string]uint32{}
+	for _, name := range []string{"test", "other", "unlimited"} {
+		metadata, err := driver.Get(name)
+		assert.NoError(t, err)
+		projectIDs[name] = metadata.Status.ProjectID
+	}
+	assert.Equal(t, map[string]uint32{"test": minProjectID, "other": minProjectID + 1, "unlimited": minProjectID + 2}, projectIDs)
+
+	// The removed volumes release their projects, and the restored volumes get another one if theirs is allocated again
+	assert.NoError(t, driver.Create("trashed", map[string]string{"quota": "1Gi", "purgeAfterDelete": "true"}))
+	assert.NoError(t, driver.Remove("trashed"))
+	assert.NoError(t, driver.Create("new", map[string]string{"quota": "1Gi"}))
+	metadata, err := driver.Get("new")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(minProjectID+3), metadata.Status.ProjectID)
+	trashEntries, err := driver.(*local).storage.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, trashEntries, 1)
+	assert.NoError(t, driver.Create("restored", map[string]string{"restoreTrash": trashEntries[0].Name}))
+	metadata, err = driver.Get("restored")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(minProjectID+4), metadata.Status.ProjectID)
+	assert.NoError(t, driver.Destroy())
+
+	// Test loop images
+	rootPath = t.TempDir()
+	driver, err = New(context.Background(), log.New("local"), "local", rootPath, `{"quotaMode": "loop", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+	assert.NoError(t, driver.Create("unlimited", nil))
+	_, err = os.Stat(path.Join(rootPath, "unlimited", loopImageName))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, driver.Create("test", map[string]string{"quota": "16Mi"}))
+	info, err := os.Stat(path.Join(rootPath, "test", loopImageName))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(16<<20), info.Size())
+
+	// The images can grow but not shrink or be removed
+	assert.NoError(t, driver.Create("test", map[string]string{"update": "true", "quota": "32Mi"}))
+	info, err = os.Stat(path.Join(rootPath, "test", loopImageName))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(32<<20), info.Size())
+	assert.Error(t, driver.Create("test", map[string]string{"update": "true", "quota": "16Mi"}))
+	assert.Error(t, driver.Create("test", map[string]string{"update": "true", "quota": "0"}))
+	metadata, err = driver.Get("test")
+	assert.NoError(t, err)
+	assert.Equal(t, apis.Size(32<<20), metadata.Spec.Quota)
+
+	// A quota added to an existing volume creates its image
+	assert.NoError(t, driver.Create("unlimited", map[string]string{"update": "true", "quota": "16Mi"}))
+	_, err = os.Stat(path.Join(rootPath, "unlimited", loopImageName))
+	assert.NoError(t, err)
+
+	assert.NoError(t, driver.Remove("test"))
+	_, err = driver.Get("test")
+	assert.Error(t, err)
+}
+
+func TestLoopfs(t *testing.T) {
+	for _, driverOptions := range []string{
+		`{"backend": "loopfs", "mock": true}`,
+		`{"defaultSize": "0", "mock": true}`,
+		`{"backup": {"directory": "/backups"}, "mock": true}`,
+		`{"sharedVolumes": {"shared": "other/volume"}, "mock": true}`,
+	} {
+		_, err := New(context.Background(), log.New("loopfs"), "loopfs", t.TempDir(), driverOptions)
+		assert.Error(t, err, driverOptions)
+	}
+
+	rootPath := t.TempDir()
+	driver, err := New(context.Background(), log.New("loopfs"), "loopfs", rootPath, `{"defaultSize": "16Mi", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+	imageSize := func(name string) int64 {
+		info, err := os.Stat(path.Join(rootPath, name, loopImageName))
+		assert.NoError(t, err)
+		return info.Size()
+	}
+
+	// The volumes without a quota get images of the default size
+	assert.NoError(t, driver.Create("test", nil))
+	assert.Equal(t, int64(16<<20), imageSize("test"))
+	assert.NoError(t, driver.Create("limited", map[string]string{"quota": "32Mi"}))
+	assert.Equal(t, int64(32<<20), imageSize("limited"))
+
+	// The images can grow but not shrink
+	assert.NoError(t, driver.Create("limited", map[string]string{"update": "true", "quota": "64Mi"}))
+	assert.Equal(t, int64(64<<20), imageSize("limited"))
+	assert.Error(t, driver.Create("limited", map[string]string{"update": "true", "quota": "32Mi"}))
+	assert.Error(t, driver.Create("limited", map[string]string{"update": "true", "quota": "0"}))
+	assert.Equal(t, int64(64<<20), imageSize("limited"))
+
+	// The image is locked until the last unmount
+	lock := flock.New(path.Join(rootPath, "test", loopImageLockName))
+	_, err = driver.Mount("test", "first")
+	assert.NoError(t, err)
+	_, err = driver.Mount("test", "second")
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Create("test", map[string]string{"update": "true", "quota": "32Mi"}))
+	assert.Equal(t, int64(32<<20), imageSize("test"))
+	assert.NoError(t, driver.Unmount("test", "first"))
+	locked, err := lock.TryLock()
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.Error(t, driver.Remove("test"))
+
+	assert.NoError(t, driver.Unmount("test", "second"))
+	locked, err = lock.TryLock()
+	assert.NoError(t, err)
+	assert.True(t, locked)
+
+	// The images locked by another plugin can't be mounted
+	_, err = driver.Mount("test", "third")
+	assert.Error(t, err)
+	assert.NoError(t, lock.Unlock())
+	_, err = driver.Mount("test", "third")
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Unmount("test", "third"))
+	assert.NoError(t, driver.Remove("test"))
+}
+
+func TestTmpfs(t *testing.T) {
+	for _, driverOptions := range []string{
+		`{"backupSchedules": [{"name": "hourly", "schedule": "@hourly"}], "mock": true}`,
+		`{"sharedVolumes": {"shared": "other/volume"}, "mock": true}`,
+	} {
+		_, err := New(context.Background(), log.New("tmpfs"), "tmpfs", t.TempDir(), driverOptions)
+		assert.Error(t, err, driverOptions)
+	}
+
+	driver, err := New(context.Background(), log.New("tmpfs"), "tmpfs", t.TempDir(), `{"defaultSize": "16Mi", "mock": true}`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, driver.Destroy())
+	}()
+	tmpfsDriver := driver.(*tmpfs)
+	spec := func(name string) *apis.VolumeSpec {
+		metadata, err := tmpfsDriver.fetch(name)
+		assert.NoError(t, err)
+		return metadata.Spec
+	}
+
+	// The tmpfs options are kept in the spec
+	assert.NoError(t, driver.Create("test", map[string]string{"size": "64Mi", "mode": "1777", "uid": "1000"}))
+	assert.Equal(t, []string{"nosuid", "nodev", "size=67108864", "mode=1777", "uid=1000"}, tmpfsDriver.mountOptions(spec("test")))
+	assert.NoError(t, driver.Create("test", map[string]string{"size": "32Mi"}))
+	assert.Equal(t, apis.Size(64<<20), spec("test").Tmpfs.Size)
+	assert.NoError(t, driver.Create("test", map[string]string{"update": "true", "size": "32Mi", "gid": "1000"}))
+	assert.Equal(t, []string{"nosuid", "nodev", "size=33554432", "mode=1777", "uid=1000", "gid=1000"}, tmpfsDriver.mountOptions(spec("test")))
+	assert.Error(t, driver.Create("invalid", map[string]string{"mode": "888"}))
+	assert.Error(t, driver.Create("invalid", map[string]string{"uid": "-1"}))
+	assert.Error(t, driver.Create("invalid", map[string]string{"size": "8Mi", "unknown": "true"}))
+	assert.Error(t, driver.Create("invalid", map[string]string{"size": "8Mi", "restoreTrash": "test-20261018T000000Z"}))
+	_, err = driver.Get("invalid")
+	assert.Error(t, err)
+
+	// The size option precedes the quota which precedes the default size
+	assert.NoError(t, driver.Create("limited", map[string]string{"quota": "8Mi"}))
+	assert.Equal(t, []string{"nosuid", "nodev", "size=8388608"}, tmpfsDriver.mountOptions(spec("limited")))
+	assert.NoError(t, driver.Create("default", nil))
+	assert.Equal(t, []string{"nosuid", "nodev", "size=16777216"}, tmpfsDriver.mountOptions(spec("default")))
+
+	// The size can't exceed the quota, which is checked against maxQuota
+	assert.Error(t, driver.Create("oversized", map[string]string{"quota": "1Mi", "size": "100Mi"}))
+	_, err = driver.Get("oversized")
+	assert.Error(t, err)
+	assert.Error(t, driver.Create("limited", map[string]string{"update": "true", "size": "16Mi"}))
+	assert.NoError(t, driver.Create("limited", map[string]string{"update": "true", "size": "4Mi"}))
+	assert.Error(t, driver.Create("limited", map[string]string{"update": "true", "quota": "2Mi"}))
+	assert.Equal(t, []string{"nosuid", "nodev", "size=4194304"}, tmpfsDriver.mountOptions(spec("limited")))
+
+	// The tmpfs is mounted until the last unmount
+	_, err = driver.Mount("test", "first")
+	assert.NoError(t, err)
+	_, err = driver.Mount("test", "second")
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Unmount("test", "first"))
+	assert.Error(t, driver.Remove("test"))
+	assert.NoError(t, driver.Unmount("test", "second"))
+	assert.NoError(t, driver.Remove("test"))
+}
@@ -0,0 +1,125 @@
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/replication"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+// loopImageName is the image file in the volume directory which is loop mounted at the data directory
+const loopImageName = "_image"
+
+// loopImage is the image file of a volume which is loop mounted at its data directory, in mock mode the image is created but never formatted or mounted.
+type loopImage struct {
+	imagePath string
+	dataPath  string
+	mock      bool
+}
+
+// newLoopImage returns the image of the volume whose mountpoint is relative to the root path.
+func newLoopImage(rootPath string, metadata *apis.VolumeMetadata, mock bool) *loopImage {
+	return &loopImage{
+		imagePath: path.Join(rootPath, path.Dir(metadata.Status.Mountpoint), loopImageName),
+		dataPath:  path.Join(rootPath, metadata.Status.Mountpoint),
+		mock:      mock,
+	}
+}
+
+// size returns the size of the image, zero means the image doesn't exist.
+func (image *loopImage) size() (int64, error) {
+	info, err := os.Stat(image.imagePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get image: %v", err)
+	}
+	return info.Size(), nil
+}
+
+// create creates the image with a filesystem of the type and moves the content of the data directory into it, the image is left unmounted.
+func (image *loopImage) create(size int64, fsType string, mkfsOptions []string) error {
+	if err := utils.CreateImage(image.imagePath, size); err != nil {
+		return err
+	}
+	if image.mock {
+		return nil
+	}
+
+	err := utils.FormatImage(image.imagePath, fsType, append([]string{"-q"}, mkfsOptions...))
+	if err == nil {
+		err = image.copyData()
+	}
+	if err != nil {
+		_ = os.Remove(image.imagePath)
+		return err
+	}
+
+	// The data is in the image from here on, it is only removed from the data directory once it is copied
+	entries, err := os.ReadDir(image.dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to read data: %v", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(path.Join(image.dataPath, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove data copied into image: %v", err)
+		}
+	}
+	return nil
+}
+
+// copyData copies the content of the data directory into the new image through a temporary mount point.
+func (image *loopImage) copyData() error {
+	stagingPath, err := os.MkdirTemp(path.Dir(image.imagePath), loopImageName+".staging.")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(stagingPath)
+	}()
+
+	if err := utils.MountLoop(image.imagePath, stagingPath, nil); err != nil {
+		return err
+	}
+	_, err = replication.SyncTree(image.dataPath, stagingPath, false)
+	if umountErr := utils.Umount(stagingPath); err == nil {
+		err = umountErr
+	}
+	return err
+}
+
+// mount mounts the image at the data directory unless it is already mounted.
+func (image *loopImage) mount(mountOptions []string) error {
+	if image.mock {
+		return nil
+	}
+	mounted, err := utils.IsMounted(image.dataPath)
+	if err != nil || mounted {
+		return err
+	}
+	return utils.MountLoop(image.imagePath, image.dataPath, mountOptions)
+}
+
+// umount unmounts the image from the data directory if it is mounted.
+func (image *loopImage) umount() error {
+	if image.mock {
+		return nil
+	}
+	mounted, err := utils.IsMounted(image.dataPath)
+	if err != nil || !mounted {
+		return err
+	}
+	return utils.Umount(image.dataPath)
+}
+
+// grow grows the mounted image and its filesystem to the size.
+func (image *loopImage) grow(size int64) error {
+	if image.mock {
+		return os.Truncate(image.imagePath, size)
+	}
+	return utils.GrowLoop(image.imagePath, image.dataPath, size)
+}
@@ -0,0 +1,178 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+func init() {
+	registerFactory("local", localFactory)
+}
+
+// local is an implementation of the Driver interface for managing volumes in a directory of the node.
+type local struct {
+	*builtin
+	opts     *localDriverOptions
+	rootPath string
+	quota    quotaEnforcer
+}
+
+type localDriverOptions struct {
+	builtinDriverOptions
+
+	// Path of the directory keeping the volumes, which is bound to the mount root path, empty means the mount root path itself
+	Path string `json:"path,omitempty"`
+
+	// QuotaMode selects how the quotas of the volumes are enforced, one of none, xfs and loop
+	QuotaMode string `json:"quotaMode,omitempty"`
+
+	// LoopFilesystem is the filesystem created in the images of the loop quota mode
+	LoopFilesystem string `json:"loopFilesystem,omitempty"`
+
+	// Mock indicates whether to run in mock mode (no actual bind, quota or loop mount)
+	Mock bool `json:"mock,omitempty"`
+}
+
+func localFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+	opts := &localDriverOptions{
+		builtinDriverOptions: defaultBuiltinDriverOptions(),
+		QuotaMode:            quotaModeNone,
+		LoopFilesystem:       "ext4",
+		Mock:                 false,
+	}
+	if err := json.Unmarshal([]byte(driverOptions), opts); err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %s", err)
+	}
+
+	// Bind the directory to a local mount point
+	bound := opts.Path != "" && !opts.Mock
+	if err := utils.MountMock(propagatedMountpoint); err != nil {
+		return nil, fmt.Errorf("failed to create mount point: %s", err)
+	}
+	if opts.Mock {
+		logger.Warning("Mock mode enabled, no actual bind, quota or loop mount will be performed")
+	} else if bound {
+		if err := os.MkdirAll(opts.Path, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %s", opts.Path, err)
+		}
+		if err := utils.Bind(opts.Path, propagatedMountpoint, nil); err != nil {
+			return nil, fmt.Errorf("failed to bind directory %s: %s", opts.Path, err)
+		}
+	}
+	cleanup := func() {
+		if bound {
+			if err := utils.Umount(propagatedMountpoint); err != nil {
+				logger.Errorf("failed to unbind local mount root path %s: %s", propagatedMountpoint, err)
+			}
+		}
+	}
+
+	base, err := newBuiltin(ctx, logger, propagatedMountpoint, &opts.builtinDriverOptions, opts.Mock)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	quota, err := newQuotaEnforcer(base, opts.QuotaMode, opts.LoopFilesystem)
+	if err == nil && quota != nil {
+		err = applyQuotas(base, quota)
+	}
+	if err != nil {
+		_ = base.Destroy()
+		if quota != nil {
+			_ = quota.close()
+		}
+		cleanup()
+		return nil, fmt.Errorf("failed to enforce quotas: %v", err)
+	}
+
+	return &local{
+		builtin:  base,
+		opts:     opts,
+		rootPath: propagatedMountpoint,
+		quota:    quota,
+	}, nil
+}
+
+// Create creates or updates the volume and then enforces its quota.
+func (driver *local) Create(name string, options map[string]string) error {
+	if driver.quota == nil {
+		return driver.builtin.Create(name, options)
+	}
+
+	if update, _ := strconv.ParseBool(options["update"]); update {
+		if value, existed := options["quota"]; existed {
+			quota, err := apis.ParseSize(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for quota: %v", err)
+			}
+			metadata, err := driver.fetch(name)
+			if err != nil {
+				return err
+			}
+			if err := driver.quota.check(metadata, quota); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := driver.builtin.Create(name, options); err != nil {
+		return err
+	}
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return err
+	}
+	return driver.quota.apply(name, metadata)
+}
+
+// Remove stops enforcing the quota of the volume and then removes it.
+func (driver *local) Remove(name string) error {
+	if driver.quota == nil || driver.sharedVolumes[name] != nil {
+		return driver.builtin.Remove(name)
+	}
+
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return err
+	}
+	if err := driver.quota.release(name, metadata); err != nil {
+		return fmt.Errorf("failed to release quota of volume %s: %v", name, err)
+	}
+	if err := driver.builtin.Remove(name); err != nil {
+		if err := driver.quota.apply(name, metadata); err != nil {
+			driver.logger.Errorf("failed to enforce quota of volume %s again: %v", name, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (driver *local) Destroy() error {
+	err := driver.builtin.Destroy()
+	if err != nil {
+		return err
+	}
+
+	if driver.quota != nil {
+		if err := driver.quota.close(); err != nil {
+			return fmt.Errorf("failed to release quotas: %s", err)
+		}
+	}
+
+	if driver.opts.Path != "" && !driver.opts.Mock {
+		err = utils.Umount(driver.rootPath)
+		if err != nil {
+			return fmt.Errorf("failed to unbind local mount root path %s: %s", driver.rootPath, err)
+		}
+	}
+
+	return nil
+}
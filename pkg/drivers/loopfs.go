@@ -0,0 +1,347 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/gofrs/flock"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+)
+
+func init() {
+	registerFactory("loopfs", loopfsFactory)
+}
+
+// loopImageLockName is the lock file in the volume directory which is held while the image is mounted, so only one node mounts it at a time
+const loopImageLockName = "_image.lock"
+
+// loopfsBackends are the drivers which can keep the images of the loopfs driver.
+var loopfsBackends = map[string]bool{"nfs": true, "cifs": true, "local": true}
+
+// loopfs is an implementation of the Driver interface which keeps each volume in an image file in the volume directory of a backend driver,
+// the image is loop mounted at the data directory while the volume is mounted, so the size of the volume is enforced by its filesystem.
+type loopfs struct {
+	apis.Driver
+	logger   *log.Logger
+	opts     *loopfsDriverOptions
+	rootPath string
+
+	// mounts are the mounted images by volume name
+	mounts map[string]*loopfsMount
+	mutex  sync.Mutex
+}
+
+// loopfsMount is a mounted image with the ids of the mounts of its volume.
+type loopfsMount struct {
+	image *loopImage
+	lock  *flock.Flock
+	ids   map[string]struct{}
+}
+
+type loopfsDriverOptions struct {
+	// Backend is the driver keeping the volume directories, the other driver options are passed to it
+	Backend string `json:"backend,omitempty"`
+
+	// Filesystem created in the images
+	Filesystem string `json:"filesystem,omitempty"`
+
+	// MkfsOptions are the extra options of mkfs when the images are formatted
+	MkfsOptions []string `json:"mkfsOptions,omitempty"`
+
+	// ImageMountOptions are the options of the loop mounts of the images
+	ImageMountOptions []string `json:"imageMountOptions,omitempty"`
+
+	// DefaultSize is the size of the images of the volumes without a quota
+	DefaultSize apis.Size `json:"defaultSize,omitempty"`
+
+	// Mock indicates whether to run in mock mode (no actual mkfs or loop mount)
+	Mock bool `json:"mock,omitempty"`
+}
+
+func loopfsFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+	opts := &loopfsDriverOptions{
+		Backend:           "local",
+		Filesystem:        "ext4",
+		MkfsOptions:       []string{},
+		ImageMountOptions: []string{},
+		DefaultSize:       apis.Size(10 << 30),
+		Mock:              false,
+	}
+	if err := json.Unmarshal([]byte(driverOptions), opts); err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %s", err)
+	}
+	if !loopfsBackends[opts.Backend] {
+		return nil, fmt.Errorf("driver %s can't be the backend of loopfs", opts.Backend)
+	}
+	if opts.DefaultSize == 0 {
+		return nil, fmt.Errorf("default size of images can't be zero")
+	}
+
+	backendOpts := &builtinDriverOptions{}
+	if err := json.Unmarshal([]byte(driverOptions), backendOpts); err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %s", err)
+	}
+	if err := checkUnmountedData(backendOpts); err != nil {
+		return nil, fmt.Errorf("loopfs %s", err)
+	}
+
+	backend, err := New(ctx, logger, opts.Backend, propagatedMountpoint, driverOptions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Mock {
+		logger.Warning("Mock mode enabled, no actual mkfs or loop mount will be performed")
+	}
+
+	return &loopfs{
+		Driver:   backend,
+		logger:   logger,
+		opts:     opts,
+		rootPath: propagatedMountpoint,
+		mounts:   map[string]*loopfsMount{},
+	}, nil
+}
+
+// Create creates or updates the volume in the backend and then creates or grows its image.
+func (driver *loopfs) Create(name string, options map[string]string) error {
+	if update, _ := strconv.ParseBool(options["update"]); update {
+		if value, existed := options["quota"]; existed {
+			quota, err := apis.ParseSize(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for quota: %v", err)
+			}
+			if err := driver.checkSize(name, quota); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := driver.Driver.Create(name, options); err != nil {
+		return err
+	}
+	metadata, err := driver.Driver.Get(name)
+	if err != nil {
+		return err
+	}
+
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.prepare(name, metadata)
+}
+
+// checkSize checks the image of the volume can be resized for the quota, the images can't shrink.
+func (driver *loopfs) checkSize(name string, quota apis.Size) error {
+	metadata, err := driver.Driver.Get(name)
+	if err != nil {
+		return err
+	}
+	size, err := newLoopImage(driver.rootPath, metadata, driver.opts.Mock).size()
+	if err != nil {
+		return err
+	}
+	if int64(driver.imageSize(quota)) < size {
+		return fmt.Errorf("image of volume %s can't shrink below %s", name, apis.Size(size))
+	}
+	return nil
+}
+
+// imageSize returns the size of the image for the quota.
+func (driver *loopfs) imageSize(quota apis.Size) apis.Size {
+	if quota == 0 {
+		return driver.opts.DefaultSize
+	}
+	return quota
+}
+
+// prepare creates the image of the volume if it doesn't exist, or grows it to the quota, the unmounted images are mounted meanwhile.
+func (driver *loopfs) prepare(name string, metadata *apis.VolumeMetadata) error {
+	image := newLoopImage(driver.rootPath, metadata, driver.opts.Mock)
+	size, err := image.size()
+	if err != nil {
+		return fmt.Errorf("failed to get image of volume %s: %v", name, err)
+	}
+	targetSize := int64(driver.imageSize(metadata.Spec.Quota))
+	if size != 0 && targetSize <= size {
+		return nil
+	}
+
+	if mount := driver.mounts[name]; mount != nil {
+		if err := image.grow(targetSize); err != nil {
+			return fmt.Errorf("failed to grow image of volume %s: %v", name, err)
+		}
+		return nil
+	}
+
+	lock, err := lockImage(name, image)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	if size == 0 {
+		if err := image.create(targetSize, driver.opts.Filesystem, driver.opts.MkfsOptions); err != nil {
+			return fmt.Errorf("failed to create image of volume %s: %v", name, err)
+		}
+		driver.logger.Infof("created image of volume %s of %s", name, apis.Size(targetSize))
+		return nil
+	}
+
+	if err := image.mount(driver.opts.ImageMountOptions); err != nil {
+		return fmt.Errorf("failed to mount image of volume %s: %v", name, err)
+	}
+	err = image.grow(targetSize)
+	if umountErr := image.umount(); err == nil && umountErr != nil {
+		err = fmt.Errorf("failed to unmount image of volume %s: %v", name, umountErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to grow image of volume %s: %v", name, err)
+	}
+	driver.logger.Infof("grew image of volume %s to %s", name, apis.Size(targetSize))
+	return nil
+}
+
+// lockImage acquires the lock of the image, which fails if the image is mounted by another plugin.
+func lockImage(name string, image *loopImage) (*flock.Flock, error) {
+	lock := flock.New(path.Join(path.Dir(image.imagePath), loopImageLockName))
+	locked, err := lock.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock of image of volume %s: %v", name, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("image of volume %s is mounted by another plugin", name)
+	}
+	return lock, nil
+}
+
+// Mount mounts the image of the volume on the first mount and then mounts the volume in the backend.
+func (driver *loopfs) Mount(name string, id string) (string, error) {
+	metadata, err := driver.Driver.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	mount := driver.mounts[name]
+	if mount == nil {
+		// The image of a volume created by an older driver or another plugin may not exist yet
+		if err := driver.prepare(name, metadata); err != nil {
+			return "", err
+		}
+		image := newLoopImage(driver.rootPath, metadata, driver.opts.Mock)
+		lock, err := lockImage(name, image)
+		if err != nil {
+			return "", err
+		}
+		if err := image.mount(driver.opts.ImageMountOptions); err != nil {
+			_ = lock.Unlock()
+			return "", fmt.Errorf("failed to mount image of volume %s: %v", name, err)
+		}
+		mount = &loopfsMount{image: image, lock: lock, ids: map[string]struct{}{}}
+		driver.mounts[name] = mount
+	}
+
+	mountpoint, err := driver.Driver.Mount(name, id)
+	if err != nil {
+		if len(mount.ids) == 0 {
+			if err := driver.umount(name, mount); err != nil {
+				driver.logger.Errorf("failed to unmount image of volume %s: %v", name, err)
+			}
+		}
+		return "", err
+	}
+	mount.ids[id] = struct{}{}
+	return mountpoint, nil
+}
+
+// Unmount unmounts the volume in the backend and then unmounts its image on the last unmount.
+func (driver *loopfs) Unmount(name string, id string) error {
+	if err := driver.Driver.Unmount(name, id); err != nil {
+		return err
+	}
+
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	mount := driver.mounts[name]
+	if mount == nil {
+		return nil
+	}
+	if _, existed := mount.ids[id]; !existed {
+		return nil
+	}
+	delete(mount.ids, id)
+	if len(mount.ids) != 0 {
+		return nil
+	}
+	return driver.umount(name, mount)
+}
+
+// umount unmounts the image and releases its lock.
+func (driver *loopfs) umount(name string, mount *loopfsMount) error {
+	if err := mount.image.umount(); err != nil {
+		return fmt.Errorf("failed to unmount image of volume %s: %v", name, err)
+	}
+	delete(driver.mounts, name)
+	if err := mount.lock.Unlock(); err != nil {
+		driver.logger.Errorf("failed to unlock flock: %v", err)
+	}
+	return nil
+}
+
+// volumeStorage returns the storage of the backend, the data copied into the data directory of a volume is moved into its image
+// when the volume is updated.
+func (driver *loopfs) volumeStorage() *storage.Builtin {
+	return driver.Driver.(storageDriver).volumeStorage()
+}
+
+// checkMigration refuses the migrations from loopfs, since the data is in the images.
+func (driver *loopfs) checkMigration(source bool) error {
+	if source {
+		return fmt.Errorf("loopfs keeps the data in images which are only mounted with the volumes")
+	}
+	return nil
+}
+
+// ResolveSpecOptions resolves the spec options by the backend.
+func (driver *loopfs) ResolveSpecOptions(options map[string]string) (map[string]string, error) {
+	if resolver, ok := driver.Driver.(apis.SpecResolver); ok {
+		return resolver.ResolveSpecOptions(options)
+	}
+	return options, nil
+}
+
+// Remove removes the volume from the backend, the mounted volumes can't be removed.
+func (driver *loopfs) Remove(name string) error {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	if driver.mounts[name] != nil {
+		return fmt.Errorf("volume %s is mounted", name)
+	}
+	return driver.Driver.Remove(name)
+}
+
+// Destroy unmounts all images and then destroys the backend.
+func (driver *loopfs) Destroy() error {
+	driver.mutex.Lock()
+	errs := []error{}
+	for name, mount := range driver.mounts {
+		if err := driver.umount(name, mount); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	driver.mutex.Unlock()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return driver.Driver.Destroy()
+}
@@ -0,0 +1,93 @@
+package drivers
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+)
+
+// storageDriver is implemented by the drivers which keep their volumes in a storage.Builtin, the volumes are migrated between their storages.
+type storageDriver interface {
+	volumeStorage() *storage.Builtin
+}
+
+func (driver *builtin) volumeStorage() *storage.Builtin {
+	return driver.storage
+}
+
+// migrationChecker is implemented by the drivers which can't be the source or the target of migrations, since they don't keep the data
+// in the data directories of their volumes.
+type migrationChecker interface {
+	checkMigration(source bool) error
+}
+
+// MigrateVolume copies the volume from the source driver to the target driver and moves the source into the trash for the retention,
+// and returns the name of the trash entry. The volume is streamed as a quiesced backup into a restore, so it is verified against
+// the checksums of the source before it appears in the target, and read back from the target before the source is trashed.
+// The copied volume is then updated through the target driver, which sets it up like the volumes it creates, e.g. enforces its quota.
+func MigrateVolume(source apis.Driver, target apis.Driver, name string, retention time.Duration) (string, error) {
+	sourceDriver, ok := source.(storageDriver)
+	if !ok {
+		return "", fmt.Errorf("source driver doesn't support migration")
+	}
+	targetDriver, ok := target.(storageDriver)
+	if !ok {
+		return "", fmt.Errorf("target driver doesn't support migration")
+	}
+	if checker, ok := source.(migrationChecker); ok {
+		if err := checker.checkMigration(true); err != nil {
+			return "", fmt.Errorf("source driver doesn't support migration: %v", err)
+		}
+	}
+	if checker, ok := target.(migrationChecker); ok {
+		if err := checker.checkMigration(false); err != nil {
+			return "", fmt.Errorf("target driver doesn't support migration: %v", err)
+		}
+	}
+	if retention <= 0 {
+		return "", fmt.Errorf("trash retention of the source must be positive")
+	}
+	sourceStorage, targetStorage := sourceDriver.volumeStorage(), targetDriver.volumeStorage()
+
+	if _, err := sourceStorage.FetchVolumeMetadata(name); err != nil {
+		return "", fmt.Errorf("failed to get volume %s: %w", name, err)
+	}
+
+	reader, writer := io.Pipe()
+	backupDone := make(chan struct{})
+	go func() {
+		defer close(backupDone)
+		_, err := sourceStorage.BackupVolume(name, writer, true)
+		writer.CloseWithError(err)
+	}()
+	manifest, err := targetStorage.RestoreVolumeFromBackup(name, reader)
+	_ = reader.CloseWithError(io.ErrClosedPipe)
+	// The source is only released once the backup has returned
+	<-backupDone
+	if err != nil {
+		return "", fmt.Errorf("failed to copy volume %s: %v", name, err)
+	}
+
+	deleteCopy := func() {
+		if err := targetStorage.DeleteVolumeMetadata(name); err == nil {
+			_ = targetStorage.DeleteVolume(name)
+		}
+	}
+	if err := targetStorage.VerifyVolume(name, manifest); err != nil {
+		deleteCopy()
+		return "", fmt.Errorf("failed to verify copied volume %s: %v", name, err)
+	}
+	if err := target.Create(name, map[string]string{"update": "true"}); err != nil {
+		deleteCopy()
+		return "", fmt.Errorf("failed to set up copied volume %s in target driver: %v", name, err)
+	}
+
+	entry, err := sourceStorage.TrashVolume(name, retention)
+	if err != nil {
+		return "", fmt.Errorf("volume %s is copied but the source can't be moved into trash: %v", name, err)
+	}
+	return entry, nil
+}
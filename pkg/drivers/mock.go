@@ -39,13 +39,35 @@ type mock struct {
 }
 
 func (driver *mock) Create(name string, options map[string]string) error {
+	createOptions := &apis.CreateOptions{}
+	specOptions, err := createOptions.Unmarshal(options)
+	if err != nil {
+		return err
+	}
+
+	if createOptions.RestoreFrom != "" {
+		return fmt.Errorf("restoreFrom is not supported by the mock driver")
+	}
+
+	if createOptions.Update {
+		if driver.volumeMetadataMap[name] == nil {
+			return fmt.Errorf("volume %s does not exist", name)
+		}
+		spec := *driver.volumeMetadataMap[name].Spec
+		if err := spec.Update(specOptions); err != nil {
+			return err
+		}
+		driver.volumeMetadataMap[name].Spec = &spec
+		return nil
+	}
+
 	if driver.volumeMetadataMap[name] != nil {
 		driver.logger.Warning(fmt.Sprintf("Volume %s already exists, skipping creation", name))
 		return nil
 	}
 
 	spec := &apis.VolumeSpec{}
-	if err := spec.Unmarshal(options); err != nil {
+	if err := spec.Unmarshal(specOptions); err != nil {
 		return err
 	}
 
@@ -0,0 +1,245 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+const (
+	// quotaModeNone doesn't enforce the quotas, they are only recorded
+	quotaModeNone = "none"
+
+	// quotaModeXFS enforces the quotas by XFS project quotas of the data directories
+	quotaModeXFS = "xfs"
+
+	// quotaModeLoop enforces the quotas by keeping the data in loop mounted image files of the quota size
+	quotaModeLoop = "loop"
+
+	// minProjectID is the first XFS project allocated to the volumes, the lower ones are left to the projects of the administrators
+	minProjectID = 1 << 20
+)
+
+// quotaEnforcer enforces the quotas of the volumes of a builtin driver.
+type quotaEnforcer interface {
+	// check validates the quota before it is updated on the existing volume
+	check(metadata *apis.VolumeMetadata, quota apis.Size) error
+
+	// apply enforces the quota in the spec of the volume, it is called after the volume is created or updated
+	apply(name string, metadata *apis.VolumeMetadata) error
+
+	// release stops enforcing the quota before the volume is removed
+	release(name string, metadata *apis.VolumeMetadata) error
+
+	// close stops enforcing the quotas of all volumes
+	close() error
+}
+
+// newQuotaEnforcer creates the enforcer of the quota mode, nil means the quotas are not enforced.
+func newQuotaEnforcer(driver *builtin, mode string, fsType string) (quotaEnforcer, error) {
+	switch mode {
+	case "", quotaModeNone:
+		return nil, nil
+	case quotaModeXFS:
+		if !driver.mock {
+			if err := utils.CheckProjectQuota(driver.rootPath); err != nil {
+				return nil, err
+			}
+		}
+		return &projectQuota{driver: driver}, nil
+	case quotaModeLoop:
+		return &loopQuota{driver: driver, fsType: fsType, mounted: map[string]*loopImage{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown quota mode %s", mode)
+	}
+}
+
+// applyQuotas enforces the quotas of the existing volumes when the driver starts, e.g. mounts their images.
+func applyQuotas(driver *builtin, enforcer quotaEnforcer) error {
+	names := []string{}
+	err := driver.storage.WalkVolumeMetadata(func(name string, metadata *apis.VolumeMetadata) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+
+	errs := []error{}
+	for _, name := range names {
+		metadata, err := driver.fetch(name)
+		if err == nil {
+			err = enforcer.apply(name, metadata)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// projectQuota assigns each volume with a quota to an XFS project whose hard limit is the quota.
+type projectQuota struct {
+	driver *builtin
+	mutex  sync.Mutex
+}
+
+func (enforcer *projectQuota) check(metadata *apis.VolumeMetadata, quota apis.Size) error {
+	return nil
+}
+
+func (enforcer *projectQuota) apply(name string, metadata *apis.VolumeMetadata) error {
+	if metadata.Spec.Quota == 0 && metadata.Status.ProjectID == 0 {
+		return nil
+	}
+
+	projectID, err := enforcer.allocate(name, metadata.Status.ProjectID)
+	if err != nil {
+		return err
+	}
+	if projectID != metadata.Status.ProjectID {
+		err = enforcer.driver.storage.UpdateVolumeMetadata(name, func(metadata *apis.VolumeMetadata) error {
+			metadata.Status.ProjectID = projectID
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record project of volume %s: %v", name, err)
+		}
+	}
+
+	if enforcer.driver.mock {
+		return nil
+	}
+	if err := utils.SetProjectQuota(path.Join(enforcer.driver.rootPath, metadata.Status.Mountpoint), projectID, int64(metadata.Spec.Quota)); err != nil {
+		return fmt.Errorf("failed to set quota of volume %s: %v", name, err)
+	}
+	return nil
+}
+
+// allocate returns the project of the volume, a new one is allocated if the volume has none or shares it with another volume,
+// e.g. a volume restored from the trash whose project was allocated again.
+func (enforcer *projectQuota) allocate(name string, projectID uint32) (uint32, error) {
+	enforcer.mutex.Lock()
+	defer enforcer.mutex.Unlock()
+
+	used := map[uint32]bool{}
+	maxID := uint32(minProjectID - 1)
+	err := enforcer.driver.storage.WalkVolumeMetadata(func(volumeName string, metadata *apis.VolumeMetadata) error {
+		if volumeName != name && metadata.Status.ProjectID != 0 {
+			used[metadata.Status.ProjectID] = true
+			maxID = max(maxID, metadata.Status.ProjectID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list volumes: %v", err)
+	}
+
+	if projectID != 0 && !used[projectID] {
+		return projectID, nil
+	}
+	if maxID == ^uint32(0) {
+		return 0, fmt.Errorf("no XFS project is left")
+	}
+	return maxID + 1, nil
+}
+
+// release clears the project of the data directory, so the data left in the trash or orphaned isn't counted against the project any more.
+func (enforcer *projectQuota) release(name string, metadata *apis.VolumeMetadata) error {
+	if metadata.Status.ProjectID == 0 || enforcer.driver.mock {
+		return nil
+	}
+	return utils.ClearProjectQuota(path.Join(enforcer.driver.rootPath, metadata.Status.Mountpoint), metadata.Status.ProjectID)
+}
+
+func (enforcer *projectQuota) close() error {
+	return nil
+}
+
+// loopQuota keeps the data of each volume with a quota in an image file of the quota size, which is loop mounted at the data directory
+// while the driver runs, so the other operations of the driver see the data as usual. The images can grow but not shrink.
+type loopQuota struct {
+	driver *builtin
+	fsType string
+
+	// mounted are the images mounted by their data directories
+	mounted map[string]*loopImage
+	mutex   sync.Mutex
+}
+
+func (enforcer *loopQuota) check(metadata *apis.VolumeMetadata, quota apis.Size) error {
+	size, err := newLoopImage(enforcer.driver.rootPath, metadata, enforcer.driver.mock).size()
+	if err != nil || size == 0 {
+		return err
+	}
+	if quota == 0 {
+		return fmt.Errorf("quota can't be removed from a volume kept in an image")
+	}
+	if int64(quota) < size {
+		return fmt.Errorf("quota of a volume kept in an image can't shrink below %s", apis.Size(size))
+	}
+	return nil
+}
+
+func (enforcer *loopQuota) apply(name string, metadata *apis.VolumeMetadata) error {
+	enforcer.mutex.Lock()
+	defer enforcer.mutex.Unlock()
+
+	image := newLoopImage(enforcer.driver.rootPath, metadata, enforcer.driver.mock)
+	size, err := image.size()
+	if err != nil {
+		return fmt.Errorf("failed to get image of volume %s: %v", name, err)
+	}
+	if size == 0 {
+		if metadata.Spec.Quota == 0 {
+			return nil
+		}
+		if err := image.create(int64(metadata.Spec.Quota), enforcer.fsType, nil); err != nil {
+			return fmt.Errorf("failed to create image of volume %s: %v", name, err)
+		}
+		size = int64(metadata.Spec.Quota)
+	}
+
+	if err := image.mount(nil); err != nil {
+		return fmt.Errorf("failed to mount image of volume %s: %v", name, err)
+	}
+	enforcer.mounted[image.dataPath] = image
+	if int64(metadata.Spec.Quota) <= size {
+		return nil
+	}
+	if err := image.grow(int64(metadata.Spec.Quota)); err != nil {
+		return fmt.Errorf("failed to grow image of volume %s: %v", name, err)
+	}
+	return nil
+}
+
+func (enforcer *loopQuota) release(name string, metadata *apis.VolumeMetadata) error {
+	enforcer.mutex.Lock()
+	defer enforcer.mutex.Unlock()
+
+	image := newLoopImage(enforcer.driver.rootPath, metadata, enforcer.driver.mock)
+	if err := image.umount(); err != nil {
+		return err
+	}
+	delete(enforcer.mounted, image.dataPath)
+	return nil
+}
+
+func (enforcer *loopQuota) close() error {
+	enforcer.mutex.Lock()
+	defer enforcer.mutex.Unlock()
+
+	errs := []error{}
+	for dataPath, image := range enforcer.mounted {
+		if err := image.umount(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unmount image at %s: %v", dataPath, err))
+			continue
+		}
+		delete(enforcer.mounted, dataPath)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+// remote is the base of the drivers which manage the volumes on a remote share mounted at the root path.
+type remote struct {
+	*builtin
+	kind string
+	mock bool
+}
+
+// newRemote mounts the remote share by the mount function, or a mock mount point in mock mode, and manages the volumes on it.
+// The share is unmounted again if the volumes can't be managed.
+func newRemote(ctx context.Context, logger *log.Logger, kind string, rootPath string, opts *builtinDriverOptions, mock bool, mount func() error) (*remote, error) {
+	if mock {
+		logger.Warningf("Mock mode enabled, no actual %s mount will be performed", kind)
+		if err := utils.MountMock(rootPath); err != nil {
+			return nil, fmt.Errorf("failed to create mock mount point: %s", err)
+		}
+	} else if err := mount(); err != nil {
+		return nil, err
+	}
+
+	base, err := newBuiltin(ctx, logger, rootPath, opts, mock)
+	if err != nil {
+		if !mock {
+			if err := utils.Umount(rootPath); err != nil {
+				logger.Errorf("failed to unmount %s mount root path %s: %s", kind, rootPath, err)
+			}
+		}
+		return nil, err
+	}
+
+	return &remote{
+		builtin: base,
+		kind:    kind,
+		mock:    mock,
+	}, nil
+}
+
+func (driver *remote) Destroy() error {
+	err := driver.builtin.Destroy()
+	if err != nil {
+		return err
+	}
+
+	if !driver.mock {
+		err = utils.Umount(driver.rootPath)
+		if err != nil {
+			return fmt.Errorf("failed to unmount %s mount root path %s: %s", driver.kind, driver.rootPath, err)
+		}
+	}
+
+	return nil
+}
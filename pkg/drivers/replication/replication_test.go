@@ -0,0 +1,200 @@
+package replication
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncTree(t *testing.T) {
+	sourcePath, replicaPath := t.TempDir(), t.TempDir()
+	modTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.MkdirAll(path.Join(sourcePath, "dir", "nested"), 0755))
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, "file"), []byte("data"), 0600))
+	assert.NoError(t, os.Chtimes(path.Join(sourcePath, "file"), modTime, modTime))
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, "dir", "nested", "file"), []byte("nested"), 0644))
+	assert.NoError(t, os.Symlink("file", path.Join(sourcePath, "link")))
+	assert.NoError(t, os.WriteFile(path.Join(replicaPath, "extraneous"), []byte("extraneous"), 0644))
+	assert.NoError(t, os.MkdirAll(path.Join(replicaPath, "dir", "nested", "file"), 0755))
+
+	checkReplica := func() {
+		data, err := os.ReadFile(path.Join(replicaPath, "link"))
+		assert.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+		info, err := os.Stat(path.Join(replicaPath, "file"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assert.True(t, modTime.Equal(info.ModTime()))
+		data, err = os.ReadFile(path.Join(replicaPath, "dir", "nested", "file"))
+		assert.NoError(t, err)
+		assert.Equal(t, "nested", string(data))
+		_, err = os.Stat(path.Join(replicaPath, "extraneous"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// The first sync copies all files, deletes the extraneous file and replaces the directory which is a file in the source
+	result, err := SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncResult{Copied: 2, Bytes: 10, Deleted: 1}, result)
+	checkReplica()
+
+	// The files whose size and modification time match are skipped
+	result, err = SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncResult{}, result)
+
+	// The files whose content changed but size and modification time match are only copied with checksum
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, "file"), []byte("DATA"), 0600))
+	assert.NoError(t, os.Chtimes(path.Join(sourcePath, "file"), modTime, modTime))
+	result, err = SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Copied)
+	result, err = SyncTree(sourcePath, replicaPath, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Copied)
+	data, err := os.ReadFile(path.Join(replicaPath, "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "DATA", string(data))
+
+	// The deleted entries are deleted from the replica
+	assert.NoError(t, os.RemoveAll(path.Join(sourcePath, "dir")))
+	result, err = SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Deleted)
+	_, err = os.Stat(path.Join(replicaPath, "dir"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSyncTreeOwners(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owners requires root")
+	}
+	sourcePath, replicaPath := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(sourcePath, "dir"), 0755))
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, "file"), []byte("data"), 0644))
+	assert.NoError(t, os.Symlink("file", path.Join(sourcePath, "link")))
+	_, err := SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+
+	checkOwners := func(expected map[string][2]int) {
+		for name, owners := range expected {
+			info, err := os.Lstat(path.Join(replicaPath, name))
+			assert.NoError(t, err)
+			uid, gid := owner(info)
+			assert.Equal(t, owners, [2]int{uid, gid}, name)
+		}
+	}
+
+	// The owners are synced without copying the files again
+	assert.NoError(t, os.Lchown(path.Join(sourcePath, "file"), 1000, 1001))
+	assert.NoError(t, os.Lchown(path.Join(sourcePath, "link"), 1002, 1003))
+	assert.NoError(t, os.Lchown(path.Join(sourcePath, "dir"), 1004, 1005))
+	result, err := SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncResult{}, result)
+	checkOwners(map[string][2]int{"file": {1000, 1001}, "link": {1002, 1003}, "dir": {1004, 1005}})
+
+	// The copied files and the new entries keep the owners of the source
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, "file"), []byte("changed"), 0644))
+	assert.NoError(t, os.WriteFile(path.Join(sourcePath, "dir", "new"), []byte("new"), 0644))
+	assert.NoError(t, os.Lchown(path.Join(sourcePath, "dir", "new"), 1006, 1007))
+	result, err = SyncTree(sourcePath, replicaPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Copied)
+	checkOwners(map[string][2]int{"file": {1000, 1001}, "dir": {1004, 1005}, "dir/new": {1006, 1007}})
+}
+
+func TestReplicator(t *testing.T) {
+	logger := log.New("test")
+	rootPath, replicaPath := t.TempDir(), t.TempDir()
+	source := storage.NewBuiltin(logger, rootPath)
+	defer func() {
+		assert.NoError(t, source.Close())
+	}()
+	assert.NoError(t, source.CreateVolume("replicated", &apis.VolumeSpec{Replicate: "dr", Labels: map[string]string{"owner": "team-a"}}, false))
+	assert.NoError(t, source.CreateVolume("other", &apis.VolumeSpec{}, false))
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, "replicated", "_data", "file"), []byte("data"), 0644))
+
+	_, err := NewReplicator(logger, source, rootPath, map[string]*Target{"dr": {Path: rootPath}}, &storage.NameMappingOptions{})
+	assert.Error(t, err)
+	_, err = NewReplicator(logger, source, rootPath, map[string]*Target{".dr": {Path: replicaPath}}, &storage.NameMappingOptions{})
+	assert.Error(t, err)
+	replicator, err := NewReplicator(logger, source, rootPath, map[string]*Target{"dr": {Path: replicaPath}}, &storage.NameMappingOptions{})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, replicator.Close())
+	}()
+	assert.Equal(t, DefaultInterval, replicator.Targets()["dr"].Interval)
+	assert.Error(t, replicator.Run("undefined"))
+
+	// Test the sync of the marked volumes
+	assert.NoError(t, replicator.Run("dr"))
+	data, err := os.ReadFile(path.Join(replicaPath, "replicated", "_data", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	_, err = os.Stat(path.Join(replicaPath, "other"))
+	assert.True(t, os.IsNotExist(err))
+
+	metadata, err := source.FetchVolumeMetadata("replicated")
+	assert.NoError(t, err)
+	assert.NotNil(t, metadata.Status.Replication.LastSync)
+	replicas := storage.NewBuiltin(logger, replicaPath)
+	defer func() {
+		assert.NoError(t, replicas.Close())
+	}()
+	replica, err := replicas.FetchVolumeMetadata("replicated")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.Spec, replica.Spec)
+	assert.Equal(t, metadata.CreatedAt.Unix(), replica.CreatedAt.Unix())
+	assert.Equal(t, "dr", replica.Status.Replica.Target)
+
+	// Test promotion, the promoted volume is no longer synced
+	_, err = Promote(replicas, "other")
+	assert.Error(t, err)
+	syncedAt, err := Promote(replicas, "replicated")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.Status.Replication.LastSync.Unix(), syncedAt.Unix())
+	replica, err = replicas.FetchVolumeMetadata("replicated")
+	assert.NoError(t, err)
+	assert.Nil(t, replica.Status.Replica)
+	assert.Empty(t, replica.Spec.Replicate)
+	_, err = Promote(replicas, "replicated")
+	assert.Error(t, err)
+
+	assert.Error(t, replicator.Run("dr"))
+	metadata, err = source.FetchVolumeMetadata("replicated")
+	assert.NoError(t, err)
+	assert.NotNil(t, metadata.Status.Replication.LastFailure)
+	assert.Contains(t, metadata.Status.Replication.LastError, "promoted")
+
+	// Test the syncs continue after the first sync of a replica failed
+	assert.NoError(t, source.UpdateVolumeMetadata("other", func(metadata *apis.VolumeMetadata) error {
+		metadata.Spec.Replicate = "dr"
+		return nil
+	}))
+	assert.NoError(t, os.Rename(path.Join(rootPath, "other", "_data"), path.Join(rootPath, "other", "data")))
+	assert.Error(t, replicator.Run("dr"))
+	replica, err = replicas.FetchVolumeMetadata("other")
+	assert.NoError(t, err)
+	assert.Equal(t, "dr", replica.Status.Replica.Target)
+	assert.True(t, replica.Status.Replica.SyncedAt.IsZero())
+	metadata, err = source.FetchVolumeMetadata("other")
+	assert.NoError(t, err)
+	assert.Nil(t, metadata.Status.Replication.LastSync)
+	assert.NoError(t, os.Rename(path.Join(rootPath, "other", "data"), path.Join(rootPath, "other", "_data")))
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, "other", "_data", "file"), []byte("other"), 0644))
+	assert.Error(t, replicator.Run("dr"))
+	metadata, err = source.FetchVolumeMetadata("other")
+	assert.NoError(t, err)
+	assert.NotNil(t, metadata.Status.Replication.LastSync)
+	data, err = os.ReadFile(path.Join(replicaPath, "other", "_data", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(data))
+}
@@ -0,0 +1,241 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/storage"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+)
+
+const (
+	// replicationDirName is the directory in the root path which keeps the locks of the replication targets
+	replicationDirName = ".replication"
+
+	// DefaultInterval is the default interval of the syncs to a replication target
+	DefaultInterval = apis.Duration(5 * time.Minute)
+)
+
+// Target is a root path the volumes are replicated to, e.g. another mounted export, the replicas are kept there
+// as regular volumes, so the root path can be served as is once they are promoted.
+type Target struct {
+	// Path is the root path of the replicas
+	Path string `json:"path"`
+
+	// Interval of the syncs, default to 5m
+	Interval apis.Duration `json:"interval,omitempty"`
+
+	// Checksum indicates whether to compare the files whose size and modification time match by their SHA-256 too
+	Checksum bool `json:"checksum,omitempty"`
+}
+
+// replicaTarget is a replication target with the storage of its replicas.
+type replicaTarget struct {
+	opts    *Target
+	storage *storage.Builtin
+}
+
+// Replicator syncs the volumes of a storage to the replication targets they are marked with by the replicate option,
+// the nodes sharing the root path coordinate by a lock file of each target, so only one of them syncs to a target at a time.
+type Replicator struct {
+	logger   *log.Logger
+	source   *storage.Builtin
+	rootPath string
+	targets  map[string]*replicaTarget
+}
+
+// NewReplicator creates the replicator of the volumes in the storage at the root path, the replicas use the same name mapping as the volumes.
+func NewReplicator(logger *log.Logger, source *storage.Builtin, rootPath string, targets map[string]*Target, nameMapping *storage.NameMappingOptions) (*Replicator, error) {
+	replicator := &Replicator{logger: logger, source: source, rootPath: rootPath, targets: map[string]*replicaTarget{}}
+	for name, opts := range targets {
+		if err := storage.ValidateVolumeName(name); err != nil {
+			_ = replicator.Close()
+			return nil, fmt.Errorf("invalid name of replication target: %v", err)
+		}
+		if opts.Path == "" || path.Clean(opts.Path) == path.Clean(rootPath) {
+			_ = replicator.Close()
+			return nil, fmt.Errorf("replication target %s should have a path other than the root path", name)
+		}
+		if opts.Interval == 0 {
+			opts.Interval = DefaultInterval
+		}
+
+		if err := os.MkdirAll(opts.Path, 0755); err != nil {
+			_ = replicator.Close()
+			return nil, fmt.Errorf("failed to create root path of replication target %s: %v", name, err)
+		}
+		replicaStorage := storage.NewBuiltin(logger.WithService("storage").WithLogLevel(log.WarnLevel), opts.Path)
+		if err := replicaStorage.SetNameMapping(nameMapping); err != nil {
+			_ = replicaStorage.Close()
+			_ = replicator.Close()
+			return nil, fmt.Errorf("invalid name mapping: %v", err)
+		}
+		replicator.targets[name] = &replicaTarget{opts: opts, storage: replicaStorage}
+	}
+	return replicator, nil
+}
+
+// Targets returns the replication targets by name.
+func (replicator *Replicator) Targets() map[string]*Target {
+	targets := map[string]*Target{}
+	for name, target := range replicator.targets {
+		targets[name] = target.opts
+	}
+	return targets
+}
+
+// Run syncs the volumes marked with the target to it, it returns without syncing if another node is syncing to the target.
+func (replicator *Replicator) Run(targetName string) error {
+	if replicator.targets[targetName] == nil {
+		return fmt.Errorf("replication target %s is not defined", targetName)
+	}
+	if err := os.MkdirAll(path.Join(replicator.rootPath, replicationDirName), 0755); err != nil {
+		return fmt.Errorf("failed to create replication directory: %v", err)
+	}
+
+	lock := flock.New(path.Join(replicator.rootPath, replicationDirName, targetName+".lock"))
+	locked, err := lock.TryLock()
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	if !locked {
+		replicator.logger.Debugf("another node is syncing to replication target %s", targetName)
+		return nil
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			replicator.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	names := []string{}
+	err = replicator.source.WalkVolumeMetadata(func(name string, metadata *apis.VolumeMetadata) error {
+		if metadata.Spec.Replicate == targetName {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		if err := replicator.Sync(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sync syncs the volume to the replication target it is marked with and records the result in its status.
+func (replicator *Replicator) Sync(name string) error {
+	startedAt := time.Now()
+	result, err := replicator.sync(name, startedAt)
+	if err != nil {
+		err = fmt.Errorf("failed to sync volume %s: %v", name, err)
+	} else {
+		replicator.logger.Infof("synced volume %s, copied %d files of %d bytes and deleted %d entries", name, result.Copied, result.Bytes, result.Deleted)
+	}
+
+	updateErr := replicator.source.UpdateVolumeMetadata(name, func(metadata *apis.VolumeMetadata) error {
+		if metadata.Status.Replication == nil {
+			metadata.Status.Replication = &apis.ReplicationStatus{}
+		}
+		if err == nil {
+			metadata.Status.Replication.LastSync = &startedAt
+		} else {
+			metadata.Status.Replication.LastFailure = &startedAt
+			metadata.Status.Replication.LastError = err.Error()
+		}
+		return nil
+	})
+	if updateErr != nil {
+		replicator.logger.Warningf("failed to record replication status of volume %s: %v", name, updateErr)
+	}
+	return err
+}
+
+// sync creates the replica of the volume if it doesn't exist, syncs the data directory and then copies the metadata to the replica.
+func (replicator *Replicator) sync(name string, startedAt time.Time) (*SyncResult, error) {
+	metadata, err := replicator.source.FetchVolumeMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+	target := replicator.targets[metadata.Spec.Replicate]
+	if target == nil {
+		return nil, fmt.Errorf("replication target %s is not defined", metadata.Spec.Replicate)
+	}
+
+	replica, err := target.storage.FetchVolumeMetadata(name)
+	if err == nil && replica.Status.Replica == nil {
+		return nil, fmt.Errorf("the volume in replication target %s isn't a replica, it may have been promoted", metadata.Spec.Replicate)
+	}
+	if err != nil {
+		if err := target.storage.CreateVolume(name, metadata.Spec, true); err != nil {
+			return nil, fmt.Errorf("failed to create replica: %v", err)
+		}
+		// Mark the replica before copying any data, so the later syncs continue if this one fails
+		err = target.storage.UpdateVolumeMetadata(name, func(replica *apis.VolumeMetadata) error {
+			replica.Status.Replica = &apis.ReplicaStatus{Target: metadata.Spec.Replicate}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark replica: %v", err)
+		}
+		if replica, err = target.storage.FetchVolumeMetadata(name); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := SyncTree(path.Join(replicator.rootPath, metadata.Status.Mountpoint), path.Join(target.opts.Path, replica.Status.Mountpoint), target.opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	err = target.storage.UpdateVolumeMetadata(name, func(replica *apis.VolumeMetadata) error {
+		replica.CreatedAt = metadata.CreatedAt
+		replica.Spec = metadata.Spec
+		replica.Status.Replica = &apis.ReplicaStatus{Target: metadata.Spec.Replicate, SyncedAt: startedAt}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update metadata of replica: %v", err)
+	}
+	return result, nil
+}
+
+// Close closes the storages of the replicas.
+func (replicator *Replicator) Close() error {
+	errs := []error{}
+	for name, target := range replicator.targets {
+		if err := target.storage.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close storage of replication target %s: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Promote turns the replica of a volume into a regular volume, e.g. after the primary is lost, and returns when it was last synced.
+// The replicator refuses to sync to the promoted volume, so the primary can't overwrite it when it is back.
+func Promote(replicas *storage.Builtin, name string) (time.Time, error) {
+	var syncedAt time.Time
+	err := replicas.UpdateVolumeMetadata(name, func(metadata *apis.VolumeMetadata) error {
+		if metadata.Status.Replica == nil {
+			return fmt.Errorf("volume %s isn't a replica", name)
+		}
+		syncedAt = metadata.Status.Replica.SyncedAt
+		metadata.Spec.Replicate = ""
+		metadata.Status.Replica = nil
+		metadata.Status.Replication = nil
+		return nil
+	})
+	return syncedAt, err
+}
@@ -0,0 +1,280 @@
+package replication
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// SyncResult summarizes the changes made by a sync.
+type SyncResult struct {
+	// Copied is the number of the files copied to the replica
+	Copied int
+
+	// Bytes is the total size of the copied files
+	Bytes int64
+
+	// Deleted is the number of the entries deleted from the replica since they no longer exist in the source
+	Deleted int
+}
+
+// SyncTree makes the replica directory a copy of the source directory like rsync does: the files whose size or modification time differ
+// are copied, and with checksum the files whose size and modification time match are compared by their SHA-256 too. The modes and owners
+// of the entries are synced too, so the containers running as other users than root keep their access to a promoted replica. Each file is copied
+// to a temporary file which is renamed over the replica, so the replica never has a partial file, and the entries which no longer exist
+// in the source are deleted last. Other types than directories, regular files and symbolic links are skipped.
+func SyncTree(sourcePath string, replicaPath string, checksum bool) (*SyncResult, error) {
+	result := &SyncResult{}
+	synced := map[string]bool{}
+	dirModes := map[string]fs.FileMode{}
+	err := filepath.WalkDir(sourcePath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(sourcePath, filePath)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		replicaFilePath := filepath.Join(replicaPath, relativePath)
+
+		switch {
+		case info.IsDir():
+			if err := replaceNonMatchingType(replicaFilePath, fs.ModeDir); err != nil {
+				return err
+			}
+			// Keep the directory writable until all its entries are synced
+			if err := os.MkdirAll(replicaFilePath, 0755); err != nil {
+				return err
+			}
+			if err := syncOwner(replicaFilePath, info); err != nil {
+				return err
+			}
+			if err := os.Chmod(replicaFilePath, info.Mode().Perm()|0700); err != nil {
+				return err
+			}
+			dirModes[replicaFilePath] = info.Mode().Perm()
+		case info.Mode()&fs.ModeSymlink != 0:
+			if err := syncSymlink(filePath, replicaFilePath, info); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			copied, err := syncFile(filePath, replicaFilePath, info, checksum)
+			if err != nil {
+				return err
+			}
+			if copied {
+				result.Copied++
+				result.Bytes += info.Size()
+			}
+		default:
+			return nil
+		}
+		synced[relativePath] = true
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to sync %s: %v", sourcePath, err)
+	}
+
+	err = filepath.WalkDir(replicaPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(replicaPath, filePath)
+		if err != nil {
+			return err
+		}
+		if synced[relativePath] {
+			return nil
+		}
+
+		if err := os.RemoveAll(filePath); err != nil {
+			return err
+		}
+		result.Deleted++
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to delete extraneous entries of %s: %v", replicaPath, err)
+	}
+
+	for dirPath, mode := range dirModes {
+		if err := os.Chmod(dirPath, mode); err != nil {
+			return result, fmt.Errorf("failed to set mode of %s: %v", dirPath, err)
+		}
+	}
+	return result, nil
+}
+
+// syncSymlink makes the replica a symbolic link to the same target and with the same owner as the source.
+func syncSymlink(sourcePath string, replicaPath string, info fs.FileInfo) error {
+	link, err := os.Readlink(sourcePath)
+	if err != nil {
+		return err
+	}
+	if replicaLink, err := os.Readlink(replicaPath); err == nil && replicaLink == link {
+		return syncOwner(replicaPath, info)
+	}
+
+	if err := os.RemoveAll(replicaPath); err != nil {
+		return err
+	}
+	if err := os.Symlink(link, replicaPath); err != nil {
+		return err
+	}
+	return lchown(replicaPath, info)
+}
+
+// syncOwner changes the owner of the replica entry to the owner of the source unless they already match.
+func syncOwner(replicaPath string, info fs.FileInfo) error {
+	replicaInfo, err := os.Lstat(replicaPath)
+	if err != nil {
+		return err
+	}
+	if sameOwner(replicaInfo, info) {
+		return nil
+	}
+	return lchown(replicaPath, info)
+}
+
+// lchown changes the owner of the replica entry to the owner of the source.
+func lchown(replicaPath string, info fs.FileInfo) error {
+	uid, gid := owner(info)
+	return os.Lchown(replicaPath, uid, gid)
+}
+
+// owner returns the uid and gid of the file, or -1 which keeps them unchanged by chown if they are unknown.
+func owner(info fs.FileInfo) (int, int) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(stat.Uid), int(stat.Gid)
+}
+
+// sameOwner tells whether the files have the same owner.
+func sameOwner(info1 fs.FileInfo, info2 fs.FileInfo) bool {
+	uid1, gid1 := owner(info1)
+	uid2, gid2 := owner(info2)
+	return uid1 == uid2 && gid1 == gid2
+}
+
+// syncFile copies the source file to the replica unless they already match, and reports whether it was copied.
+func syncFile(sourcePath string, replicaPath string, info fs.FileInfo, checksum bool) (bool, error) {
+	replicaInfo, err := os.Lstat(replicaPath)
+	if err == nil && replicaInfo.Mode().IsRegular() && replicaInfo.Size() == info.Size() && replicaInfo.ModTime().Equal(info.ModTime()) {
+		same := true
+		if checksum {
+			same, err = sameContent(sourcePath, replicaPath)
+			if err != nil {
+				return false, err
+			}
+		}
+		if same {
+			if !sameOwner(replicaInfo, info) {
+				if err := lchown(replicaPath, info); err != nil {
+					return false, err
+				}
+			}
+			if replicaInfo.Mode().Perm() != info.Mode().Perm() {
+				return false, os.Chmod(replicaPath, info.Mode().Perm())
+			}
+			return false, nil
+		}
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	temp, err := os.CreateTemp(filepath.Dir(replicaPath), "."+filepath.Base(replicaPath)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	size, err := io.Copy(temp, source)
+	if err == nil && size != info.Size() {
+		err = fmt.Errorf("file %s was changed during the sync", sourcePath)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = lchown(temp.Name(), info)
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(temp.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = replaceNonMatchingType(replicaPath, 0)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), replicaPath)
+	}
+	return err == nil, err
+}
+
+// replaceNonMatchingType removes the replica entry if it exists with another type than the type, so it can be replaced.
+func replaceNonMatchingType(replicaPath string, fileType fs.FileMode) error {
+	info, err := os.Lstat(replicaPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() == fileType {
+		return nil
+	}
+	return os.RemoveAll(replicaPath)
+}
+
+// sameContent compares the files by their SHA-256.
+func sameContent(path1 string, path2 string) (bool, error) {
+	sum1, err := hashFile(path1)
+	if err != nil {
+		return false, err
+	}
+	sum2, err := hashFile(path2)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sum1, sum2), nil
+}
+
+func hashFile(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,77 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+func init() {
+	registerFactory("s3", s3Factory)
+}
+
+// s3 is an implementation of the Driver interface for managing volumes in an S3 compatible bucket mounted by s3fs.
+type s3 struct {
+	*remote
+	opts *s3DriverOptions
+}
+
+type s3DriverOptions struct {
+	builtinDriverOptions
+
+	// Bucket keeping the volumes
+	Bucket string `json:"bucket"`
+
+	// Prefix of the objects of the volumes in the bucket, empty means the whole bucket
+	Prefix string `json:"prefix,omitempty"`
+
+	// Endpoint is the URL of the S3 compatible service, empty means AWS
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Region signing the requests, empty means the default of s3fs
+	Region string `json:"region,omitempty"`
+
+	// CredentialsFile has the access key and secret key in the format of s3fs
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+
+	// PathStyle indicates whether to address the bucket in the path instead of the host name
+	PathStyle bool `json:"pathStyle,omitempty"`
+
+	// MountOptions for s3fs
+	MountOptions []string `json:"mountOptions,omitempty"`
+
+	// Mock indicates whether to run in mock mode (no actual s3fs mount)
+	Mock bool `json:"mock,omitempty"`
+}
+
+func s3Factory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+	opts := &s3DriverOptions{
+		MountOptions:         []string{"allow_other", "mp_umask=0022"},
+		builtinDriverOptions: defaultBuiltinDriverOptions(),
+		Mock:                 false,
+	}
+	if err := json.Unmarshal([]byte(driverOptions), opts); err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %s", err)
+	}
+
+	// Mount S3 bucket to a local mount point
+	base, err := newRemote(ctx, logger, "s3fs", propagatedMountpoint, &opts.builtinDriverOptions, opts.Mock, func() error {
+		err := utils.MountS3FS(opts.Bucket, opts.Prefix, propagatedMountpoint, opts.Endpoint, opts.Region, opts.CredentialsFile, opts.PathStyle, opts.MountOptions)
+		if err != nil {
+			return fmt.Errorf("failed to mount S3 bucket: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3{
+		remote: base,
+		opts:   opts,
+	}, nil
+}
@@ -0,0 +1,77 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+func init() {
+	registerFactory("sshfs", sshfsFactory)
+}
+
+// sshfs is an implementation of the Driver interface for managing volumes on a remote path over SSH.
+type sshfs struct {
+	*remote
+	opts *sshfsDriverOptions
+}
+
+type sshfsDriverOptions struct {
+	builtinDriverOptions
+
+	// Address of SSH server
+	Address string `json:"address"`
+
+	// Port of SSH server, zero means the default of ssh
+	Port int `json:"port,omitempty"`
+
+	// Username on SSH server
+	Username string `json:"username,omitempty"`
+
+	// RemotePath on SSH server
+	RemotePath string `json:"remotePath"`
+
+	// IdentityFile is the private key authenticating the user
+	IdentityFile string `json:"identityFile,omitempty"`
+
+	// KnownHostsFile has the key of SSH server, which is checked strictly
+	KnownHostsFile string `json:"knownHostsFile,omitempty"`
+
+	// MountOptions for SSHFS
+	MountOptions []string `json:"mountOptions,omitempty"`
+
+	// Mock indicates whether to run in mock mode (no actual SSHFS mount)
+	Mock bool `json:"mock,omitempty"`
+}
+
+func sshfsFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+	opts := &sshfsDriverOptions{
+		MountOptions:         []string{"reconnect", "ServerAliveInterval=15", "ServerAliveCountMax=3", "allow_other"},
+		builtinDriverOptions: defaultBuiltinDriverOptions(),
+		Mock:                 false,
+	}
+	if err := json.Unmarshal([]byte(driverOptions), opts); err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %s", err)
+	}
+
+	// Mount remote path to a local mount point
+	base, err := newRemote(ctx, logger, "SSHFS", propagatedMountpoint, &opts.builtinDriverOptions, opts.Mock, func() error {
+		err := utils.MountSSHFS(opts.Address, opts.Port, opts.Username, opts.RemotePath, propagatedMountpoint, opts.IdentityFile, opts.KnownHostsFile, opts.MountOptions)
+		if err != nil {
+			return fmt.Errorf("failed to mount SSHFS remote path: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshfs{
+		remote: base,
+		opts:   opts,
+	}, nil
+}
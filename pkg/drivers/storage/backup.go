@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// BackupFormatVersion is the version of the backup archive format
+	BackupFormatVersion = 1
+
+	backupManifestName = "manifest.json"
+	restoreDirName     = ".restore"
+)
+
+// BackupManifest is the last entry of a backup archive, it describes the archive and records the checksums of its files.
+type BackupManifest struct {
+	// Version of the archive format
+	Version int `json:"version"`
+
+	// Volume is the name of the backed up volume
+	Volume string `json:"volume"`
+
+	// CreatedAt is when the backup was started
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Quiesced indicates whether the metadata lock was held during the whole backup
+	Quiesced bool `json:"quiesced"`
+
+	// Files are the regular files and symbolic links in the archive
+	Files []*BackupFile `json:"files"`
+}
+
+// BackupFile is a regular file or a symbolic link in a backup archive.
+type BackupFile struct {
+	// Path in the archive
+	Path string `json:"path"`
+
+	// Size of the regular file
+	Size int64 `json:"size,omitempty"`
+
+	// SHA256 of the content of the regular file
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Link is the target of the symbolic link
+	Link string `json:"link,omitempty"`
+}
+
+// BackupVolume writes the metadata and the data directory of the volume to the archive, which is a zstd compressed tar ended by the manifest.
+// The metadata lock is held during the whole backup if quiesce is true, so the volume can't be updated or removed meanwhile,
+// otherwise it is only held while the metadata is read.
+func (s *Builtin) BackupVolume(name string, archive io.Writer, quiesce bool) (*BackupManifest, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := s.acquireMetadataLock(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	unlock := func() {
+		if lock == nil {
+			return
+		}
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+		lock = nil
+	}
+	defer unlock()
+
+	metadata, err := s.store.Fetch(dir)
+	if err != nil {
+		return nil, err
+	}
+	metadata.Name = name
+	metadataData, err := metadata.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal volume metadata: %v", err)
+	}
+	if !quiesce {
+		unlock()
+	}
+
+	manifest := &BackupManifest{
+		Version:   BackupFormatVersion,
+		Volume:    name,
+		CreatedAt: time.Now(),
+		Quiesced:  quiesce,
+		Files:     []*BackupFile{},
+	}
+	encoder, err := zstd.NewWriter(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+	err = s.writeBackupEntries(tar.NewWriter(encoder), manifest, dir, metadataData)
+	if closeErr := encoder.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write backup archive: %v", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// writeBackupEntries writes the metadata, the data directory and the manifest to the archive.
+func (s *Builtin) writeBackupEntries(writer *tar.Writer, manifest *BackupManifest, dir string, metadataData []byte) error {
+	err := writeBackupFile(writer, manifest, metadataFileName, metadataData)
+	if err != nil {
+		return err
+	}
+
+	dataDirPath := s.getDataDirPath(dir)
+	err = filepath.WalkDir(dataDirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(dataDirPath, filePath)
+		if err != nil {
+			return err
+		}
+		return s.backupEntry(writer, manifest, path.Join(s.dataDirName, filepath.ToSlash(relativePath)), filePath, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to back up data of volume %s: %v", manifest.Volume, err)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest: %v", err)
+	}
+	err = writer.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0644, Size: int64(len(manifestData)), ModTime: manifest.CreatedAt})
+	if err == nil {
+		_, err = writer.Write(manifestData)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write backup archive: %v", err)
+	}
+	return nil
+}
+
+// backupEntry writes an entry of the data directory to the archive, the entries other than directories, regular files and symbolic links are skipped.
+func (s *Builtin) backupEntry(writer *tar.Writer, manifest *BackupManifest, name string, filePath string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{Name: name, Mode: int64(info.Mode().Perm()), ModTime: info.ModTime()}
+	switch {
+	case info.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		return writer.WriteHeader(header)
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(filePath)
+		if err != nil {
+			return err
+		}
+		header.Typeflag, header.Linkname = tar.TypeSymlink, link
+		manifest.Files = append(manifest.Files, &BackupFile{Path: name, Link: link})
+		return writer.WriteHeader(header)
+	case info.Mode().IsRegular():
+	default:
+		s.logger.Warningf("skipping %s of type %s in backup", name, info.Mode().Type())
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	header.Typeflag, header.Size = tar.TypeReg, info.Size()
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(writer, hash), io.LimitReader(file, info.Size()))
+	if err != nil {
+		return err
+	}
+	if size != info.Size() {
+		return fmt.Errorf("file %s was truncated during the backup", name)
+	}
+	manifest.Files = append(manifest.Files, &BackupFile{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	return nil
+}
+
+// writeBackupFile writes a regular file with the data to the archive.
+func writeBackupFile(writer *tar.Writer, manifest *BackupManifest, name string, data []byte) error {
+	err := writer.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()})
+	if err == nil {
+		_, err = writer.Write(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s to backup archive: %v", name, err)
+	}
+
+	sum := sha256.Sum256(data)
+	manifest.Files = append(manifest.Files, &BackupFile{Path: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+	return nil
+}
+
+// RestoreVolumeFromBackup creates the volume with the metadata and data in the backup archive, the archive is extracted aside and verified
+// against its manifest before it is moved in place, so a broken archive leaves nothing behind.
+func (s *Builtin) RestoreVolumeFromBackup(name string, archive io.Reader) (*BackupManifest, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaseCollision(name, dir); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(path.Join(s.rootPath, dir)); err == nil {
+		return nil, fmt.Errorf("volume %s already exists", name)
+	}
+
+	err = os.MkdirAll(path.Join(s.rootPath, restoreDirName), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %v", err)
+	}
+	stagingPath, err := os.MkdirTemp(path.Join(s.rootPath, restoreDirName), dir+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(stagingPath)
+	}()
+
+	manifest, err := extractBackup(archive, stagingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract backup archive: %v", err)
+	}
+
+	metadata, err := parseMetadataFile(path.Join(stagingPath, metadataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata in backup archive: %v", err)
+	}
+	err = os.Remove(path.Join(stagingPath, metadataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove metadata file of backup archive: %v", err)
+	}
+
+	// The staging directory is created with 0700, the volume directories are 0755 like the created ones
+	err = os.Chmod(stagingPath, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set mode of restored volume %s: %v", name, err)
+	}
+
+	err = os.Rename(stagingPath, path.Join(s.rootPath, dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to move restored volume %s in place: %v", name, err)
+	}
+
+	lock, err := s.acquireMetadataLock(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	metadata.Name = name
+	metadata.Status.Mountpoint = s.getMountpointPath(dir)
+	metadata.Status.TrashedAt = nil
+	metadata.Status.PurgeAt = nil
+	return manifest, s.store.Create(dir, metadata)
+}
+
+// extractBackup extracts the archive into the directory and verifies the extracted files against the manifest. The symbolic links are created
+// after all files, so no file is written through them, and the modes of the directories are set last, so the read-only ones can be filled.
+func extractBackup(archive io.Reader, dirPath string) (*BackupManifest, error) {
+	decoder, err := zstd.NewReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	reader := tar.NewReader(decoder)
+	extracted := map[string]*BackupFile{}
+	links := []*BackupFile{}
+	dirModes := map[string]fs.FileMode{}
+	var manifest *BackupManifest
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			return nil, fmt.Errorf("unexpected entry %s after the manifest", header.Name)
+		}
+
+		if header.Name == backupManifestName {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(reader).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("failed to parse manifest: %v", err)
+			}
+			continue
+		}
+
+		name := strings.TrimSuffix(header.Name, "/")
+		if name != metadataFileName && name != "_data" && !strings.HasPrefix(name, "_data/") || path.Clean(name) != name {
+			return nil, fmt.Errorf("invalid entry %s", header.Name)
+		}
+		if _, existed := extracted[name]; existed {
+			return nil, fmt.Errorf("duplicate entry %s", name)
+		}
+		filePath := path.Join(dirPath, name)
+		mode := fs.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return nil, err
+			}
+			dirModes[filePath] = mode
+			extracted[name] = nil
+		case tar.TypeSymlink:
+			link := &BackupFile{Path: name, Link: header.Linkname}
+			extracted[name] = link
+			links = append(links, link)
+		case tar.TypeReg:
+			file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+			if err != nil {
+				return nil, err
+			}
+			hash := sha256.New()
+			size, err := io.Copy(io.MultiWriter(file, hash), reader)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+			extracted[name] = &BackupFile{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+		default:
+			return nil, fmt.Errorf("unsupported type of entry %s", header.Name)
+		}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("manifest is missing, the archive may be truncated")
+	}
+	if manifest.Version > BackupFormatVersion {
+		return nil, fmt.Errorf("archive format version %d is newer than the supported version %d", manifest.Version, BackupFormatVersion)
+	}
+	if err := verifyBackupFiles(manifest, extracted); err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		if err := os.Symlink(link.Link, path.Join(dirPath, link.Path)); err != nil {
+			return nil, err
+		}
+	}
+	for dirPath, mode := range dirModes {
+		if err := os.Chmod(dirPath, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// VerifyVolume re-hashes the files in the data directory of the volume and checks they match the manifest of its backup,
+// so the data written by a restore is read back from the disk, e.g. to verify a migrated volume before the source is trashed.
+func (s *Builtin) VerifyVolume(name string, manifest *BackupManifest) error {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return err
+	}
+
+	dataDirPath := s.getDataDirPath(dir)
+	// The files are hashed as they are backed up, the archive is discarded
+	writer := tar.NewWriter(io.Discard)
+	actual := &BackupManifest{Files: []*BackupFile{}}
+	err = filepath.WalkDir(dataDirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(dataDirPath, filePath)
+		if err != nil {
+			return err
+		}
+		return s.backupEntry(writer, actual, path.Join(s.dataDirName, filepath.ToSlash(relativePath)), filePath, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read data of volume %s: %v", name, err)
+	}
+
+	expected := &BackupManifest{Files: []*BackupFile{}}
+	for _, file := range manifest.Files {
+		if file.Path != metadataFileName {
+			expected.Files = append(expected.Files, file)
+		}
+	}
+	extracted := map[string]*BackupFile{}
+	for _, file := range actual.Files {
+		extracted[file.Path] = file
+	}
+	return verifyBackupFiles(expected, extracted)
+}
+
+// verifyBackupFiles checks the extracted files match the manifest, the directories are nil in the extracted files.
+func verifyBackupFiles(manifest *BackupManifest, extracted map[string]*BackupFile) error {
+	count := 0
+	for _, file := range extracted {
+		if file != nil {
+			count++
+		}
+	}
+	if count != len(manifest.Files) {
+		return fmt.Errorf("archive has %d files but the manifest records %d", count, len(manifest.Files))
+	}
+
+	for _, expected := range manifest.Files {
+		file := extracted[expected.Path]
+		if file == nil {
+			return fmt.Errorf("file %s is missing", expected.Path)
+		}
+		if *file != *expected {
+			return fmt.Errorf("file %s doesn't match its checksum in the manifest", expected.Path)
+		}
+	}
+	return nil
+}
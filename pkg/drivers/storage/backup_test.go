@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupVolume(t *testing.T) {
+	s := NewBuiltin(log.New("test"), t.TempDir())
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{Quota: 1 << 30}, false))
+	dataDirPath := s.getDataDirPath("test")
+	assert.NoError(t, os.MkdirAll(path.Join(dataDirPath, "dir"), 0755))
+	assert.NoError(t, os.WriteFile(path.Join(dataDirPath, "dir", "file"), []byte("data"), 0600))
+	assert.NoError(t, os.Symlink("dir/file", path.Join(dataDirPath, "link")))
+	assert.NoError(t, os.Chmod(path.Join(dataDirPath, "dir"), 0555))
+
+	// Test BackupVolume
+	archive := &bytes.Buffer{}
+	manifest, err := s.BackupVolume("test", archive, true)
+	assert.NoError(t, err)
+	assert.Equal(t, BackupFormatVersion, manifest.Version)
+	assert.True(t, manifest.Quiesced)
+	assert.Len(t, manifest.Files, 3)
+
+	// Test the restored volume has the spec and data of the backup
+	_, err = s.RestoreVolumeFromBackup("test", bytes.NewReader(archive.Bytes()))
+	assert.Error(t, err)
+	_, err = s.RestoreVolumeFromBackup("restored", bytes.NewReader(archive.Bytes()))
+	assert.NoError(t, err)
+	metadata, err := s.FetchVolumeMetadata("restored")
+	assert.NoError(t, err)
+	assert.Equal(t, "restored", metadata.Name)
+	assert.Equal(t, "restored/_data", metadata.Status.Mountpoint)
+	assert.Equal(t, apis.Size(1<<30), metadata.Spec.Quota)
+	info, err := os.Stat(path.Join(s.rootPath, "restored"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	restoredPath := s.getDataDirPath("restored")
+	data, err := os.ReadFile(path.Join(restoredPath, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	info, err = os.Stat(path.Join(restoredPath, "dir"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0555), info.Mode().Perm())
+	info, err = os.Stat(path.Join(restoredPath, "dir", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoError(t, os.Chmod(path.Join(dataDirPath, "dir"), 0755))
+	assert.NoError(t, os.Chmod(path.Join(restoredPath, "dir"), 0755))
+
+	// Test the truncated archive is rejected and leaves nothing behind
+	_, err = s.RestoreVolumeFromBackup("truncated", bytes.NewReader(archive.Bytes()[:archive.Len()/2]))
+	assert.Error(t, err)
+	assert.NoDirExists(t, path.Join(s.rootPath, "truncated"))
+	entries, err := os.ReadDir(path.Join(s.rootPath, restoreDirName))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRestoreVolumeFromInvalidBackup(t *testing.T) {
+	testCases := []struct {
+		description string
+		entries     []*tar.Header
+	}{
+		{
+			description: "path traversal",
+			entries:     []*tar.Header{{Name: "_data/../../escaped", Typeflag: tar.TypeReg, Mode: 0644}},
+		},
+		{
+			description: "unexpected entry",
+			entries:     []*tar.Header{{Name: "unexpected", Typeflag: tar.TypeReg, Mode: 0644}},
+		},
+		{
+			description: "missing manifest",
+			entries:     []*tar.Header{{Name: "_data/", Typeflag: tar.TypeDir, Mode: 0755}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			s := NewBuiltin(log.New("test"), t.TempDir())
+			defer func() {
+				assert.NoError(t, s.Close())
+			}()
+
+			archive := &bytes.Buffer{}
+			encoder, err := zstd.NewWriter(archive)
+			assert.NoError(t, err)
+			writer := tar.NewWriter(encoder)
+			for _, header := range testCase.entries {
+				assert.NoError(t, writer.WriteHeader(header))
+			}
+			assert.NoError(t, writer.Close())
+			assert.NoError(t, encoder.Close())
+
+			_, err = s.RestoreVolumeFromBackup("test", archive)
+			assert.Error(t, err)
+			assert.NoDirExists(t, path.Join(s.rootPath, "test"))
+		})
+	}
+}
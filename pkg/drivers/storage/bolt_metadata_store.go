@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltDatabaseName       = ".metadata.db"
+	boltBucketName         = "volumes"
+	defaultBoltLockTimeout = 30 * time.Second
+)
+
+// boltMetadataStore stores the metadata of all volumes in an embedded bolt database.
+// The database is opened for each operation only, so the plugins on different nodes can share the database file on the share,
+// the file lock of the database serializes their writes.
+type boltMetadataStore struct {
+	logger  *log.Logger
+	dbPath  string
+	timeout time.Duration
+}
+
+// NewBoltMetadataStore creates a metadata store which keeps the metadata in the bolt database file, default to .metadata.db in the root path.
+func NewBoltMetadataStore(logger *log.Logger, dbPath string, rootPath string, timeout apis.Duration) (MetadataStore, error) {
+	if dbPath == "" {
+		dbPath = path.Join(rootPath, boltDatabaseName)
+	}
+	if timeout == 0 {
+		timeout = apis.Duration(defaultBoltLockTimeout)
+	}
+
+	store := &boltMetadataStore{
+		logger:  logger,
+		dbPath:  dbPath,
+		timeout: time.Duration(timeout),
+	}
+
+	// Make sure the database can be created before serving
+	err := store.update(func(bucket *bolt.Bucket) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (store *boltMetadataStore) Create(name string, metadata *apis.VolumeMetadata) error {
+	data, err := metadata.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal volume metadata: %v", err)
+	}
+
+	return store.update(func(bucket *bolt.Bucket) error {
+		if bucket.Get([]byte(name)) != nil {
+			return ErrVolumeExists
+		}
+		return bucket.Put([]byte(name), data)
+	})
+}
+
+func (store *boltMetadataStore) Fetch(name string) (*apis.VolumeMetadata, error) {
+	var metadata *apis.VolumeMetadata
+	err := store.view(func(bucket *bolt.Bucket) error {
+		data := bucket.Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		metadata, err = parseMetadata(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("metadata of volume %s: %w", name, fs.ErrNotExist)
+	}
+
+	return metadata, nil
+}
+
+func (store *boltMetadataStore) List() (map[string]*apis.VolumeMetadata, error) {
+	return listMetadata(store)
+}
+
+func (store *boltMetadataStore) Walk(fn func(name string, metadata *apis.VolumeMetadata) error) error {
+	return store.view(func(bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(key, data []byte) error {
+			metadata, err := parseMetadata(data)
+			if err != nil {
+				store.logger.Warningf("failed to get metadata for volume %s: %v", key, err)
+				return nil
+			}
+
+			return fn(string(key), metadata)
+		})
+	})
+}
+
+func (store *boltMetadataStore) Update(name string, update func(metadata *apis.VolumeMetadata) error) error {
+	return store.update(func(bucket *bolt.Bucket) error {
+		data := bucket.Get([]byte(name))
+		if data == nil {
+			return fmt.Errorf("metadata of volume %s: %w", name, fs.ErrNotExist)
+		}
+
+		metadata, err := parseMetadata(data)
+		if err != nil {
+			return err
+		}
+
+		err = update(metadata)
+		if err != nil {
+			return err
+		}
+
+		data, err = metadata.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to marshal volume metadata: %v", err)
+		}
+		return bucket.Put([]byte(name), data)
+	})
+}
+
+func (store *boltMetadataStore) Delete(name string) error {
+	return store.update(func(bucket *bolt.Bucket) error {
+		if bucket.Get([]byte(name)) == nil {
+			return fmt.Errorf("metadata of volume %s: %w", name, fs.ErrNotExist)
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (store *boltMetadataStore) Migrate(name string, dryRun bool) (int, error) {
+	version := 0
+	migrate := func(bucket *bolt.Bucket) error {
+		data := bucket.Get([]byte(name))
+		if data == nil {
+			return fmt.Errorf("metadata of volume %s: %w", name, fs.ErrNotExist)
+		}
+
+		var err error
+		_, version, err = apis.MigrateVolumeMetadata(data)
+		if err != nil || dryRun || version == apis.CurrentSchemaVersion {
+			return err
+		}
+
+		metadata, err := parseMetadata(data)
+		if err != nil {
+			return err
+		}
+		data, err = metadata.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to marshal volume metadata: %v", err)
+		}
+		return bucket.Put([]byte(name), data)
+	}
+
+	if dryRun {
+		err := store.view(migrate)
+		return version, err
+	}
+	err := store.update(migrate)
+	return version, err
+}
+
+func (store *boltMetadataStore) Close() error {
+	// Do nothing, the database is only opened during the operations
+	return nil
+}
+
+// stamp of the database file is shared by all volumes, since the database is written in place.
+func (store *boltMetadataStore) stamp(name string) (string, error) {
+	return fileStamp(store.dbPath)
+}
+
+// view runs the function in a read-only transaction, the function is not called if the database or bucket doesn't exist yet.
+func (store *boltMetadataStore) view(fn func(bucket *bolt.Bucket) error) error {
+	if _, err := os.Stat(store.dbPath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	db, err := bolt.Open(store.dbPath, 0644, &bolt.Options{Timeout: store.timeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open metadata database: %v", err)
+	}
+	defer store.closeDatabase(db)
+
+	return db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(boltBucketName))
+		if bucket == nil {
+			return nil
+		}
+		return fn(bucket)
+	})
+}
+
+// update runs the function in a read-write transaction, the database and bucket are created if they don't exist.
+func (store *boltMetadataStore) update(fn func(bucket *bolt.Bucket) error) error {
+	db, err := bolt.Open(store.dbPath, 0644, &bolt.Options{Timeout: store.timeout})
+	if err != nil {
+		return fmt.Errorf("failed to open metadata database: %v", err)
+	}
+	defer store.closeDatabase(db)
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(boltBucketName))
+		if err != nil {
+			return fmt.Errorf("failed to create bucket: %v", err)
+		}
+		return fn(bucket)
+	})
+}
+
+func (store *boltMetadataStore) closeDatabase(db *bolt.DB) {
+	if err := db.Close(); err != nil {
+		store.logger.Errorf("failed to close metadata database: %v", err)
+	}
+}
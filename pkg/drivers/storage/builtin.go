@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,48 +16,82 @@ import (
 	"github.com/zouy414/docker-volume-plugin/pkg/log"
 )
 
+const (
+	namespaceMarkerName = ".namespace"
+	dataDirName         = "_data"
+)
+
+// Builtin manages the volumes under a root path, each volume has a directory holding its data directory and metadata lock,
+// and its metadata is kept by a MetadataStore keyed by the name of the volume directory, which is mapped from the volume name.
 type Builtin struct {
-	logger           *log.Logger
-	rootPath         string
-	dataDirName      string
-	metadataFileName string
-	metadataLockName string
-	waitGroup        sync.WaitGroup
+	logger      *log.Logger
+	rootPath    string
+	dataDirName string
+	store       MetadataStore
+	names       *nameMapper
+	waitGroup   sync.WaitGroup
 }
 
 // New creates a new instance of the Storage struct with the provided logger and path.
 func NewBuiltin(logger *log.Logger, rootPath string) *Builtin {
+	return NewBuiltinWithMetadataStore(logger, rootPath, NewFileMetadataStore(logger, rootPath, defaultListConcurrency))
+}
+
+// NewBuiltinWithMetadataStore creates a new instance of the Storage struct which keeps the metadata in the provided store.
+func NewBuiltinWithMetadataStore(logger *log.Logger, rootPath string, store MetadataStore) *Builtin {
 	return &Builtin{
-		logger:           logger,
-		rootPath:         rootPath,
-		dataDirName:      "_data",
-		metadataFileName: "_metadata.json",
-		metadataLockName: "_metadata.json.lock",
-		waitGroup:        sync.WaitGroup{},
+		logger:      logger,
+		rootPath:    rootPath,
+		dataDirName: dataDirName,
+		store:       store,
+		names:       &nameMapper{mode: NameMappingNone},
+		waitGroup:   sync.WaitGroup{},
+	}
+}
+
+// SetNameMapping changes how the volume names are mapped to the volume directories, it must be called before any volume is accessed.
+func (s *Builtin) SetNameMapping(opts *NameMappingOptions) error {
+	names, err := newNameMapper(opts)
+	if err != nil {
+		return err
 	}
+	s.names = names
+	return nil
 }
 
-// CreateVolume creates a volume entry
-func (s *Builtin) CreateVolume(name string, spec *apis.VolumeSpec) error {
+// CreateVolume creates a volume entry, the orphaned data left by a previously deleted volume with the same name is only reused when adopt is true
+func (s *Builtin) CreateVolume(name string, spec *apis.VolumeSpec, adopt bool) error {
 	s.waitGroup.Add(1)
 	defer s.waitGroup.Done()
 
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCaseCollision(name, dir); err != nil {
+		return err
+	}
+	if s.isNamespace(dir) {
+		return fmt.Errorf("volume %s conflicts with the namespace of the same name", name)
+	}
+
 	metadata := &apis.VolumeMetadata{
+		Name:      name,
 		CreatedAt: time.Now(),
 		Spec:      spec,
 		Status: &apis.VolumeStatus{
-			Mountpoint: s.getMountpointPath(name),
+			Mountpoint: s.getMountpointPath(dir),
 		},
 	}
 
 	// Create the volume directory if it doesn't exist
-	err := os.MkdirAll(s.getDataDirPath(name), 0755)
+	err = os.MkdirAll(path.Join(s.rootPath, dir), 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create volume directory: %v", err)
 	}
 
 	// Acquire a lock on the metadata file to prevent concurrent modifications
-	lock, err := s.acquireMetadataLock(name)
+	lock, err := s.acquireMetadataLock(dir)
 	if err != nil {
 		return fmt.Errorf("failed to acquire lock: %v", err)
 	}
@@ -63,59 +101,119 @@ func (s *Builtin) CreateVolume(name string, spec *apis.VolumeSpec) error {
 		}
 	}()
 
-	// Check if the metadata file already exists, which indicates that the volume already exists
-	if _, err := os.Stat(s.getMetadataFilePath(name)); err == nil {
+	// Check if the metadata already exists, which indicates that the volume already exists
+	if _, err := s.store.Fetch(dir); err == nil {
 		s.logger.Warningf("volume %s already exists, skipping creation", name)
 		return nil
 	}
 
-	// Marshal the volume metadata to JSON format and write it to the metadata file
-	data, err := metadata.Marshal()
-	if err != nil {
-		return fmt.Errorf("failed to marshal volume metadata: %v", err)
+	// Check if the data directory already exists, which indicates that the data is orphaned by a deleted volume
+	if _, err := os.Stat(s.getDataDirPath(dir)); err == nil {
+		if !adopt {
+			return fmt.Errorf("volume %s has orphaned data, create it with adopt=true to reuse the data", name)
+		}
+		s.logger.Warningf("adopting orphaned data of volume %s", name)
+	} else {
+		err = os.Mkdir(s.getDataDirPath(dir), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create volume data directory: %v", err)
+		}
+	}
+
+	err = s.store.Create(dir, metadata)
+	if err == ErrVolumeExists {
+		s.logger.Warningf("volume %s already exists, skipping creation", name)
+		return nil
 	}
-	return os.WriteFile(s.getMetadataFilePath(name), data, 0644)
+	return err
 }
 
 // FetchVolumeMetadata retrieves the volume metadata for the specified volume name
 func (s *Builtin) FetchVolumeMetadata(name string) (*apis.VolumeMetadata, error) {
-	data, err := os.ReadFile(s.getMetadataFilePath(name))
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Fetch(dir)
+}
+
+// UpdateVolumeMetadata applies the update to the volume metadata for the specified volume name and writes it back while holding the metadata lock
+func (s *Builtin) UpdateVolumeMetadata(name string, update func(metadata *apis.VolumeMetadata) error) error {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return err
+	}
+
+	lock, err := s.acquireMetadataLock(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read metadata file: %v", err)
+		return fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	return s.store.Update(dir, update)
+}
+
+// UpdateVolumeSpec merges the options into the spec of the volume while holding the metadata lock, the options which can't be changed are rejected
+func (s *Builtin) UpdateVolumeSpec(name string, options map[string]string) error {
+	return s.UpdateVolumeMetadata(name, func(metadata *apis.VolumeMetadata) error {
+		return metadata.Spec.Update(options)
+	})
+}
+
+// MigrateVolumeMetadata rewrites the metadata in the specified volume directory in the current schema version and returns the schema version it was stored in,
+// nothing is written in dry run mode or if it is already in the current schema version. It takes the directory name, since the volume name
+// of unknown schema versions can't be read. The directories without metadata are left untouched.
+func (s *Builtin) MigrateVolumeMetadata(dir string, dryRun bool) (int, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	// Don't leave a metadata lock in the directories which aren't volumes
+	if _, err := s.store.Fetch(dir); errors.Is(err, fs.ErrNotExist) {
+		return 0, err
 	}
 
-	metadata := &apis.VolumeMetadata{}
-	err = metadata.Unmarshal(data)
+	lock, err := s.acquireMetadataLock(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal volume metadata: %v", err)
+		return 0, fmt.Errorf("failed to acquire lock: %v", err)
 	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
 
-	return metadata, nil
+	return s.store.Migrate(dir, dryRun)
 }
 
 // ListVolumeMetadataMap retrieves a map of all volume metadata entries, where the keys are the volume names and the values are the corresponding volume metadata.
 func (s *Builtin) ListVolumeMetadata() (map[string]*apis.VolumeMetadata, error) {
-	volumeMetadataMap := make(map[string]*apis.VolumeMetadata)
-	entries, err := os.ReadDir(s.rootPath)
+	volumeMetadataMap, err := s.store.List()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read root directory: %v", err)
+		return nil, err
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		metadata, err := s.FetchVolumeMetadata(entry.Name())
-		if err != nil {
-			s.logger.Warningf("failed to get metadata for volume %s: %v", entry.Name(), err)
-			continue
-		}
-
-		volumeMetadataMap[entry.Name()] = metadata
+	if s.names.mode == NameMappingNone {
+		return volumeMetadataMap, nil
+	}
+	mapped := make(map[string]*apis.VolumeMetadata, len(volumeMetadataMap))
+	for dir, metadata := range volumeMetadataMap {
+		mapped[volumeName(dir, metadata)] = metadata
 	}
+	return mapped, nil
+}
 
-	return volumeMetadataMap, nil
+// WalkVolumeMetadata calls the function with the metadata of each volume as it is read, in no particular order, and stops at the first error returned by the function.
+func (s *Builtin) WalkVolumeMetadata(fn func(name string, metadata *apis.VolumeMetadata) error) error {
+	return s.store.Walk(func(dir string, metadata *apis.VolumeMetadata) error {
+		return fn(volumeName(dir, metadata), metadata)
+	})
 }
 
 // DeleteVolumeMetadata deletes the volume metadata for the specified volume name
@@ -123,7 +221,12 @@ func (s *Builtin) DeleteVolumeMetadata(name string) error {
 	s.waitGroup.Add(1)
 	defer s.waitGroup.Done()
 
-	lock, err := s.acquireMetadataLock(name)
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return err
+	}
+
+	lock, err := s.acquireMetadataLock(dir)
 	if err != nil {
 		return fmt.Errorf("failed to acquire lock: %v", err)
 	}
@@ -133,7 +236,18 @@ func (s *Builtin) DeleteVolumeMetadata(name string) error {
 		}
 	}()
 
-	return os.Remove(s.getMetadataFilePath(name))
+	err = s.store.Delete(dir)
+	if err != nil {
+		return err
+	}
+
+	// Touch the volume directory to record when the data is orphaned
+	now := time.Now()
+	err = os.Chtimes(path.Join(s.rootPath, dir), now, now)
+	if err != nil {
+		s.logger.Warningf("failed to touch volume directory %s: %v", name, err)
+	}
+	return nil
 }
 
 // DeleteVolume deletes the volume and its metadata for the specified volume name
@@ -141,35 +255,144 @@ func (s *Builtin) DeleteVolume(name string) error {
 	s.waitGroup.Add(1)
 	defer s.waitGroup.Done()
 
-	return os.RemoveAll(path.Join(s.rootPath, name))
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path.Join(s.rootPath, dir))
+}
+
+// RemoveVolume removes the volume according to its spec, the data is left as orphan unless purgeAfterDelete is set, and the purged data is moved into the trash
+// when the trash retention of the volume, or the default one if the volume doesn't specify, is not zero
+func (s *Builtin) RemoveVolume(name string, defaultTrashRetention time.Duration) error {
+	metadata, err := s.FetchVolumeMetadata(name)
+	if err != nil {
+		return fmt.Errorf("failed to get volume metadata: %v", err)
+	}
+
+	if !metadata.Spec.PurgeAfterDelete {
+		err = s.DeleteVolumeMetadata(name)
+		if err != nil {
+			return fmt.Errorf("failed to delete volume metadata: %v", err)
+		}
+		return nil
+	}
+
+	retention := defaultTrashRetention
+	if metadata.Spec.TrashRetention != nil {
+		retention = time.Duration(*metadata.Spec.TrashRetention)
+	}
+	if retention != 0 {
+		entry, err := s.TrashVolume(name, retention)
+		if err != nil {
+			return fmt.Errorf("failed to move volume into trash: %v", err)
+		}
+		s.logger.Infof("volume %s moved into trash entry %s for %s", name, entry, retention)
+		return nil
+	}
+
+	err = s.DeleteVolumeMetadata(name)
+	if err != nil {
+		return fmt.Errorf("failed to delete volume metadata: %v", err)
+	}
+
+	err = s.DeleteVolume(name)
+	if err != nil {
+		return fmt.Errorf("failed to delete volume data: %v", err)
+	}
+
+	return nil
 }
 
 // Close releases any resources held by the DB instance, such as the file lock. It should be called when the DB instance is no longer needed to ensure proper cleanup.
 func (s *Builtin) Close() error {
 	s.waitGroup.Wait()
 
-	// Do nothing
+	return s.store.Close()
+}
+
+// MarkNamespace marks the directory as a namespace, whose entries are skipped by the storage of the parent directory.
+// The directory of a volume of the parent directory is refused, since the volume would be hidden.
+func MarkNamespace(namespacePath string) error {
+	for _, name := range []string{metadataFileName, dataDirName} {
+		if _, err := os.Lstat(path.Join(namespacePath, name)); err == nil {
+			return fmt.Errorf("directory %s is a volume, it can't be a namespace", namespacePath)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check directory %s: %v", namespacePath, err)
+		}
+	}
+
+	err := os.WriteFile(path.Join(namespacePath, namespaceMarkerName), []byte{}, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write namespace marker: %v", err)
+	}
 	return nil
 }
 
-func (s *Builtin) getMountpointPath(name string) string {
-	return path.Join(name, s.dataDirName)
+// ListVolumeDirs lists the volume directories of the root directory in order, the namespace directories are not included.
+func (s *Builtin) ListVolumeDirs() ([]string, error) {
+	return s.listDirs(func(name string) bool { return !s.isNamespace(name) })
+}
+
+// ListNamespaces lists the namespace directories of the root directory in order.
+func (s *Builtin) ListNamespaces() ([]string, error) {
+	return s.listDirs(s.isNamespace)
+}
+
+// listDirs lists the directories of the root directory accepted by the filter in order, the internal entries are skipped.
+func (s *Builtin) listDirs(filter func(name string) bool) ([]string, error) {
+	entries, err := os.ReadDir(s.rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %v", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !isInternalEntry(entry.Name()) && filter(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isNamespace reports whether an entry of the root directory is the directory of a namespace.
+func (s *Builtin) isNamespace(name string) bool {
+	_, err := os.Stat(path.Join(s.rootPath, name, namespaceMarkerName))
+	return err == nil
+}
+
+// isInternalEntry reports whether an entry of the root directory is used by the storage itself, the volume names never start with a dot.
+func isInternalEntry(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// volumeName returns the original name of the volume in the directory, the metadata written before the names were recorded falls back to the directory name.
+func volumeName(dir string, metadata *apis.VolumeMetadata) string {
+	if metadata.Name != "" {
+		return metadata.Name
+	}
+	return dir
+}
+
+func (s *Builtin) getMountpointPath(dir string) string {
+	return path.Join(dir, s.dataDirName)
 }
 
-func (s *Builtin) getDataDirPath(name string) string {
-	return path.Join(s.rootPath, s.getMountpointPath(name))
+func (s *Builtin) getDataDirPath(dir string) string {
+	return path.Join(s.rootPath, s.getMountpointPath(dir))
 }
 
-func (s *Builtin) getMetadataFilePath(name string) string {
-	return path.Join(s.rootPath, name, s.metadataFileName)
+func (s *Builtin) getMetadataFilePath(dir string) string {
+	return path.Join(s.rootPath, dir, metadataFileName)
 }
 
-func (s *Builtin) acquireMetadataLock(name string) (*flock.Flock, error) {
-	lock := flock.New(path.Join(s.rootPath, name, s.metadataLockName))
+func (s *Builtin) acquireMetadataLock(dir string) (*flock.Flock, error) {
+	lock := flock.New(path.Join(s.rootPath, dir, metadataLockName))
 
 	err := lock.Lock()
 	if err != nil {
-		return nil, fmt.Errorf("failed to acquire metadata lock: %v", err)
+		return nil, fmt.Errorf("failed to acquire metadata lock: %w", err)
 	}
 
 	return lock, nil
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataBackup(t *testing.T) {
+	rootPath := t.TempDir()
+	s := NewBuiltin(log.New("test"), rootPath)
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{}, false))
+	assert.NoFileExists(t, s.getMetadataFilePath("test")+metadataBackupSuffix)
+
+	// Test update keeps the previous version as backup
+	err := s.UpdateVolumeMetadata("test", func(metadata *apis.VolumeMetadata) error {
+		metadata.Spec.PurgeAfterDelete = true
+		return nil
+	})
+	assert.NoError(t, err)
+	backup, err := parseMetadataFile(s.getMetadataFilePath("test") + metadataBackupSuffix)
+	assert.NoError(t, err)
+	assert.False(t, backup.Spec.PurgeAfterDelete)
+
+	// Test no temporary file is left
+	entries, err := os.ReadDir(path.Join(rootPath, "test"))
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), ".tmp")
+	}
+
+	// Test fallback to backup when the checksum mismatches
+	data, err := os.ReadFile(s.getMetadataFilePath("test"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("test"), bytes.Replace(data, []byte(`"purgeAfterDelete":true`), []byte(`"purgeAfterDelete":false`), 1), 0644))
+	metadata, err := s.FetchVolumeMetadata("test")
+	assert.NoError(t, err)
+	assert.False(t, metadata.Spec.PurgeAfterDelete)
+
+	// Test fallback to backup when the metadata is truncated
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("test"), data[:len(data)/2], 0644))
+	_, err = s.FetchVolumeMetadata("test")
+	assert.NoError(t, err)
+
+	// Test no fallback when both are broken
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("test")+metadataBackupSuffix, data[:len(data)/2], 0644))
+	_, err = s.FetchVolumeMetadata("test")
+	assert.Error(t, err)
+
+	// Test no fallback when the metadata is deleted
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("test"), data, 0644))
+	assert.NoError(t, s.UpdateVolumeMetadata("test", func(metadata *apis.VolumeMetadata) error { return nil }))
+	assert.NoError(t, s.DeleteVolumeMetadata("test"))
+	assert.NoFileExists(t, s.getMetadataFilePath("test")+metadataBackupSuffix)
+	_, err = s.FetchVolumeMetadata("test")
+	assert.Error(t, err)
+}
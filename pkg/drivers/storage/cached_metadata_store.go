@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+// metadataStamper is implemented by the stores which can tell cheaply whether the stored metadata of a volume has changed,
+// the stamp changes whenever the metadata is written by any node.
+type metadataStamper interface {
+	stamp(name string) (string, error)
+}
+
+// cachedMetadata is the cached metadata of a volume.
+type cachedMetadata struct {
+	// data is the metadata in JSON, it is unmarshaled on each hit so the callers can't modify the cache
+	data []byte
+
+	// stamp of the stored metadata when it was fetched, empty if the store doesn't support stamps
+	stamp string
+
+	// validatedAt is when the cache was fetched or validated against the stamp
+	validatedAt time.Time
+}
+
+// cachedMetadataStore caches the metadata of another store in memory. A cached metadata is used without checking the store within the TTL,
+// then it is validated against the stamp of the stored metadata and only fetched again if it has changed, so the changes made by other nodes
+// become visible within the TTL. The cache is invalidated on local writes.
+type cachedMetadataStore struct {
+	store MetadataStore
+	ttl   time.Duration
+
+	mutex    sync.Mutex
+	volumes  map[string]*cachedMetadata
+	list     map[string][]byte
+	listedAt time.Time
+
+	// generations count the invalidations of each volume and of the list, a fetched metadata or list is only cached
+	// if no invalidation happened while it was read, since it may have been read before the write
+	generations    map[string]uint64
+	listGeneration uint64
+}
+
+// NewCachedMetadataStore creates a metadata store which caches the metadata of the store in memory for the TTL.
+func NewCachedMetadataStore(store MetadataStore, ttl time.Duration) MetadataStore {
+	return &cachedMetadataStore{
+		store:       store,
+		ttl:         ttl,
+		volumes:     map[string]*cachedMetadata{},
+		generations: map[string]uint64{},
+	}
+}
+
+func (store *cachedMetadataStore) Create(name string, metadata *apis.VolumeMetadata) error {
+	defer store.invalidate(name)
+	return store.store.Create(name, metadata)
+}
+
+func (store *cachedMetadataStore) Fetch(name string) (*apis.VolumeMetadata, error) {
+	store.mutex.Lock()
+	cached, generation := store.volumes[name], store.generations[name]
+	store.mutex.Unlock()
+
+	if cached != nil && time.Since(cached.validatedAt) < store.ttl {
+		return decodeCachedMetadata(cached.data)
+	}
+
+	// Take the stamp before fetching, so a change made in between is fetched again next time
+	stamp, stamped := "", false
+	if stamper, ok := store.store.(metadataStamper); ok {
+		var err error
+		stamp, err = stamper.stamp(name)
+		stamped = err == nil
+	}
+
+	if cached != nil && stamped && cached.stamp == stamp {
+		store.mutex.Lock()
+		cached.validatedAt = time.Now()
+		store.mutex.Unlock()
+		return decodeCachedMetadata(cached.data)
+	}
+
+	metadata, err := store.store.Fetch(name)
+	if err != nil {
+		store.invalidate(name)
+		return nil, err
+	}
+	if !stamped && store.ttl == 0 {
+		return metadata, nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache volume metadata: %v", err)
+	}
+	store.mutex.Lock()
+	if store.generations[name] == generation {
+		store.volumes[name] = &cachedMetadata{data: data, stamp: stamp, validatedAt: time.Now()}
+	}
+	store.mutex.Unlock()
+
+	return metadata, nil
+}
+
+func (store *cachedMetadataStore) List() (map[string]*apis.VolumeMetadata, error) {
+	return listMetadata(store)
+}
+
+func (store *cachedMetadataStore) Walk(fn func(name string, metadata *apis.VolumeMetadata) error) error {
+	store.mutex.Lock()
+	list, listedAt, generation := store.list, store.listedAt, store.listGeneration
+	store.mutex.Unlock()
+
+	if list != nil && time.Since(listedAt) < store.ttl {
+		for name, data := range list {
+			metadata, err := decodeCachedMetadata(data)
+			if err != nil {
+				return err
+			}
+			if err := fn(name, metadata); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if store.ttl == 0 {
+		return store.store.Walk(fn)
+	}
+
+	// Only cache the list once the walk completes
+	list = map[string][]byte{}
+	err := store.store.Walk(func(name string, metadata *apis.VolumeMetadata) error {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to cache volume metadata: %v", err)
+		}
+		list[name] = data
+
+		return fn(name, metadata)
+	})
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	if store.listGeneration == generation {
+		store.list, store.listedAt = list, time.Now()
+	}
+	store.mutex.Unlock()
+	return nil
+}
+
+func (store *cachedMetadataStore) Update(name string, update func(metadata *apis.VolumeMetadata) error) error {
+	defer store.invalidate(name)
+	return store.store.Update(name, update)
+}
+
+func (store *cachedMetadataStore) Delete(name string) error {
+	defer store.invalidate(name)
+	return store.store.Delete(name)
+}
+
+func (store *cachedMetadataStore) Migrate(name string, dryRun bool) (int, error) {
+	defer store.invalidate(name)
+	return store.store.Migrate(name, dryRun)
+}
+
+func (store *cachedMetadataStore) Close() error {
+	return store.store.Close()
+}
+
+func (store *cachedMetadataStore) verify(name string) error {
+	if repairer, ok := store.store.(metadataRepairer); ok {
+		return repairer.verify(name)
+	}
+
+	_, err := store.store.Fetch(name)
+	return err
+}
+
+func (store *cachedMetadataStore) restoreBackup(name string, dryRun bool) (bool, error) {
+	repairer, ok := store.store.(metadataRepairer)
+	if !ok {
+		return false, nil
+	}
+
+	defer store.invalidate(name)
+	return repairer.restoreBackup(name, dryRun)
+}
+
+// invalidate drops the cached metadata of the volume and the cached list.
+func (store *cachedMetadataStore) invalidate(name string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.volumes, name)
+	store.list = nil
+	store.generations[name]++
+	store.listGeneration++
+}
+
+func decodeCachedMetadata(data []byte) (*apis.VolumeMetadata, error) {
+	metadata := &apis.VolumeMetadata{}
+	err := json.Unmarshal(data, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cached volume metadata: %v", err)
+	}
+	return metadata, nil
+}
+
+// fileStamp is the stamp of a file made of its inode, modification time and size, the inode changes whenever it is written atomically.
+func fileStamp(filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	var inode uint64
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = stat.Ino
+	}
+	return fmt.Sprintf("%d-%d-%d", inode, info.ModTime().UnixNano(), info.Size()), nil
+}
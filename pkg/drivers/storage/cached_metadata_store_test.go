@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingMetadataStore counts the fetches of the file metadata store, and calls the hook after each fetch or walk if it is set.
+type countingMetadataStore struct {
+	*fileMetadataStore
+	fetches int
+	hook    func()
+}
+
+func (store *countingMetadataStore) Fetch(name string) (*apis.VolumeMetadata, error) {
+	store.fetches++
+	metadata, err := store.fileMetadataStore.Fetch(name)
+	if store.hook != nil {
+		store.hook()
+	}
+	return metadata, err
+}
+
+func (store *countingMetadataStore) Walk(fn func(name string, metadata *apis.VolumeMetadata) error) error {
+	err := store.fileMetadataStore.Walk(fn)
+	if store.hook != nil {
+		store.hook()
+	}
+	return err
+}
+
+func TestCachedMetadataStore(t *testing.T) {
+	testCases := []struct {
+		description   string
+		ttl           time.Duration
+		expectFetches int
+		expectStale   bool
+	}{
+		{
+			description:   "cached metadata is validated by stamp without ttl",
+			ttl:           0,
+			expectFetches: 2,
+			expectStale:   false,
+		},
+		{
+			description:   "cached metadata is used within ttl",
+			ttl:           time.Hour,
+			expectFetches: 1,
+			expectStale:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			rootPath := t.TempDir()
+			assert.NoError(t, os.Mkdir(path.Join(rootPath, "test"), 0755))
+			fileStore := NewFileMetadataStore(log.New("test"), rootPath, 0).(*fileMetadataStore)
+			counting := &countingMetadataStore{fileMetadataStore: fileStore}
+			store := NewCachedMetadataStore(counting, testCase.ttl)
+
+			metadata := &apis.VolumeMetadata{
+				CreatedAt: time.Now(),
+				Spec:      &apis.VolumeSpec{},
+				Status:    &apis.VolumeStatus{Mountpoint: "test/_data"},
+			}
+			assert.NoError(t, store.Create("test", metadata))
+
+			// Test the unchanged metadata is only fetched once
+			for i := 0; i < 3; i++ {
+				fetched, err := store.Fetch("test")
+				assert.NoError(t, err)
+				assert.False(t, fetched.Spec.PurgeAfterDelete)
+
+				// Test the callers can't modify the cache
+				fetched.Spec.PurgeAfterDelete = true
+			}
+			assert.Equal(t, 1, counting.fetches)
+
+			// Test the change made by another node
+			assert.NoError(t, fileStore.Update("test", func(metadata *apis.VolumeMetadata) error {
+				metadata.Spec.PurgeAfterDelete = true
+				return nil
+			}))
+			fetched, err := store.Fetch("test")
+			assert.NoError(t, err)
+			assert.Equal(t, !testCase.expectStale, fetched.Spec.PurgeAfterDelete)
+			assert.Equal(t, testCase.expectFetches, counting.fetches)
+
+			// Test the local writes invalidate the cache
+			assert.NoError(t, store.Update("test", func(metadata *apis.VolumeMetadata) error {
+				metadata.Status.Mountpoint = "updated/_data"
+				return nil
+			}))
+			fetched, err = store.Fetch("test")
+			assert.NoError(t, err)
+			assert.Equal(t, "updated/_data", fetched.Status.Mountpoint)
+			volumeMetadataMap, err := store.List()
+			assert.NoError(t, err)
+			assert.Equal(t, "updated/_data", volumeMetadataMap["test"].Status.Mountpoint)
+
+			assert.NoError(t, store.Delete("test"))
+			_, err = store.Fetch("test")
+			assert.Error(t, err)
+			volumeMetadataMap, err = store.List()
+			assert.NoError(t, err)
+			assert.Empty(t, volumeMetadataMap)
+		})
+	}
+}
+
+func TestCachedMetadataStoreInvalidatedWhileReading(t *testing.T) {
+	rootPath := t.TempDir()
+	assert.NoError(t, os.Mkdir(path.Join(rootPath, "test"), 0755))
+	counting := &countingMetadataStore{fileMetadataStore: NewFileMetadataStore(log.New("test"), rootPath, 0).(*fileMetadataStore)}
+	store := NewCachedMetadataStore(counting, time.Hour)
+	assert.NoError(t, store.Create("test", &apis.VolumeMetadata{
+		CreatedAt: time.Now(),
+		Spec:      &apis.VolumeSpec{},
+		Status:    &apis.VolumeStatus{Mountpoint: "test/_data"},
+	}))
+
+	// A local write between reading and caching, the metadata read before it must not be cached
+	update := func(mountpoint string) func() {
+		return func() {
+			counting.hook = nil
+			assert.NoError(t, store.Update("test", func(metadata *apis.VolumeMetadata) error {
+				metadata.Status.Mountpoint = mountpoint
+				return nil
+			}))
+		}
+	}
+
+	counting.hook = update("fetched/_data")
+	fetched, err := store.Fetch("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "test/_data", fetched.Status.Mountpoint)
+	fetched, err = store.Fetch("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "fetched/_data", fetched.Status.Mountpoint)
+
+	counting.hook = update("walked/_data")
+	volumeMetadataMap, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, "fetched/_data", volumeMetadataMap["test"].Status.Mountpoint)
+	volumeMetadataMap, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, "walked/_data", volumeMetadataMap["test"].Status.Mountpoint)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+// fileMetadataStore stores the metadata of each volume as a JSON file in the volume directory.
+type fileMetadataStore struct {
+	logger      *log.Logger
+	rootPath    string
+	concurrency int
+}
+
+// NewFileMetadataStore creates a metadata store which keeps the metadata in the volume directories under the root path,
+// the metadata files are read by up to concurrency workers when listing.
+func NewFileMetadataStore(logger *log.Logger, rootPath string, concurrency int) MetadataStore {
+	if concurrency <= 0 {
+		concurrency = defaultListConcurrency
+	}
+
+	return &fileMetadataStore{
+		logger:      logger,
+		rootPath:    rootPath,
+		concurrency: concurrency,
+	}
+}
+
+func (store *fileMetadataStore) Create(name string, metadata *apis.VolumeMetadata) error {
+	// Check if the metadata file already exists, which indicates that the volume already exists
+	if _, err := os.Stat(store.getMetadataFilePath(name)); err == nil {
+		return ErrVolumeExists
+	}
+
+	return writeMetadataFile(store.getMetadataFilePath(name), metadata)
+}
+
+func (store *fileMetadataStore) Fetch(name string) (*apis.VolumeMetadata, error) {
+	return readMetadataFile(store.logger, store.getMetadataFilePath(name))
+}
+
+func (store *fileMetadataStore) List() (map[string]*apis.VolumeMetadata, error) {
+	return listMetadata(store)
+}
+
+// Walk reads the metadata files with a bounded pool of workers, since the latency of the share dominates the reads.
+func (store *fileMetadataStore) Walk(fn func(name string, metadata *apis.VolumeMetadata) error) error {
+	entries, err := os.ReadDir(store.rootPath)
+	if err != nil {
+		return fmt.Errorf("failed to read root directory: %v", err)
+	}
+
+	names := make(chan string)
+	done := make(chan struct{})
+	var walkErr error
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	for i := 0; i < store.concurrency; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for name := range names {
+				metadata, err := store.Fetch(name)
+				if errors.Is(err, fs.ErrNotExist) {
+					store.logger.Debugf("skipping volume %s without metadata", name)
+					continue
+				}
+				if err != nil {
+					store.logger.Warningf("failed to get metadata for volume %s: %v", name, err)
+					continue
+				}
+
+				// Call the function serially, so it doesn't need to be safe for concurrent use
+				mutex.Lock()
+				if walkErr == nil {
+					walkErr = fn(name, metadata)
+					if walkErr != nil {
+						close(done)
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, entry := range entries {
+		if !entry.IsDir() || isInternalEntry(entry.Name()) {
+			continue
+		}
+
+		select {
+		case names <- entry.Name():
+		case <-done:
+			break feed
+		}
+	}
+	close(names)
+	waitGroup.Wait()
+
+	return walkErr
+}
+
+func (store *fileMetadataStore) Update(name string, update func(metadata *apis.VolumeMetadata) error) error {
+	metadata, err := store.Fetch(name)
+	if err != nil {
+		return err
+	}
+
+	err = update(metadata)
+	if err != nil {
+		return err
+	}
+
+	return writeMetadataFile(store.getMetadataFilePath(name), metadata)
+}
+
+func (store *fileMetadataStore) Delete(name string) error {
+	return removeMetadataFile(store.getMetadataFilePath(name))
+}
+
+func (store *fileMetadataStore) Migrate(name string, dryRun bool) (int, error) {
+	data, err := os.ReadFile(store.getMetadataFilePath(name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+	_, version, err := apis.MigrateVolumeMetadata(data)
+	if err != nil || dryRun || version == apis.CurrentSchemaVersion {
+		return version, err
+	}
+
+	metadata, err := store.Fetch(name)
+	if err != nil {
+		return version, err
+	}
+	return version, writeMetadataFile(store.getMetadataFilePath(name), metadata)
+}
+
+func (store *fileMetadataStore) Close() error {
+	// Do nothing
+	return nil
+}
+
+func (store *fileMetadataStore) verify(name string) error {
+	_, err := parseMetadataFile(store.getMetadataFilePath(name))
+	return err
+}
+
+func (store *fileMetadataStore) restoreBackup(name string, dryRun bool) (bool, error) {
+	backup, err := os.ReadFile(store.getMetadataFilePath(name) + metadataBackupSuffix)
+	if err != nil {
+		return false, nil
+	}
+	if _, err := parseMetadata(backup); err != nil {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	return true, utils.WriteFileAtomic(store.getMetadataFilePath(name), backup, 0644)
+}
+
+func (store *fileMetadataStore) stamp(name string) (string, error) {
+	return fileStamp(store.getMetadataFilePath(name))
+}
+
+func (store *fileMetadataStore) getMetadataFilePath(name string) string {
+	return path.Join(store.rootPath, name, metadataFileName)
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+const quarantineDirName = ".quarantine"
+
+// CheckState classifies an entry of the root directory.
+type CheckState string
+
+const (
+	// CheckStateValid is a volume with valid metadata and data directory
+	CheckStateValid CheckState = "valid"
+
+	// CheckStateOrphan is a volume directory with data but no metadata
+	CheckStateOrphan CheckState = "orphan"
+
+	// CheckStateCorruptMetadata is a volume directory whose metadata can't be read or fails validation
+	CheckStateCorruptMetadata CheckState = "corrupt-metadata"
+
+	// CheckStateMissingData is a volume with valid metadata but no data directory
+	CheckStateMissingData CheckState = "missing-data"
+
+	// CheckStateStaleLock is a volume directory left with nothing but the metadata lock
+	CheckStateStaleLock CheckState = "stale-lock"
+
+	// CheckStateUnknown is an entry which is not managed by the storage
+	CheckStateUnknown CheckState = "unknown"
+)
+
+// CheckResult is the consistency check result of an entry of the root directory.
+type CheckResult struct {
+	// Name of the entry
+	Name string
+
+	// State of the entry
+	State CheckState
+
+	// Detail explains the state
+	Detail string
+}
+
+// Check classifies every entry of the root directory, the namespace directories are checked by their own storages.
+func (s *Builtin) Check() ([]*CheckResult, error) {
+	entries, err := os.ReadDir(s.rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %v", err)
+	}
+
+	results := make([]*CheckResult, 0, len(entries))
+	for _, entry := range entries {
+		if isInternalEntry(entry.Name()) || (entry.IsDir() && s.isNamespace(entry.Name())) {
+			continue
+		}
+		if !entry.IsDir() {
+			results = append(results, &CheckResult{Name: entry.Name(), State: CheckStateUnknown, Detail: "not a directory"})
+			continue
+		}
+
+		results = append(results, s.checkVolume(entry.Name()))
+	}
+
+	return results, nil
+}
+
+// Repair fixes the entry according to its check result and returns a description of the action, nothing is changed in dry run mode.
+// Corrupt metadata is restored from its backup or quarantined if the backup is invalid too, a missing data directory is recreated and a stale lock is removed, other states are left as is.
+func (s *Builtin) Repair(result *CheckResult, dryRun bool) (string, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	switch result.State {
+	case CheckStateCorruptMetadata:
+		if repairer, ok := s.store.(metadataRepairer); ok {
+			restored, err := repairer.restoreBackup(result.Name, dryRun)
+			if restored || err != nil {
+				return "restore metadata from backup", err
+			}
+		}
+
+		entry := fmt.Sprintf("%s-%s", result.Name, time.Now().UTC().Format(trashTimeFormat))
+		action := fmt.Sprintf("quarantine as %s", path.Join(quarantineDirName, entry))
+		if dryRun {
+			return action, nil
+		}
+
+		err := os.MkdirAll(path.Join(s.rootPath, quarantineDirName), 0755)
+		if err != nil {
+			return action, fmt.Errorf("failed to create quarantine directory: %v", err)
+		}
+		err = os.Rename(path.Join(s.rootPath, result.Name), path.Join(s.rootPath, quarantineDirName, entry))
+		if err != nil {
+			return action, err
+		}
+
+		// Drop the corrupt metadata left in the store if it is not kept in the volume directory
+		err = s.store.Delete(result.Name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warningf("failed to delete corrupt metadata of volume %s: %v", result.Name, err)
+		}
+		return action, nil
+	case CheckStateMissingData:
+		action := "recreate empty data directory"
+		if dryRun {
+			return action, nil
+		}
+
+		return action, os.Mkdir(s.getDataDirPath(result.Name), 0755)
+	case CheckStateStaleLock:
+		action := "remove stale lock"
+		if dryRun {
+			return action, nil
+		}
+
+		// Make sure the lock is not held by a running operation before removing it
+		lock := flock.New(path.Join(s.rootPath, result.Name, metadataLockName))
+		locked, err := lock.TryLock()
+		if err != nil {
+			return action, fmt.Errorf("failed to acquire metadata lock: %v", err)
+		}
+		if !locked {
+			return action, fmt.Errorf("metadata lock is held")
+		}
+		defer func() {
+			if err := lock.Unlock(); err != nil {
+				s.logger.Errorf("failed to unlock flock: %v", err)
+			}
+		}()
+
+		return action, os.RemoveAll(path.Join(s.rootPath, result.Name))
+	default:
+		return "", nil
+	}
+}
+
+func (s *Builtin) checkVolume(name string) *CheckResult {
+	result := &CheckResult{Name: name}
+
+	metadataErr := s.verifyMetadata(name)
+	dataInfo, dataErr := os.Stat(s.getDataDirPath(name))
+	hasData := dataErr == nil && dataInfo.IsDir()
+
+	switch {
+	case metadataErr == nil:
+		if !hasData {
+			result.State, result.Detail = CheckStateMissingData, "data directory does not exist"
+		} else {
+			result.State = CheckStateValid
+		}
+	case errors.Is(metadataErr, apis.ErrNewerSchema):
+		// The metadata is left to the plugins of the newer version, restoring the backup would lose its newer fields
+		result.State, result.Detail = CheckStateUnknown, metadataErr.Error()
+	case !errors.Is(metadataErr, fs.ErrNotExist):
+		result.State, result.Detail = CheckStateCorruptMetadata, metadataErr.Error()
+	case hasData:
+		result.State, result.Detail = CheckStateOrphan, "metadata does not exist"
+	default:
+		result.State, result.Detail = s.checkLeftovers(name)
+	}
+
+	return result
+}
+
+// checkLeftovers classifies a volume directory without metadata and data.
+func (s *Builtin) checkLeftovers(name string) (CheckState, string) {
+	entries, err := os.ReadDir(path.Join(s.rootPath, name))
+	if err != nil {
+		return CheckStateUnknown, err.Error()
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != metadataLockName {
+			return CheckStateUnknown, fmt.Sprintf("unexpected entry %s", entry.Name())
+		}
+	}
+
+	return CheckStateStaleLock, "neither metadata nor data exists"
+}
+
+// verifyMetadata checks the metadata of the volume, without falling back to its backup to detect the corruption.
+func (s *Builtin) verifyMetadata(name string) error {
+	if repairer, ok := s.store.(metadataRepairer); ok {
+		return repairer.verify(name)
+	}
+
+	_, err := s.store.Fetch(name)
+	return err
+}
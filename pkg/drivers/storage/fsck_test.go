@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	rootPath := t.TempDir()
+	s := NewBuiltin(log.New("test"), rootPath)
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	assert.NoError(t, s.CreateVolume("valid", &apis.VolumeSpec{}, false))
+	assert.NoError(t, s.CreateVolume("orphan", &apis.VolumeSpec{}, false))
+	assert.NoError(t, s.DeleteVolumeMetadata("orphan"))
+	assert.NoError(t, s.CreateVolume("corrupt-metadata", &apis.VolumeSpec{}, false))
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("corrupt-metadata"), []byte(`{"createAt":`), 0644))
+	assert.NoError(t, s.CreateVolume("missing-data", &apis.VolumeSpec{}, false))
+	assert.NoError(t, os.Remove(s.getDataDirPath("missing-data")))
+	assert.NoError(t, s.CreateVolume("stale-lock", &apis.VolumeSpec{}, false))
+	assert.NoError(t, os.Remove(s.getDataDirPath("stale-lock")))
+	assert.NoError(t, os.Remove(s.getMetadataFilePath("stale-lock")))
+	assert.NoError(t, s.CreateVolume("restorable", &apis.VolumeSpec{}, false))
+	assert.NoError(t, s.UpdateVolumeMetadata("restorable", func(metadata *apis.VolumeMetadata) error { return nil }))
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("restorable"), []byte(`{}`), 0644))
+	assert.NoError(t, os.WriteFile(path.Join(rootPath, "unknown"), []byte{}, 0644))
+	assert.NoError(t, os.Mkdir(path.Join(rootPath, "namespace"), 0755))
+	assert.NoError(t, MarkNamespace(path.Join(rootPath, "namespace")))
+	assert.NoError(t, os.Mkdir(path.Join(rootPath, "namespace", "_data"), 0755))
+	namespace := NewBuiltin(log.New("test"), path.Join(rootPath, "namespace"))
+	assert.NoError(t, namespace.CreateVolume("valid", &apis.VolumeSpec{}, false))
+	assert.NoError(t, namespace.Close())
+
+	// Test the namespace directories are neither volumes nor orphans
+	assert.Error(t, MarkNamespace(path.Join(rootPath, "valid")))
+	assert.Error(t, MarkNamespace(path.Join(rootPath, "orphan")))
+	assert.Error(t, s.CreateVolume("namespace", &apis.VolumeSpec{}, false))
+	orphans, err := s.ListOrphans()
+	assert.NoError(t, err)
+	assert.Len(t, orphans, 1)
+	assert.Equal(t, "orphan", orphans[0].Name)
+
+	// Test Check classifies every entry
+	results, err := s.Check()
+	assert.NoError(t, err)
+	assert.Len(t, results, 7)
+	for _, result := range results {
+		if result.Name == "restorable" {
+			assert.Equal(t, CheckStateCorruptMetadata, result.State)
+		} else {
+			assert.Equal(t, CheckState(result.Name), result.State)
+		}
+
+		// Test Repair in dry run mode changes nothing
+		_, err := s.Repair(result, true)
+		assert.NoError(t, err)
+	}
+	resultsAfterDryRun, err := s.Check()
+	assert.NoError(t, err)
+	assert.Equal(t, results, resultsAfterDryRun)
+
+	// Test Repair
+	for _, result := range results {
+		_, err := s.Repair(result, false)
+		assert.NoError(t, err)
+	}
+	results, err = s.Check()
+	assert.NoError(t, err)
+	states := map[string]CheckState{}
+	for _, result := range results {
+		states[result.Name] = result.State
+	}
+	assert.Equal(t, map[string]CheckState{
+		"valid":        CheckStateValid,
+		"orphan":       CheckStateOrphan,
+		"missing-data": CheckStateValid,
+		"restorable":   CheckStateValid,
+		"unknown":      CheckStateUnknown,
+	}, states)
+	entries, err := os.ReadDir(path.Join(rootPath, quarantineDirName))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+const (
+	metadataFileName     = "_metadata.json"
+	metadataLockName     = "_metadata.json.lock"
+	metadataBackupSuffix = ".bak"
+
+	defaultListConcurrency = 16
+)
+
+// ErrVolumeExists is returned by MetadataStore.Create if the volume already exists.
+var ErrVolumeExists = errors.New("volume already exists")
+
+// MetadataStore stores the metadata of volumes by volume name. The stores don't lock the volumes, the callers hold the metadata lock of a volume
+// while modifying it, so the operations of different volumes may run concurrently.
+type MetadataStore interface {
+	// Create stores the metadata of a new volume, it returns ErrVolumeExists if the volume already exists.
+	Create(name string, metadata *apis.VolumeMetadata) error
+
+	// Fetch retrieves the metadata of the volume, the error wraps fs.ErrNotExist if the volume doesn't exist.
+	Fetch(name string) (*apis.VolumeMetadata, error)
+
+	// List retrieves the metadata of all volumes, the volumes whose metadata can't be read are skipped.
+	List() (map[string]*apis.VolumeMetadata, error)
+
+	// Walk calls the function with the metadata of each volume in no particular order as it is read, instead of collecting all of them,
+	// the volumes whose metadata can't be read are skipped. The walk stops at the first error returned by the function, which must not write the store.
+	Walk(fn func(name string, metadata *apis.VolumeMetadata) error) error
+
+	// Update applies the update to the metadata of the volume and stores it.
+	Update(name string, update func(metadata *apis.VolumeMetadata) error) error
+
+	// Delete deletes the metadata of the volume, the error wraps fs.ErrNotExist if the volume doesn't exist.
+	Delete(name string) error
+
+	// Migrate rewrites the metadata of the volume in the current schema version and returns the schema version it was stored in,
+	// nothing is written in dry run mode or if it is already in the current schema version.
+	Migrate(name string, dryRun bool) (int, error)
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// metadataRepairer is implemented by the stores which keep a backup of the metadata.
+type metadataRepairer interface {
+	// verify checks the stored metadata of the volume without falling back to its backup.
+	verify(name string) error
+
+	// restoreBackup replaces the metadata of the volume by its backup, it returns false if there is no valid backup.
+	restoreBackup(name string, dryRun bool) (bool, error)
+}
+
+// MetadataStoreOptions selects and configures the metadata store of volumes.
+type MetadataStoreOptions struct {
+	// Type of the metadata store, supported: file, bolt
+	Type string `json:"type,omitempty"`
+
+	// Path of the database file of the bolt metadata store, default to .metadata.db in the root path
+	Path string `json:"path,omitempty"`
+
+	// SingleNode allows the database file outside the root path, which is only valid if no other node uses the root path
+	SingleNode bool `json:"singleNode,omitempty"`
+
+	// Timeout of acquiring the lock of the database file of the bolt metadata store
+	Timeout apis.Duration `json:"timeout,omitempty"`
+
+	// ListConcurrency is the number of metadata files read concurrently by the file metadata store when listing
+	ListConcurrency int `json:"listConcurrency,omitempty"`
+
+	// CacheTTL is how long the cached metadata is used without checking whether it was changed by other nodes
+	CacheTTL apis.Duration `json:"cacheTTL,omitempty"`
+}
+
+// NewMetadataStore creates the metadata store of volumes in the root path according to the options, the metadata is cached in memory.
+func NewMetadataStore(logger *log.Logger, rootPath string, opts *MetadataStoreOptions) (MetadataStore, error) {
+	var store MetadataStore
+	switch opts.Type {
+	case "", "file":
+		store = NewFileMetadataStore(logger, rootPath, opts.ListConcurrency)
+	case "bolt":
+		// The volumes in a database the other nodes don't see look orphaned to them, and their data would be adopted or purged
+		if opts.Path != "" && !opts.SingleNode && !isInRootPath(rootPath, opts.Path) {
+			return nil, fmt.Errorf("database file %s is outside the root path, which is only valid with singleNode", opts.Path)
+		}
+		var err error
+		store, err = NewBoltMetadataStore(logger, opts.Path, rootPath, opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("metadata store %s is invalid", opts.Type)
+	}
+
+	return NewCachedMetadataStore(store, time.Duration(opts.CacheTTL)), nil
+}
+
+// isInRootPath tells whether the path is in the root path.
+func isInRootPath(rootPath string, path string) bool {
+	relative, err := filepath.Rel(filepath.Clean(rootPath), filepath.Clean(path))
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, "../")
+}
+
+// listMetadata collects the metadata walked by the store.
+func listMetadata(store MetadataStore) (map[string]*apis.VolumeMetadata, error) {
+	volumeMetadataMap := make(map[string]*apis.VolumeMetadata)
+	err := store.Walk(func(name string, metadata *apis.VolumeMetadata) error {
+		volumeMetadataMap[name] = metadata
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return volumeMetadataMap, nil
+}
+
+// readMetadataFile reads the metadata file, and falls back to its backup if the metadata file exists but can't be read.
+// The metadata of a newer schema version never falls back, since the backup would hide the newer fields.
+func readMetadataFile(logger *log.Logger, filePath string) (*apis.VolumeMetadata, error) {
+	metadata, err := parseMetadataFile(filePath)
+	if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, apis.ErrNewerSchema) {
+		return metadata, err
+	}
+
+	backup, backupErr := parseMetadataFile(filePath + metadataBackupSuffix)
+	if backupErr != nil {
+		return nil, err
+	}
+	logger.Warningf("metadata file %s is broken, falling back to its backup: %v", filePath, err)
+	return backup, nil
+}
+
+// parseMetadataFile reads and parses the metadata file.
+func parseMetadataFile(filePath string) (*apis.VolumeMetadata, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+
+	return parseMetadata(data)
+}
+
+// parseMetadata verifies the checksum of the metadata, migrates it to the current schema version and unmarshals it.
+func parseMetadata(data []byte) (*apis.VolumeMetadata, error) {
+	err := apis.VerifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err = apis.MigrateVolumeMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &apis.VolumeMetadata{}
+	err = metadata.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal volume metadata: %v", err)
+	}
+
+	return metadata, nil
+}
+
+// writeMetadataFile writes the metadata file atomically, the previous content is kept as backup if it is valid.
+// The metadata of a newer schema version is never overwritten.
+func writeMetadataFile(filePath string, metadata *apis.VolumeMetadata) error {
+	// Marshal the volume metadata to JSON format and write it to the metadata file
+	data, err := metadata.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal volume metadata: %v", err)
+	}
+
+	if previous, err := os.ReadFile(filePath); err == nil {
+		_, err := parseMetadata(previous)
+		if errors.Is(err, apis.ErrNewerSchema) {
+			return fmt.Errorf("refuse to overwrite metadata file: %w", err)
+		}
+		if err == nil {
+			err = utils.WriteFileAtomic(filePath+metadataBackupSuffix, previous, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to back up metadata file: %v", err)
+			}
+		}
+	}
+
+	return utils.WriteFileAtomic(filePath, data, 0644)
+}
+
+// removeMetadataFile removes the metadata file and its backup.
+func removeMetadataFile(filePath string) error {
+	err := os.Remove(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath + metadataBackupSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
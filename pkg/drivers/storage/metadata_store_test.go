@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataStore(t *testing.T) {
+	testCases := []struct {
+		description string
+		opts        *MetadataStoreOptions
+		expectErr   bool
+	}{
+		{
+			description: "file",
+			opts:        &MetadataStoreOptions{},
+		},
+		{
+			description: "bolt",
+			opts:        &MetadataStoreOptions{Type: "bolt"},
+		},
+		{
+			description: "invalid",
+			opts:        &MetadataStoreOptions{Type: "invalid"},
+			expectErr:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			rootPath := t.TempDir()
+			store, err := NewMetadataStore(log.New("test"), rootPath, testCase.opts)
+			if testCase.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer func() {
+				assert.NoError(t, store.Close())
+			}()
+
+			// The file store keeps the metadata in the volume directory
+			assert.NoError(t, os.Mkdir(path.Join(rootPath, "test"), 0755))
+
+			// Test Fetch and Delete of a volume which doesn't exist
+			_, err = store.Fetch("test")
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+			assert.True(t, errors.Is(store.Delete("test"), fs.ErrNotExist))
+
+			// Test Create
+			metadata := &apis.VolumeMetadata{
+				CreatedAt: time.Now(),
+				Spec:      &apis.VolumeSpec{},
+				Status:    &apis.VolumeStatus{Mountpoint: "test/_data"},
+			}
+			assert.NoError(t, store.Create("test", metadata))
+			assert.Equal(t, ErrVolumeExists, store.Create("test", metadata))
+
+			// Test Update and Fetch
+			assert.NoError(t, store.Update("test", func(metadata *apis.VolumeMetadata) error {
+				metadata.Spec.PurgeAfterDelete = true
+				return nil
+			}))
+			fetched, err := store.Fetch("test")
+			assert.NoError(t, err)
+			assert.True(t, fetched.Spec.PurgeAfterDelete)
+			assert.Equal(t, "test/_data", fetched.Status.Mountpoint)
+
+			// Test List
+			volumeMetadataMap, err := store.List()
+			assert.NoError(t, err)
+			assert.Len(t, volumeMetadataMap, 1)
+			assert.Contains(t, volumeMetadataMap, "test")
+
+			// Test Walk stops at the first error
+			assert.NoError(t, os.Mkdir(path.Join(rootPath, "other"), 0755))
+			assert.NoError(t, store.Create("other", metadata))
+			walked := 0
+			err = store.Walk(func(name string, metadata *apis.VolumeMetadata) error {
+				walked++
+				return errors.New("stop")
+			})
+			assert.EqualError(t, err, "stop")
+			assert.Equal(t, 1, walked)
+			assert.NoError(t, store.Delete("other"))
+
+			// Test Migrate of the current schema version
+			version, err := store.Migrate("test", false)
+			assert.NoError(t, err)
+			assert.Equal(t, apis.CurrentSchemaVersion, version)
+
+			// Test Delete
+			assert.NoError(t, store.Delete("test"))
+			_, err = store.Fetch("test")
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+			volumeMetadataMap, err = store.List()
+			assert.NoError(t, err)
+			assert.Empty(t, volumeMetadataMap)
+		})
+	}
+}
+
+func TestBoltMetadataStore(t *testing.T) {
+	rootPath := t.TempDir()
+	store, err := NewBoltMetadataStore(log.New("test"), "", rootPath, 0)
+	assert.NoError(t, err)
+	s := NewBuiltinWithMetadataStore(log.New("test"), rootPath, store)
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	// Test the database is kept in the root path and ignored as a volume
+	assert.FileExists(t, path.Join(rootPath, boltDatabaseName))
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{PurgeAfterDelete: true}, false))
+	assert.NoFileExists(t, s.getMetadataFilePath("test"))
+	assert.DirExists(t, s.getDataDirPath("test"))
+	results, err := s.Check()
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, CheckStateValid, results[0].State)
+
+	// Test the trashed volume is restored into the store
+	entry, err := s.TrashVolume("test", time.Hour)
+	assert.NoError(t, err)
+	_, err = s.FetchVolumeMetadata("test")
+	assert.Error(t, err)
+	assert.NoError(t, s.RestoreVolume(entry, "restored"))
+	metadata, err := s.FetchVolumeMetadata("restored")
+	assert.NoError(t, err)
+	assert.Nil(t, metadata.Status.TrashedAt)
+	assert.NoFileExists(t, s.getMetadataFilePath("restored"))
+
+	// Test the data is orphaned when the metadata is deleted
+	assert.NoError(t, s.DeleteVolumeMetadata("restored"))
+	orphans, err := s.ListOrphans()
+	assert.NoError(t, err)
+	assert.Len(t, orphans, 1)
+	assert.Equal(t, "restored", orphans[0].Name)
+}
+
+func BenchmarkListVolumeMetadata(b *testing.B) {
+	const volumeCount = 4000
+
+	benchmarks := []struct {
+		description string
+		opts        *MetadataStoreOptions
+	}{
+		{
+			description: "file sequential",
+			opts:        &MetadataStoreOptions{ListConcurrency: 1},
+		},
+		{
+			description: "file concurrent",
+			opts:        &MetadataStoreOptions{},
+		},
+		{
+			description: "bolt",
+			opts:        &MetadataStoreOptions{Type: "bolt"},
+		},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.description, func(b *testing.B) {
+			rootPath := b.TempDir()
+			store, err := NewMetadataStore(log.NewWithLogLevel("test", log.ErrorLevel), rootPath, benchmark.opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			s := NewBuiltinWithMetadataStore(log.NewWithLogLevel("test", log.ErrorLevel), rootPath, store)
+			defer func() {
+				assert.NoError(b, s.Close())
+			}()
+
+			for i := 0; i < volumeCount; i++ {
+				if err := s.CreateVolume(fmt.Sprintf("volume-%d", i), &apis.VolumeSpec{}, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				volumeMetadataMap, err := s.ListVolumeMetadata()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(volumeMetadataMap) != volumeCount {
+					b.Fatalf("listed %d volumes, expected %d", len(volumeMetadataMap), volumeCount)
+				}
+			}
+		})
+	}
+}
+
+func TestNewMetadataStore(t *testing.T) {
+	rootPath := t.TempDir()
+	tests := []struct {
+		name   string
+		opts   *MetadataStoreOptions
+		hasErr bool
+	}{
+		{name: "file", opts: &MetadataStoreOptions{}},
+		{name: "bolt in root path", opts: &MetadataStoreOptions{Type: "bolt"}},
+		{name: "bolt in sub directory", opts: &MetadataStoreOptions{Type: "bolt", Path: path.Join(rootPath, "sub", "..", "other.db")}},
+		{name: "bolt outside root path", opts: &MetadataStoreOptions{Type: "bolt", Path: path.Join(t.TempDir(), "local.db")}, hasErr: true},
+		{name: "bolt beside root path", opts: &MetadataStoreOptions{Type: "bolt", Path: rootPath + "-local.db"}, hasErr: true},
+		{name: "bolt outside root path on single node", opts: &MetadataStoreOptions{Type: "bolt", Path: path.Join(t.TempDir(), "local.db"), SingleNode: true}},
+		{name: "invalid", opts: &MetadataStoreOptions{Type: "invalid"}, hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewMetadataStore(log.New("test"), rootPath, tt.opts)
+			assert.True(t, (err != nil) == tt.hasErr, "NewMetadataStore got not excepted error: %v", err)
+			if err == nil {
+				assert.NoError(t, store.Close())
+			}
+		})
+	}
+}
+
+func TestNewerSchemaMetadata(t *testing.T) {
+	s := NewBuiltin(log.New("test"), t.TempDir())
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	// Leave a valid backup by updating the volume once
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{}, false))
+	assert.NoError(t, s.UpdateVolumeMetadata("test", func(metadata *apis.VolumeMetadata) error { return nil }))
+	assert.FileExists(t, s.getMetadataFilePath("test")+metadataBackupSuffix)
+	newer := []byte(`{"schemaVersion":2,"createdAt":"2026-10-18T00:00:00Z","spec":{},"status":{"mountpoint":"test/_data"},"newField":true}`)
+	assert.NoError(t, os.WriteFile(s.getMetadataFilePath("test"), newer, 0644))
+
+	// Test the metadata of the newer schema version neither falls back to the backup nor is overwritten
+	_, err := s.FetchVolumeMetadata("test")
+	assert.ErrorIs(t, err, apis.ErrNewerSchema)
+	err = s.UpdateVolumeMetadata("test", func(metadata *apis.VolumeMetadata) error { return nil })
+	assert.ErrorIs(t, err, apis.ErrNewerSchema)
+	assert.Error(t, writeMetadataFile(s.getMetadataFilePath("test"), &apis.VolumeMetadata{Spec: &apis.VolumeSpec{}, Status: &apis.VolumeStatus{}}))
+
+	// Test fsck doesn't restore the backup over it
+	results, err := s.Check()
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, CheckStateUnknown, results[0].State)
+	_, err = s.Repair(results[0], false)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(s.getMetadataFilePath("test"))
+	assert.NoError(t, err)
+	assert.Equal(t, newer, data)
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// NameMappingNone uses the validated volume names as the directory names
+	NameMappingNone = "none"
+
+	// NameMappingEscape escapes the characters which are unsafe in the directory names as %XX
+	NameMappingEscape = "escape"
+
+	// NameMappingHash uses a hash of the volume names as the directory names
+	NameMappingHash = "hash"
+
+	// maxDirNameLength is the maximum length of a file name on most file systems
+	maxDirNameLength = 255
+
+	// hashedDirNamePrefix is the prefix of the hashed directory names
+	hashedDirNamePrefix = "v-"
+)
+
+// NameMappingOptions configures how the volume names are mapped to the names of the volume directories.
+type NameMappingOptions struct {
+	// Mode of the mapping, supported: none, escape, hash, default to none
+	Mode string `json:"mode,omitempty"`
+
+	// CaseInsensitive indicates the root path is on a case-insensitive file system, so the names which differ only by case collide
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+}
+
+// nameMapper maps the volume names to the names of the volume directories.
+type nameMapper struct {
+	mode            string
+	caseInsensitive bool
+}
+
+// newNameMapper creates the name mapper with the options, nil options use the names as is.
+func newNameMapper(opts *NameMappingOptions) (*nameMapper, error) {
+	mapper := &nameMapper{mode: NameMappingNone}
+	if opts == nil {
+		return mapper, nil
+	}
+
+	switch opts.Mode {
+	case "", NameMappingNone:
+	case NameMappingEscape, NameMappingHash:
+		mapper.mode = opts.Mode
+	default:
+		return nil, fmt.Errorf("unsupported name mapping mode %s", opts.Mode)
+	}
+	mapper.caseInsensitive = opts.CaseInsensitive
+
+	return mapper, nil
+}
+
+// ValidateVolumeName rejects the volume names which can't be stored safely under the root path: empty names, path elements like . and ..,
+// names containing slashes or NUL, and names starting with a dot or an underscore, which are reserved for the internal entries of the storage.
+func ValidateVolumeName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("volume name can't be empty")
+	case strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("invalid volume name %q: slashes and NUL are not allowed", name)
+	case strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_"):
+		return fmt.Errorf("invalid volume name %q: names starting with a dot or an underscore are reserved", name)
+	}
+	return nil
+}
+
+// dirName returns the name of the directory of the volume.
+func (mapper *nameMapper) dirName(name string) (string, error) {
+	if err := ValidateVolumeName(name); err != nil {
+		return "", err
+	}
+
+	dir := name
+	switch mapper.mode {
+	case NameMappingEscape:
+		dir = mapper.escape(name)
+	case NameMappingHash:
+		sum := sha256.Sum256([]byte(name))
+		dir = hashedDirNamePrefix + hex.EncodeToString(sum[:16])
+	}
+
+	if len(dir) > maxDirNameLength {
+		return "", fmt.Errorf("invalid volume name %q: the directory name is longer than %d bytes", name, maxDirNameLength)
+	}
+	return dir, nil
+}
+
+// escape replaces the bytes other than alphanumerics, dots, dashes and underscores by %XX, the upper case letters are escaped too
+// on case-insensitive file systems, so the escaped names never differ only by case.
+func (mapper *nameMapper) escape(name string) string {
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		safe := c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_' ||
+			c >= 'A' && c <= 'Z' && !mapper.caseInsensitive
+		if safe {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+// checkCaseCollision returns an error if another entry of the root directory differs from the directory name only by case,
+// which would be the same directory on a case-insensitive file system.
+func (s *Builtin) checkCaseCollision(name string, dir string) error {
+	if !s.names.caseInsensitive {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.rootPath)
+	if err != nil {
+		return fmt.Errorf("failed to read root directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != dir && strings.EqualFold(entry.Name(), dir) {
+			return fmt.Errorf("volume name %s collides with the volume directory %s on the case-insensitive file system", name, entry.Name())
+		}
+	}
+	return nil
+}
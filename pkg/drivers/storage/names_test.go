@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameMapper(t *testing.T) {
+	testCases := []struct {
+		description string
+		opts        *NameMappingOptions
+		name        string
+		expectDir   string
+		expectErr   bool
+	}{
+		{
+			description: "plain name",
+			opts:        &NameMappingOptions{},
+			name:        "Test-1.data_x",
+			expectDir:   "Test-1.data_x",
+		},
+		{
+			description: "parent directory",
+			opts:        &NameMappingOptions{},
+			name:        "..",
+			expectErr:   true,
+		},
+		{
+			description: "slash",
+			opts:        &NameMappingOptions{},
+			name:        "a/../b",
+			expectErr:   true,
+		},
+		{
+			description: "internal file",
+			opts:        &NameMappingOptions{},
+			name:        metadataFileName,
+			expectErr:   true,
+		},
+		{
+			description: "hidden name",
+			opts:        &NameMappingOptions{Mode: NameMappingEscape},
+			name:        ".trash",
+			expectErr:   true,
+		},
+		{
+			description: "empty name",
+			opts:        &NameMappingOptions{Mode: NameMappingHash},
+			name:        "",
+			expectErr:   true,
+		},
+		{
+			description: "too long name",
+			opts:        &NameMappingOptions{},
+			name:        strings.Repeat("a", maxDirNameLength+1),
+			expectErr:   true,
+		},
+		{
+			description: "escape unsafe characters",
+			opts:        &NameMappingOptions{Mode: NameMappingEscape},
+			name:        "Test:1 %",
+			expectDir:   "Test%3A1%20%25",
+		},
+		{
+			description: "escape upper case on case-insensitive file system",
+			opts:        &NameMappingOptions{Mode: NameMappingEscape, CaseInsensitive: true},
+			name:        "Test",
+			expectDir:   "%54est",
+		},
+		{
+			description: "hash",
+			opts:        &NameMappingOptions{Mode: NameMappingHash},
+			name:        "test",
+			expectDir:   "v-9f86d081884c7d659a2feaa0c55ad015",
+		},
+		{
+			description: "invalid mode",
+			opts:        &NameMappingOptions{Mode: "invalid"},
+			name:        "test",
+			expectErr:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			mapper, err := newNameMapper(testCase.opts)
+			if err == nil {
+				var dir string
+				dir, err = mapper.dirName(testCase.name)
+				assert.Equal(t, testCase.expectDir, dir)
+			}
+			assert.Equal(t, testCase.expectErr, err != nil)
+		})
+	}
+}
+
+func TestNameMapping(t *testing.T) {
+	s := NewBuiltin(log.New("test"), t.TempDir())
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+	assert.NoError(t, s.SetNameMapping(&NameMappingOptions{Mode: NameMappingEscape}))
+
+	// Test the volume is stored in the escaped directory and listed by its original name
+	assert.NoError(t, s.CreateVolume("a:b", &apis.VolumeSpec{PurgeAfterDelete: true}, false))
+	assert.DirExists(t, s.getDataDirPath("a%3Ab"))
+	metadata, err := s.FetchVolumeMetadata("a:b")
+	assert.NoError(t, err)
+	assert.Equal(t, "a:b", metadata.Name)
+	assert.Equal(t, "a%3Ab/_data", metadata.Status.Mountpoint)
+	volumeMetadataMap, err := s.ListVolumeMetadata()
+	assert.NoError(t, err)
+	assert.Contains(t, volumeMetadataMap, "a:b")
+
+	// Test the trash entry keeps the original name
+	entry, err := s.TrashVolume("a:b", time.Hour)
+	assert.NoError(t, err)
+	trashEntries, err := s.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, trashEntries, 1)
+	assert.Equal(t, "a:b", trashEntries[0].Volume)
+	assert.NoError(t, s.RestoreVolume(entry, "c:d"))
+	metadata, err = s.FetchVolumeMetadata("c:d")
+	assert.NoError(t, err)
+	assert.Equal(t, "c:d", metadata.Name)
+
+	// Test the invalid names are rejected
+	assert.Error(t, s.CreateVolume("../escaped", &apis.VolumeSpec{}, false))
+	_, err = s.FetchVolumeMetadata("..")
+	assert.Error(t, err)
+}
+
+func TestCaseCollision(t *testing.T) {
+	s := NewBuiltin(log.New("test"), t.TempDir())
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+	assert.NoError(t, s.SetNameMapping(&NameMappingOptions{CaseInsensitive: true}))
+
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{}, false))
+	assert.Error(t, s.CreateVolume("Test", &apis.VolumeSpec{}, false))
+
+	// Test the existing volume can still be created again
+	assert.NoError(t, s.CreateVolume("test", &apis.VolumeSpec{}, false))
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// Orphan describes the data left behind by a volume which was deleted without purging.
+type Orphan struct {
+	// Name of the directory of the deleted volume, it is the volume name unless the names are mapped
+	Name string
+
+	// OrphanedAt is the last modification time of the volume directory, which is updated when the metadata is deleted
+	OrphanedAt time.Time
+}
+
+// ListOrphans lists the volume directories which have data but no metadata.
+func (s *Builtin) ListOrphans() ([]*Orphan, error) {
+	entries, err := os.ReadDir(s.rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %v", err)
+	}
+
+	orphans := []*Orphan{}
+	for _, entry := range entries {
+		if !entry.IsDir() || isInternalEntry(entry.Name()) || s.isNamespace(entry.Name()) {
+			continue
+		}
+
+		orphan, err := s.getOrphan(entry.Name())
+		if err != nil {
+			s.logger.Warningf("failed to check volume %s for orphaned data: %v", entry.Name(), err)
+			continue
+		}
+		if orphan != nil {
+			orphans = append(orphans, orphan)
+		}
+	}
+
+	return orphans, nil
+}
+
+// PurgeOrphans deletes the orphaned data older than the specified age and returns the names of purged volumes.
+func (s *Builtin) PurgeOrphans(olderThan time.Duration) ([]string, error) {
+	orphans, err := s.ListOrphans()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	for _, orphan := range orphans {
+		if time.Since(orphan.OrphanedAt) < olderThan {
+			continue
+		}
+
+		removed, err := s.purgeOrphan(orphan.Name)
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge orphaned data of volume %s: %v", orphan.Name, err)
+		}
+		if removed {
+			purged = append(purged, orphan.Name)
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeOrphan deletes the volume directory if it is still orphaned once the metadata lock is held.
+func (s *Builtin) purgeOrphan(name string) (bool, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	lock, err := s.acquireMetadataLock(name)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	// The volume may have been adopted since it was listed
+	orphan, err := s.getOrphan(name)
+	if err != nil || orphan == nil {
+		return false, err
+	}
+
+	return true, os.RemoveAll(path.Join(s.rootPath, name))
+}
+
+// getOrphan returns the orphan of the volume directory, or nil if it is not orphaned.
+func (s *Builtin) getOrphan(name string) (*Orphan, error) {
+	// The broken metadata is left to fsck, the volume is only orphaned if it has no metadata at all
+	if _, err := s.store.Fetch(name); !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(s.getDataDirPath(name)); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path.Join(s.rootPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Orphan{
+		Name:       name,
+		OrphanedAt: info.ModTime(),
+	}, nil
+}
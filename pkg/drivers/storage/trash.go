@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+)
+
+const (
+	trashDirName    = ".trash"
+	trashTimeFormat = "20060102T150405Z"
+)
+
+// TrashEntry describes a volume which was moved into the trash.
+type TrashEntry struct {
+	// Name of the entry in the trash, it is used to restore the volume
+	Name string
+
+	// Volume is the name of the volume before it was trashed
+	Volume string
+
+	// Metadata of the trashed volume, nil if it can't be read
+	Metadata *apis.VolumeMetadata
+}
+
+// TrashVolume moves the volume into the trash and returns the name of the trash entry, it will be purged by PurgeTrash once the retention has elapsed.
+func (s *Builtin) TrashVolume(name string, retention time.Duration) (string, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return "", err
+	}
+
+	lock, err := s.acquireMetadataLock(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	metadata, err := s.store.Fetch(dir)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(path.Join(s.rootPath, trashDirName), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create trash directory: %v", err)
+	}
+
+	trashedAt := time.Now()
+	purgeAt := trashedAt.Add(retention)
+	entry := fmt.Sprintf("%s-%s", dir, trashedAt.UTC().Format(trashTimeFormat))
+	err = os.Rename(path.Join(s.rootPath, dir), s.getTrashEntryPath(entry))
+	if err != nil {
+		return "", fmt.Errorf("failed to move volume %s into trash: %v", name, err)
+	}
+
+	// The trash entry keeps its metadata in a file whatever the metadata store is, so it can be restored on any store
+	err = s.store.Delete(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return entry, fmt.Errorf("failed to delete metadata of volume %s: %v", name, err)
+	}
+
+	// Record the retention in the trashed metadata after the move, so the data is safe even if it fails
+	metadata.Name = name
+	metadata.Status.TrashedAt = &trashedAt
+	metadata.Status.PurgeAt = &purgeAt
+	err = writeMetadataFile(path.Join(s.getTrashEntryPath(entry), metadataFileName), metadata)
+	if err != nil {
+		return entry, fmt.Errorf("failed to record retention of trash entry %s: %v", entry, err)
+	}
+
+	return entry, nil
+}
+
+// ListTrash lists all entries in the trash.
+func (s *Builtin) ListTrash() ([]*TrashEntry, error) {
+	entries, err := os.ReadDir(path.Join(s.rootPath, trashDirName))
+	if os.IsNotExist(err) {
+		return []*TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash directory: %v", err)
+	}
+
+	trashEntries := make([]*TrashEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		trashEntry := &TrashEntry{
+			Name:   entry.Name(),
+			Volume: entry.Name(),
+		}
+		if index := strings.LastIndex(entry.Name(), "-"); index > 0 {
+			trashEntry.Volume = entry.Name()[:index]
+		}
+		trashEntry.Metadata, err = readMetadataFile(s.logger, path.Join(s.getTrashEntryPath(entry.Name()), metadataFileName))
+		if err != nil {
+			s.logger.Warningf("failed to get metadata for trash entry %s: %v", entry.Name(), err)
+		} else if trashEntry.Metadata.Name != "" {
+			trashEntry.Volume = trashEntry.Metadata.Name
+		}
+
+		trashEntries = append(trashEntries, trashEntry)
+	}
+
+	return trashEntries, nil
+}
+
+// RestoreVolume moves a trash entry back as the volume with the specified name.
+func (s *Builtin) RestoreVolume(entry string, name string) error {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	if strings.Contains(entry, "/") || isInternalEntry(entry) {
+		return fmt.Errorf("invalid trash entry %s", entry)
+	}
+	dir, err := s.names.dirName(name)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCaseCollision(name, dir); err != nil {
+		return err
+	}
+
+	metadata, err := readMetadataFile(s.logger, path.Join(s.getTrashEntryPath(entry), metadataFileName))
+	if err != nil {
+		return fmt.Errorf("failed to get metadata for trash entry %s: %v", entry, err)
+	}
+
+	// Lock the trash entry, its lock file is moved with it and becomes the metadata lock of the volume, so the volume
+	// can't be created or restored concurrently from the check until its metadata is created
+	lock, err := s.acquireMetadataLock(path.Join(trashDirName, entry))
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			s.logger.Errorf("failed to unlock flock: %v", err)
+		}
+	}()
+
+	volumePath := path.Join(s.rootPath, dir)
+	if _, err := os.Lstat(volumePath); err == nil {
+		return fmt.Errorf("volume %s already exists", name)
+	}
+	err = os.Rename(s.getTrashEntryPath(entry), volumePath)
+	if err != nil {
+		return fmt.Errorf("failed to restore trash entry %s: %v", entry, err)
+	}
+
+	// Hand the metadata over from the file of the trash entry to the metadata store
+	restored, status := *metadata, *metadata.Status
+	restored.Name = name
+	restored.Status = &status
+	restored.Status.Mountpoint = s.getMountpointPath(dir)
+	restored.Status.TrashedAt = nil
+	restored.Status.PurgeAt = nil
+	err = removeMetadataFile(path.Join(volumePath, metadataFileName))
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("failed to remove metadata file of trash entry %s: %v", entry, err)
+	} else {
+		err = s.store.Create(dir, &restored)
+	}
+	if err != nil {
+		// Move the entry back into the trash with its metadata file, so it can be restored again
+		if err := os.Rename(volumePath, s.getTrashEntryPath(entry)); err != nil {
+			s.logger.Errorf("failed to move volume %s back into trash entry %s: %v", name, entry, err)
+		} else if err := writeMetadataFile(path.Join(s.getTrashEntryPath(entry), metadataFileName), metadata); err != nil {
+			s.logger.Errorf("failed to write metadata file of trash entry %s: %v", entry, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PurgeTrash deletes the trash entries whose retention has elapsed and returns their names.
+func (s *Builtin) PurgeTrash() ([]string, error) {
+	s.waitGroup.Add(1)
+	defer s.waitGroup.Done()
+
+	trashEntries, err := s.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	now := time.Now()
+	for _, trashEntry := range trashEntries {
+		if trashEntry.Metadata == nil || trashEntry.Metadata.Status.PurgeAt == nil {
+			s.logger.Warningf("trash entry %s has no retention, skipping purge", trashEntry.Name)
+			continue
+		}
+		if now.Before(*trashEntry.Metadata.Status.PurgeAt) {
+			continue
+		}
+
+		err = os.RemoveAll(s.getTrashEntryPath(trashEntry.Name))
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge trash entry %s: %v", trashEntry.Name, err)
+		}
+		purged = append(purged, trashEntry.Name)
+	}
+
+	return purged, nil
+}
+
+func (s *Builtin) getTrashEntryPath(entry string) string {
+	return path.Join(s.rootPath, trashDirName, entry)
+}
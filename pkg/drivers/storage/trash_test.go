@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingCreateMetadataStore fails to create any metadata.
+type failingCreateMetadataStore struct {
+	MetadataStore
+}
+
+func (store *failingCreateMetadataStore) Create(name string, metadata *apis.VolumeMetadata) error {
+	return errors.New("failed to create metadata")
+}
+
+func TestTrash(t *testing.T) {
+	s := NewBuiltin(log.New("test"), t.TempDir())
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	for _, name := range []string{"expired", "retained"} {
+		assert.NoError(t, s.CreateVolume(name, &apis.VolumeSpec{PurgeAfterDelete: true}, false))
+		assert.NoError(t, os.WriteFile(path.Join(s.getDataDirPath(name), "data"), []byte(name), 0644))
+	}
+
+	// Test TrashVolume
+	expiredEntry, err := s.TrashVolume("expired", 0)
+	assert.NoError(t, err)
+	retainedEntry, err := s.TrashVolume("retained", time.Hour)
+	assert.NoError(t, err)
+
+	// Test trashed volumes are hidden
+	volumeMetadataMap, err := s.ListVolumeMetadata()
+	assert.NoError(t, err)
+	assert.Empty(t, volumeMetadataMap)
+
+	// Test ListTrash
+	trashEntries, err := s.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, trashEntries, 2)
+	for _, trashEntry := range trashEntries {
+		assert.Contains(t, []string{expiredEntry, retainedEntry}, trashEntry.Name)
+		assert.Contains(t, []string{"expired", "retained"}, trashEntry.Volume)
+		assert.NotNil(t, trashEntry.Metadata.Status.PurgeAt)
+	}
+
+	// Test PurgeTrash only purges expired entries
+	purged, err := s.PurgeTrash()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{expiredEntry}, purged)
+
+	// Test RestoreVolume under a new name
+	assert.Error(t, s.RestoreVolume(expiredEntry, "restored"))
+	assert.Error(t, s.RestoreVolume("../retained", "restored"))
+	assert.NoError(t, s.RestoreVolume(retainedEntry, "restored"))
+	metadata, err := s.FetchVolumeMetadata("restored")
+	assert.NoError(t, err)
+	assert.Equal(t, "restored/_data", metadata.Status.Mountpoint)
+	assert.Nil(t, metadata.Status.PurgeAt)
+	data, err := os.ReadFile(path.Join(s.getDataDirPath("restored"), "data"))
+	assert.NoError(t, err)
+	assert.Equal(t, "retained", string(data))
+
+	// Test RestoreVolume over an existing volume
+	retainedEntry, err = s.TrashVolume("restored", time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, s.CreateVolume("restored", &apis.VolumeSpec{}, false))
+	assert.Error(t, s.RestoreVolume(retainedEntry, "restored"))
+
+	// Test the trash entry is moved back with its metadata if the metadata can't be created
+	store := s.store
+	s.store = &failingCreateMetadataStore{MetadataStore: store}
+	assert.Error(t, s.RestoreVolume(retainedEntry, "failed"))
+	s.store = store
+	_, err = os.Lstat(path.Join(s.rootPath, "failed"))
+	assert.True(t, os.IsNotExist(err))
+	trashEntries, err = s.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, trashEntries, 1)
+	assert.Equal(t, "restored", trashEntries[0].Volume)
+	assert.NotNil(t, trashEntries[0].Metadata.Status.PurgeAt)
+	assert.NoError(t, s.RestoreVolume(retainedEntry, "failed"))
+	metadata, err = s.FetchVolumeMetadata("failed")
+	assert.NoError(t, err)
+	assert.Equal(t, "failed/_data", metadata.Status.Mountpoint)
+}
@@ -0,0 +1,291 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/zouy414/docker-volume-plugin/pkg/drivers/apis"
+	"github.com/zouy414/docker-volume-plugin/pkg/log"
+	"github.com/zouy414/docker-volume-plugin/pkg/utils"
+)
+
+func init() {
+	registerFactory("tmpfs", tmpfsFactory)
+}
+
+// tmpfs is an implementation of the Driver interface for managing volumes in memory, the metadata is kept on the local disk
+// and a tmpfs is mounted at the data directory of a volume from its first mount until its last unmount.
+type tmpfs struct {
+	*builtin
+	opts     *tmpfsDriverOptions
+	rootPath string
+
+	// mounts are the ids of the mounts of the volumes whose tmpfs is mounted
+	mounts map[string]map[string]struct{}
+	mutex  sync.Mutex
+}
+
+type tmpfsDriverOptions struct {
+	builtinDriverOptions
+
+	// DefaultSize is the size of the tmpfs of the volumes without a size or quota, zero means the default of tmpfs
+	DefaultSize apis.Size `json:"defaultSize,omitempty"`
+
+	// MountOptions for tmpfs
+	MountOptions []string `json:"mountOptions,omitempty"`
+
+	// Mock indicates whether to run in mock mode (no actual tmpfs mount)
+	Mock bool `json:"mock,omitempty"`
+}
+
+func tmpfsFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+	opts := &tmpfsDriverOptions{
+		MountOptions:         []string{"nosuid", "nodev"},
+		builtinDriverOptions: defaultBuiltinDriverOptions(),
+		Mock:                 false,
+	}
+	// The data is gone once unmounted, so there is nothing to keep after deletion
+	opts.PurgeAfterDelete = true
+	if err := json.Unmarshal([]byte(driverOptions), opts); err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %s", err)
+	}
+	if err := checkUnmountedData(&opts.builtinDriverOptions); err != nil {
+		return nil, fmt.Errorf("tmpfs %s", err)
+	}
+
+	if err := utils.MountMock(propagatedMountpoint); err != nil {
+		return nil, fmt.Errorf("failed to create mount point: %s", err)
+	}
+	if opts.Mock {
+		logger.Warning("Mock mode enabled, no actual tmpfs mount will be performed")
+	}
+
+	base, err := newBuiltin(ctx, logger, propagatedMountpoint, &opts.builtinDriverOptions, opts.Mock)
+	if err != nil {
+		return nil, err
+	}
+	base.specExtension = parseTmpfsSpec
+
+	return &tmpfs{
+		builtin:  base,
+		opts:     opts,
+		rootPath: propagatedMountpoint,
+		mounts:   map[string]map[string]struct{}{},
+	}, nil
+}
+
+// Create creates or updates the volume, and resizes its tmpfs if it is mounted.
+func (driver *tmpfs) Create(name string, options map[string]string) error {
+	if err := driver.builtin.Create(name, options); err != nil {
+		return err
+	}
+	if update, _ := strconv.ParseBool(options["update"]); update {
+		return driver.resize(name)
+	}
+	return nil
+}
+
+// parseTmpfsSpec merges the tmpfs options size, mode, uid and gid into the tmpfs field of the spec. The size can't exceed the quota,
+// which is what the admission checks against maxQuota.
+func parseTmpfsSpec(spec *apis.VolumeSpec, options map[string]string) (map[string]string, error) {
+	tmpfsOptions := &apis.TmpfsOptions{}
+	options, found, err := tmpfsOptions.Unmarshal(options)
+	if err != nil {
+		return options, err
+	}
+
+	if found {
+		if spec.Tmpfs == nil {
+			spec.Tmpfs = &apis.TmpfsOptions{}
+		}
+		if tmpfsOptions.Size != 0 {
+			spec.Tmpfs.Size = tmpfsOptions.Size
+		}
+		if tmpfsOptions.Mode != nil {
+			spec.Tmpfs.Mode = tmpfsOptions.Mode
+		}
+		if tmpfsOptions.UID != nil {
+			spec.Tmpfs.UID = tmpfsOptions.UID
+		}
+		if tmpfsOptions.GID != nil {
+			spec.Tmpfs.GID = tmpfsOptions.GID
+		}
+	}
+
+	quota := spec.Quota
+	if value, existed := options["quota"]; existed {
+		if quota, err = apis.ParseSize(value); err != nil {
+			return options, fmt.Errorf("invalid value for quota: %v", err)
+		}
+	}
+	if spec.Tmpfs != nil && quota != 0 && spec.Tmpfs.Size > quota {
+		return options, fmt.Errorf("size %s exceeds the quota %s", spec.Tmpfs.Size, quota)
+	}
+	return options, nil
+}
+
+// resize applies the size of the volume to its mounted tmpfs, the mode and owner apply from the next first mount.
+func (driver *tmpfs) resize(name string) error {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	if driver.mounts[name] == nil || driver.mock {
+		return nil
+	}
+
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return err
+	}
+	size := driver.size(metadata.Spec)
+	if size == 0 {
+		return nil
+	}
+	err = utils.Remount(path.Join(driver.rootPath, metadata.Status.Mountpoint), []string{fmt.Sprintf("size=%d", size)})
+	if err != nil {
+		return fmt.Errorf("failed to resize tmpfs of volume %s: %v", name, err)
+	}
+	return nil
+}
+
+// size returns the size of the tmpfs of the volume, the size option precedes the quota.
+func (driver *tmpfs) size(spec *apis.VolumeSpec) apis.Size {
+	if spec.Tmpfs != nil && spec.Tmpfs.Size != 0 {
+		return spec.Tmpfs.Size
+	}
+	if spec.Quota != 0 {
+		return spec.Quota
+	}
+	return driver.opts.DefaultSize
+}
+
+// mountOptions returns the options of the tmpfs of the volume.
+func (driver *tmpfs) mountOptions(spec *apis.VolumeSpec) []string {
+	mountOptions := append([]string{}, driver.opts.MountOptions...)
+	if size := driver.size(spec); size != 0 {
+		mountOptions = append(mountOptions, fmt.Sprintf("size=%d", size))
+	}
+	if spec.Tmpfs != nil {
+		if spec.Tmpfs.Mode != nil {
+			mountOptions = append(mountOptions, fmt.Sprintf("mode=%o", *spec.Tmpfs.Mode))
+		}
+		if spec.Tmpfs.UID != nil {
+			mountOptions = append(mountOptions, fmt.Sprintf("uid=%d", *spec.Tmpfs.UID))
+		}
+		if spec.Tmpfs.GID != nil {
+			mountOptions = append(mountOptions, fmt.Sprintf("gid=%d", *spec.Tmpfs.GID))
+		}
+	}
+	return mountOptions
+}
+
+// Mount mounts the tmpfs of the volume on the first mount and then mounts the volume like the other builtin drivers.
+func (driver *tmpfs) Mount(name string, id string) (string, error) {
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return "", err
+	}
+
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	ids := driver.mounts[name]
+	if ids == nil {
+		if !driver.mock {
+			err = utils.MountTmpfs(path.Join(driver.rootPath, metadata.Status.Mountpoint), driver.mountOptions(metadata.Spec))
+			if err != nil {
+				return "", fmt.Errorf("failed to mount tmpfs of volume %s: %v", name, err)
+			}
+		}
+		ids = map[string]struct{}{}
+		driver.mounts[name] = ids
+	}
+
+	mountpoint, err := driver.builtin.Mount(name, id)
+	if err != nil {
+		if len(ids) == 0 {
+			if err := driver.umount(name, metadata); err != nil {
+				driver.logger.Errorf("failed to unmount tmpfs of volume %s: %v", name, err)
+			}
+		}
+		return "", err
+	}
+	ids[id] = struct{}{}
+	return mountpoint, nil
+}
+
+// Unmount unmounts the volume like the other builtin drivers and then unmounts its tmpfs on the last unmount, which discards the data.
+func (driver *tmpfs) Unmount(name string, id string) error {
+	if err := driver.builtin.Unmount(name, id); err != nil {
+		return err
+	}
+	metadata, err := driver.fetch(name)
+	if err != nil {
+		return err
+	}
+
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	ids := driver.mounts[name]
+	if _, existed := ids[id]; !existed {
+		return nil
+	}
+	delete(ids, id)
+	if len(ids) != 0 {
+		return nil
+	}
+	return driver.umount(name, metadata)
+}
+
+// umount unmounts the tmpfs of the volume.
+func (driver *tmpfs) umount(name string, metadata *apis.VolumeMetadata) error {
+	if !driver.mock {
+		if err := utils.Umount(path.Join(driver.rootPath, metadata.Status.Mountpoint)); err != nil {
+			return fmt.Errorf("failed to unmount tmpfs of volume %s: %v", name, err)
+		}
+	}
+	delete(driver.mounts, name)
+	return nil
+}
+
+// checkMigration refuses the migrations to tmpfs, since the data copied into an unmounted volume would be hidden by its tmpfs.
+func (driver *tmpfs) checkMigration(source bool) error {
+	if !source {
+		return fmt.Errorf("tmpfs only keeps the data while the volumes are mounted")
+	}
+	return nil
+}
+
+// Remove removes the volume, the mounted volumes can't be removed.
+func (driver *tmpfs) Remove(name string) error {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	if driver.mounts[name] != nil {
+		return fmt.Errorf("volume %s is mounted", name)
+	}
+	return driver.builtin.Remove(name)
+}
+
+// Destroy unmounts the tmpfs of all volumes and then destroys the builtin driver.
+func (driver *tmpfs) Destroy() error {
+	driver.mutex.Lock()
+	errs := []error{}
+	for name := range driver.mounts {
+		metadata, err := driver.fetch(name)
+		if err == nil {
+			err = driver.umount(name, metadata)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	driver.mutex.Unlock()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return driver.builtin.Destroy()
+}
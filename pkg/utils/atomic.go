@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// WriteFileAtomic writes the data to the file atomically, see CopyFileAtomic.
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	_, err := CopyFileAtomic(filePath, bytes.NewReader(data), perm)
+	return err
+}
+
+// CopyFileAtomic copies the content to a temporary file in the same directory, syncs it and renames it over the file,
+// so readers on any node see either the previous or the new content but never a truncated one.
+// The file isn't replaced if reading the content fails, it returns the number of bytes written.
+func CopyFileAtomic(filePath string, content io.Reader, perm os.FileMode) (written int64, err error) {
+	dir, name := path.Split(filePath)
+	file, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	if written, err = io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return written, fmt.Errorf("failed to write temporary file: %v", err)
+	}
+	if err = file.Chmod(perm); err != nil {
+		_ = file.Close()
+		return written, fmt.Errorf("failed to change mode of temporary file: %v", err)
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return written, fmt.Errorf("failed to sync temporary file: %v", err)
+	}
+	if err = file.Close(); err != nil {
+		return written, fmt.Errorf("failed to close temporary file: %v", err)
+	}
+
+	if err = os.Rename(file.Name(), filePath); err != nil {
+		return written, fmt.Errorf("failed to rename temporary file: %v", err)
+	}
+
+	// Sync the directory to persist the rename, it is not supported by every file system so the error is ignored
+	if dirFile, err := os.Open(path.Clean(dir)); err == nil {
+		_ = dirFile.Sync()
+		_ = dirFile.Close()
+	}
+
+	return written, nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CreateImage creates a sparse image file of the size, it fails if the file already exists.
+func CreateImage(imagePath string, size int64) error {
+	file, err := os.OpenFile(imagePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create image: %v", err)
+	}
+	err = file.Truncate(size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(imagePath)
+		return fmt.Errorf("failed to allocate image: %v", err)
+	}
+	return nil
+}
+
+// FormatImage creates a filesystem of the type, e.g. ext4 or xfs, in an image file.
+func FormatImage(imagePath string, fsType string, mkfsOptions []string) error {
+	args := append([]string{"-t", fsType}, mkfsOptions...)
+	cmd := exec.Command("mkfs", append(args, imagePath)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mkfs failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// MountLoop mounts an image file to a local path by a loop device.
+func MountLoop(imagePath string, localPath string, mountOptions []string) error {
+	// Create the mount point if it doesn't exist
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %v", err)
+	}
+
+	mountOptionsString := strings.Join(append([]string{"loop"}, mountOptions...), ",")
+	cmd := exec.Command("mount", "-o", mountOptionsString, imagePath, localPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// GrowLoop grows the image file mounted at the local path to the size and then its filesystem online, the image can't shrink.
+func GrowLoop(imagePath string, localPath string, size int64) error {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to get image: %v", err)
+	}
+	if size < info.Size() {
+		return fmt.Errorf("image of %d bytes can't shrink to %d bytes", info.Size(), size)
+	}
+	if size == info.Size() {
+		return nil
+	}
+
+	mount, err := FindMount(localPath)
+	if err != nil {
+		return err
+	}
+	if mount.Mountpoint != localPath || !strings.HasPrefix(mount.Source, "/dev/loop") {
+		return fmt.Errorf("image %s isn't loop mounted at %s", imagePath, localPath)
+	}
+
+	if err := os.Truncate(imagePath, size); err != nil {
+		return fmt.Errorf("failed to grow image: %v", err)
+	}
+	cmd := exec.Command("losetup", "-c", mount.Source)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("losetup failed: %v, output: %s", err, string(output))
+	}
+
+	switch mount.FSType {
+	case "xfs":
+		cmd = exec.Command("xfs_growfs", localPath)
+	case "ext2", "ext3", "ext4":
+		cmd = exec.Command("resize2fs", mount.Source)
+	default:
+		return fmt.Errorf("filesystem %s can't grow online", mount.FSType)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to grow filesystem: %v, output: %s", err, string(output))
+	}
+	return nil
+}
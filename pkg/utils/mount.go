@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/moby/sys/mountinfo"
@@ -66,6 +68,120 @@ func MountCIFS(address string, remotePath string, localPath string, username str
 	return nil
 }
 
+// MountSSHFS mounts a remote path over SSH to a local path, the host key must be in the known hosts file unless the mount options
+// disable StrictHostKeyChecking.
+func MountSSHFS(address string, port int, username string, remotePath string, localPath string, identityFile string, knownHostsFile string, mountOptions []string) error {
+	// Create the mount point if it doesn't exist
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %v", err)
+	}
+
+	cmd := exec.Command("sshfs", sshfsArgs(address, port, username, remotePath, localPath, identityFile, knownHostsFile, mountOptions)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// sshfsArgs returns the arguments of sshfs, which never prompts for passwords or unknown host keys.
+func sshfsArgs(address string, port int, username string, remotePath string, localPath string, identityFile string, knownHostsFile string, mountOptions []string) []string {
+	if strings.Contains(address, ":") {
+		address = "[" + address + "]"
+	}
+	if len(username) != 0 {
+		address = username + "@" + address
+	}
+
+	// ssh uses the first value of each option, so the mount options precede the defaults to override them
+	options := append([]string{}, mountOptions...)
+	if len(identityFile) != 0 {
+		options = append(options, "IdentityFile="+identityFile)
+	}
+	if len(knownHostsFile) != 0 {
+		options = append(options, "UserKnownHostsFile="+knownHostsFile)
+	}
+	options = append(options, "BatchMode=yes", "StrictHostKeyChecking=yes")
+
+	args := []string{fmt.Sprintf("%s:%s", address, remotePath), localPath, "-o", strings.Join(options, ",")}
+	if port != 0 {
+		args = append(args, "-p", strconv.Itoa(port))
+	}
+	return args
+}
+
+// MountS3FS mounts a bucket or a prefix of it to a local path by s3fs.
+func MountS3FS(bucket string, prefix string, localPath string, endpoint string, region string, credentialsFile string, pathStyle bool, mountOptions []string) error {
+	// Create the mount point if it doesn't exist
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %v", err)
+	}
+
+	cmd := exec.Command("s3fs", s3fsArgs(bucket, prefix, localPath, endpoint, region, credentialsFile, pathStyle, mountOptions)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// s3fsArgs returns the arguments of s3fs, the region is the endpoint option of s3fs which signs the requests.
+func s3fsArgs(bucket string, prefix string, localPath string, endpoint string, region string, credentialsFile string, pathStyle bool, mountOptions []string) []string {
+	if prefix = strings.Trim(prefix, "/"); len(prefix) != 0 {
+		bucket = bucket + ":/" + prefix
+	}
+
+	options := []string{}
+	if len(endpoint) != 0 {
+		options = append(options, "url="+endpoint)
+	}
+	if len(region) != 0 {
+		options = append(options, "endpoint="+region)
+	}
+	if len(credentialsFile) != 0 {
+		options = append(options, "passwd_file="+credentialsFile)
+	}
+	if pathStyle {
+		options = append(options, "use_path_request_style")
+	}
+	options = append(options, mountOptions...)
+
+	args := []string{bucket, localPath}
+	if len(options) != 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	return args
+}
+
+// MountTmpfs mounts a tmpfs to a local path.
+func MountTmpfs(localPath string, mountOptions []string) error {
+	// Create the mount point if it doesn't exist
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %v", err)
+	}
+
+	if len(mountOptions) == 0 {
+		mountOptions = []string{"defaults"}
+	}
+
+	cmd := exec.Command("mount", "-t", "tmpfs", "-o", strings.Join(mountOptions, ","), "tmpfs", localPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// Remount changes the options of a mounted local path.
+func Remount(localPath string, mountOptions []string) error {
+	cmd := exec.Command("mount", "-o", strings.Join(append([]string{"remount"}, mountOptions...), ","), localPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("remount failed: %v, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
 // MountMock simulates mounting by creating the mount point directory without performing an actual mount.
 func MountMock(localPath string) error {
 	// Create the mount point if it doesn't exist
@@ -89,3 +205,31 @@ func Umount(localPath string) error {
 func IsMounted(path string) (bool, error) {
 	return mountinfo.Mounted(path)
 }
+
+// FindMount returns the mount which the path is on.
+func FindMount(path string) (*mountinfo.Info, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %v", err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %v", err)
+	}
+
+	mounts, err := mountinfo.GetMounts(mountinfo.ParentsFilter(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mounts: %v", err)
+	}
+	// The mounts are listed in the order they were mounted, so the last one of the deepest mount point is visible
+	var found *mountinfo.Info
+	for _, mount := range mounts {
+		if found == nil || len(mount.Mountpoint) >= len(found.Mountpoint) {
+			found = mount
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no mount found for %s", path)
+	}
+	return found, nil
+}
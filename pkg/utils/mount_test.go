@@ -1,7 +1,25 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,3 +36,441 @@ func TestIsMounted(t *testing.T) {
 	_, err = IsMounted("/non-exist")
 	assert.Error(t, err)
 }
+
+func TestFindMount(t *testing.T) {
+	mount, err := FindMount("/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/", mount.Mountpoint)
+
+	mount, err = FindMount("/bin")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, mount.Mountpoint)
+
+	_, err = FindMount("/non-exist")
+	assert.Error(t, err)
+}
+
+func TestSSHFSArgs(t *testing.T) {
+	cases := []struct {
+		address        string
+		port           int
+		username       string
+		identityFile   string
+		knownHostsFile string
+		mountOptions   []string
+		expected       []string
+	}{
+		{
+			address:  "ssh-server.example.com",
+			expected: []string{"ssh-server.example.com:/export", "/mnt", "-o", "BatchMode=yes,StrictHostKeyChecking=yes"},
+		},
+		{
+			address:        "ssh-server.example.com",
+			port:           2222,
+			username:       "user",
+			identityFile:   "/keys/id_ed25519",
+			knownHostsFile: "/keys/known_hosts",
+			mountOptions:   []string{"reconnect"},
+			expected: []string{"user@ssh-server.example.com:/export", "/mnt", "-o",
+				"reconnect,IdentityFile=/keys/id_ed25519,UserKnownHostsFile=/keys/known_hosts,BatchMode=yes,StrictHostKeyChecking=yes", "-p", "2222"},
+		},
+		{
+			address:      "fd00::1",
+			username:     "user",
+			mountOptions: []string{"StrictHostKeyChecking=no"},
+			expected:     []string{"user@[fd00::1]:/export", "/mnt", "-o", "StrictHostKeyChecking=no,BatchMode=yes,StrictHostKeyChecking=yes"},
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, sshfsArgs(c.address, c.port, c.username, "/export", "/mnt", c.identityFile, c.knownHostsFile, c.mountOptions))
+	}
+}
+
+// requireCommands skips the test unless it runs as root with the commands installed, which the FUSE mounts need.
+func requireCommands(t *testing.T, commands ...string) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	for _, command := range commands {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s is not installed", command)
+		}
+	}
+}
+
+// runCommand runs the command and fails the test if it fails.
+func runCommand(t *testing.T, name string, args ...string) {
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		t.Fatalf("failed to run %s: %v, output: %s", name, err, string(output))
+	}
+}
+
+// startSSHD starts an sshd on a free local port, which accepts the user key of the directory and serves sftp, and returns the port.
+func startSSHD(t *testing.T, dir string) int {
+	sshd, err := exec.LookPath("sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	config := fmt.Sprintf(`ListenAddress 127.0.0.1:%d
+HostKey %s
+AuthorizedKeysFile %s
+PidFile %s
+StrictModes no
+UsePAM no
+PasswordAuthentication no
+PermitRootLogin prohibit-password
+Subsystem sftp internal-sftp
+`, port, filepath.Join(dir, "host_key"), filepath.Join(dir, "user_key.pub"), filepath.Join(dir, "sshd.pid"))
+	configFile := filepath.Join(dir, "sshd_config")
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Some distributions don't create the privilege separation directory until sshd is started as a service
+	if err := os.MkdirAll("/run/sshd", 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(sshd, "-D", "-e", "-f", configFile)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			_ = conn.Close()
+			return port
+		}
+	}
+	t.Fatal("sshd didn't start listening")
+	return 0
+}
+
+func TestMountSSHFS(t *testing.T) {
+	requireCommands(t, "sshfs", "sshd", "ssh-keygen")
+
+	dir := t.TempDir()
+	for _, key := range []string{"host_key", "user_key", "unknown_key"} {
+		runCommand(t, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", filepath.Join(dir, key))
+	}
+	port := startSSHD(t, dir)
+	current, err := user.Current()
+	assert.NoError(t, err)
+
+	hostKey, err := os.ReadFile(filepath.Join(dir, "host_key.pub"))
+	assert.NoError(t, err)
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	assert.NoError(t, os.WriteFile(knownHostsFile, []byte(fmt.Sprintf("[127.0.0.1]:%d %s", port, hostKey)), 0644))
+	emptyKnownHostsFile := filepath.Join(dir, "empty_known_hosts")
+	assert.NoError(t, os.WriteFile(emptyKnownHostsFile, []byte{}, 0644))
+
+	remotePath := t.TempDir()
+	cases := []struct {
+		description    string
+		identityFile   string
+		knownHostsFile string
+		expectErr      bool
+	}{
+		{
+			description:    "unknown host key",
+			identityFile:   filepath.Join(dir, "user_key"),
+			knownHostsFile: emptyKnownHostsFile,
+			expectErr:      true,
+		},
+		{
+			description:    "unauthorized user key",
+			identityFile:   filepath.Join(dir, "unknown_key"),
+			knownHostsFile: knownHostsFile,
+			expectErr:      true,
+		},
+		{
+			description:    "authorized user key",
+			identityFile:   filepath.Join(dir, "user_key"),
+			knownHostsFile: knownHostsFile,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			// The batch mode fails the mount instead of prompting for a password or the host key
+			localPath := t.TempDir()
+			err := MountSSHFS("127.0.0.1", port, current.Username, remotePath, localPath, c.identityFile, c.knownHostsFile, nil)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			assert.NoError(t, os.WriteFile(filepath.Join(localPath, "test"), []byte("data"), 0644))
+			assert.NoError(t, Umount(localPath))
+			data, err := os.ReadFile(filepath.Join(remotePath, "test"))
+			assert.NoError(t, err)
+			assert.Equal(t, "data", string(data))
+		})
+	}
+}
+
+func TestS3FSArgs(t *testing.T) {
+	cases := []struct {
+		bucket          string
+		prefix          string
+		endpoint        string
+		region          string
+		credentialsFile string
+		pathStyle       bool
+		mountOptions    []string
+		expected        []string
+	}{
+		{
+			bucket:   "volumes",
+			expected: []string{"volumes", "/mnt"},
+		},
+		{
+			bucket:          "artifacts",
+			prefix:          "/cluster-a/volumes/",
+			endpoint:        "http://minio.example.com:9000",
+			region:          "eu-west-1",
+			credentialsFile: "/etc/passwd-s3fs",
+			pathStyle:       true,
+			mountOptions:    []string{"allow_other"},
+			expected: []string{"artifacts:/cluster-a/volumes", "/mnt", "-o",
+				"url=http://minio.example.com:9000,endpoint=eu-west-1,passwd_file=/etc/passwd-s3fs,use_path_request_style,allow_other"},
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, s3fsArgs(c.bucket, c.prefix, "/mnt", c.endpoint, c.region, c.credentialsFile, c.pathStyle, c.mountOptions))
+	}
+}
+
+// fakeS3FS is an in-memory S3 endpoint which serves the requests of s3fs, it only accepts the access key and the bucket in the path.
+type fakeS3FS struct {
+	bucket    string
+	accessKey string
+	objects   map[string]*fakeObject
+	mutex     sync.Mutex
+}
+
+type fakeObject struct {
+	data     []byte
+	header   http.Header
+	modified time.Time
+}
+
+type fakeListBucketResult struct {
+	XMLName        xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string
+	Prefix         string
+	Marker         string
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []fakeListEntry
+	CommonPrefixes []fakeCommonPrefix
+}
+
+type fakeListEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type fakeCommonPrefix struct {
+	Prefix string
+}
+
+func (server *fakeS3FS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !strings.Contains(r.Header.Get("Authorization"), server.accessKey) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	bucketPath := "/" + server.bucket
+	if r.URL.Path != bucketPath && !strings.HasPrefix(r.URL.Path, bucketPath+"/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPath), "/")
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Has("location"):
+		_, _ = io.WriteString(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+	case key == "" && r.Method == http.MethodGet:
+		server.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+	case key == "" && r.Method == http.MethodHead:
+	case r.Method == http.MethodPut:
+		server.put(w, r, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, existed := server.objects[key]
+		if !existed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", etag(object.data))
+		http.ServeContent(w, r, "", object.modified, bytes.NewReader(object.data))
+	case r.Method == http.MethodDelete:
+		delete(server.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (server *fakeS3FS) list(w http.ResponseWriter, prefix string, delimiter string) {
+	result := &fakeListBucketResult{Name: server.bucket, Prefix: prefix, MaxKeys: 1000}
+	keys := make([]string, 0, len(server.objects))
+	for key := range server.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	prefixes := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if index := strings.Index(key[len(prefix):], delimiter); delimiter != "" && index >= 0 {
+			commonPrefix := key[:len(prefix)+index+len(delimiter)]
+			if !prefixes[commonPrefix] {
+				prefixes[commonPrefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, fakeCommonPrefix{Prefix: commonPrefix})
+			}
+			continue
+		}
+		object := server.objects[key]
+		result.Contents = append(result.Contents, fakeListEntry{
+			Key:          key,
+			LastModified: object.modified.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         etag(object.data),
+			Size:         len(object.data),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// put stores the object from the body, or copies it from the x-amz-copy-source object, which s3fs does to change the metadata.
+func (server *fakeS3FS) put(w http.ResponseWriter, r *http.Request, key string) {
+	object := &fakeObject{header: http.Header{}, modified: time.Now()}
+	if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+		source, _ = url.PathUnescape(source)
+		sourceObject, existed := server.objects[strings.TrimPrefix(strings.TrimPrefix(source, "/"), server.bucket+"/")]
+		if !existed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		object.data = sourceObject.data
+		object.header = sourceObject.header
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			object.header = objectHeader(r.Header)
+		}
+		server.objects[key] = object
+		_, _ = fmt.Fprintf(w, "<CopyObjectResult><LastModified>%s</LastModified><ETag>%s</ETag></CopyObjectResult>",
+			object.modified.UTC().Format("2006-01-02T15:04:05.000Z"), etag(object.data))
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	object.data = data
+	object.header = objectHeader(r.Header)
+	server.objects[key] = object
+	w.Header().Set("ETag", etag(data))
+}
+
+// objectHeader returns the headers of the request which are stored with the object.
+func objectHeader(header http.Header) http.Header {
+	objectHeader := http.Header{}
+	for name, values := range header {
+		if name == "Content-Type" || strings.HasPrefix(name, "X-Amz-Meta-") {
+			objectHeader[name] = values
+		}
+	}
+	return objectHeader
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestMountS3FS(t *testing.T) {
+	requireCommands(t, "s3fs")
+
+	// A MinIO or another S3 compatible endpoint can be tested instead of the stand-in
+	endpoint, bucket := os.Getenv("S3_TEST_ENDPOINT"), os.Getenv("S3_TEST_BUCKET")
+	accessKey, secretKey := os.Getenv("S3_TEST_ACCESS_KEY"), os.Getenv("S3_TEST_SECRET_KEY")
+	if endpoint == "" {
+		bucket, accessKey, secretKey = "volumes", "access", "secret"
+		server := httptest.NewServer(&fakeS3FS{bucket: bucket, accessKey: accessKey, objects: map[string]*fakeObject{}})
+		defer server.Close()
+		endpoint = server.URL
+	}
+
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "passwd-s3fs")
+	assert.NoError(t, os.WriteFile(credentialsFile, []byte(accessKey+":"+secretKey), 0600))
+	wrongCredentialsFile := filepath.Join(dir, "wrong-passwd-s3fs")
+	assert.NoError(t, os.WriteFile(wrongCredentialsFile, []byte("wrong:wrong"), 0600))
+
+	cases := []struct {
+		description     string
+		credentialsFile string
+		expectErr       bool
+	}{
+		{
+			description:     "wrong credentials",
+			credentialsFile: wrongCredentialsFile,
+			expectErr:       true,
+		},
+		{
+			description:     "credentials",
+			credentialsFile: credentialsFile,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			localPath := t.TempDir()
+			err := MountS3FS(bucket, "", localPath, endpoint, "", c.credentialsFile, true, nil)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			data := []byte(time.Now().String())
+			assert.NoError(t, os.WriteFile(filepath.Join(localPath, "test"), data, 0644))
+			assert.NoError(t, Umount(localPath))
+
+			// Test the object is read back from the bucket by another mount
+			localPath = t.TempDir()
+			assert.NoError(t, MountS3FS(bucket, "", localPath, endpoint, "", c.credentialsFile, true, nil))
+			actual, err := os.ReadFile(filepath.Join(localPath, "test"))
+			assert.NoError(t, err)
+			assert.Equal(t, data, actual)
+			assert.NoError(t, os.Remove(filepath.Join(localPath, "test")))
+			assert.NoError(t, Umount(localPath))
+		})
+	}
+}
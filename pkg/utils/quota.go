@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CheckProjectQuota checks the path is on an XFS filesystem mounted with project quotas.
+func CheckProjectQuota(path string) error {
+	mount, err := FindMount(path)
+	if err != nil {
+		return err
+	}
+	if mount.FSType != "xfs" {
+		return fmt.Errorf("%s is on a %s filesystem, project quotas require xfs", path, mount.FSType)
+	}
+	for _, option := range strings.Split(mount.VFSOptions, ",") {
+		if option == "prjquota" || option == "pquota" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is mounted without project quotas, mount it with the prjquota option", mount.Mountpoint)
+}
+
+// SetProjectQuota assigns the directory and its content to the XFS project and limits the project to the size, zero means unlimited.
+func SetProjectQuota(directoryPath string, projectID uint32, size int64) error {
+	mount, err := FindMount(directoryPath)
+	if err != nil {
+		return err
+	}
+
+	return runXFSQuota(mount.Mountpoint,
+		fmt.Sprintf("project -s -p %s %d", directoryPath, projectID),
+		fmt.Sprintf("limit -p bhard=%d %d", size, projectID),
+	)
+}
+
+// ClearProjectQuota removes the directory and its content from the XFS project and lifts the limit of the project.
+func ClearProjectQuota(directoryPath string, projectID uint32) error {
+	mount, err := FindMount(directoryPath)
+	if err != nil {
+		return err
+	}
+
+	return runXFSQuota(mount.Mountpoint,
+		fmt.Sprintf("project -C -p %s %d", directoryPath, projectID),
+		fmt.Sprintf("limit -p bhard=0 %d", projectID),
+	)
+}
+
+func runXFSQuota(mountpoint string, commands ...string) error {
+	for _, command := range commands {
+		cmd := exec.Command("xfs_quota", "-x", "-c", command, mountpoint)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("xfs_quota failed: %v, output: %s", err, string(output))
+		}
+	}
+	return nil
+}